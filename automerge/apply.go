@@ -7,7 +7,6 @@ import (
 
 	intapply "github.com/cjanietz/automerge-native-go/internal/apply"
 	"github.com/cjanietz/automerge-native-go/internal/changegraph"
-	"github.com/cjanietz/automerge-native-go/internal/model"
 	"github.com/cjanietz/automerge-native-go/internal/opset"
 )
 
@@ -16,11 +15,28 @@ var (
 )
 
 func (d *Document) ApplyChanges(changes []Change) error {
-	return d.ApplyChangesWithActorMap(changes, nil)
+	return d.ApplyChangesWithActorMap(changes, nil, nil)
 }
 
-func (d *Document) ApplyChangesWithActorMap(changes []Change, actorMap map[uint32]uint32) error {
-	ready := make(map[model.ChangeHash]struct{})
+// ApplyChangesWithActorMap applies changes after renaming their actors through
+// actorMap. Both actorMap and log may be nil; an active log receives the
+// patches of every applied operation.
+//
+// While a transaction is open the changes are held and applied when it
+// commits or rolls back, so the transaction keeps reading the state it began
+// with. FlushHeld reports any error applying them.
+func (d *Document) ApplyChangesWithActorMap(changes []Change, actorMap map[uint32]uint32, log *PatchLog) error {
+	// The pending ops of an open transaction are already applied and must
+	// stay last in the op log so Rollback can drop them.
+	if d.open != nil {
+		held := make([]Change, len(changes))
+		for i, c := range changes {
+			held[i] = deepCopyChange(remapChangeActors(c, actorMap))
+		}
+		d.held = append(d.held, heldChanges{changes: held, log: log})
+		return nil
+	}
+	ready := make(map[ChangeHash]struct{})
 	batch := make([]Change, 0, len(changes))
 
 	for _, c := range changes {
@@ -51,18 +67,28 @@ func (d *Document) ApplyChangesWithActorMap(changes []Change, actorMap map[uint3
 		ready[next.Hash] = struct{}{}
 	}
 
+	beforeHeads := d.Heads()
+	log, logStart := d.observeLog(log)
+	var applied []ChangeHash
+	// Subscribers hear about the changes that made it in, even when a later
+	// one fails.
+	defer func() { d.notify(EventRemoteChanges, applied, beforeHeads, log.since(logStart)) }()
 	for _, idx := range orderChangeIndicesTopologically(batch) {
 		c := batch[idx]
 		if d.hasChange(c.Hash) {
 			continue
 		}
-		if err := d.applyOneChange(c); err != nil {
+		if err := d.applyOneChange(c, log); err != nil {
 			return err
 		}
+		applied = append(applied, c.Hash)
 	}
+	log.setHeads(d.Heads())
 	return nil
 }
 
+// Merge applies the changes of other that d lacks. Like ApplyChanges, it holds
+// them until an open transaction closes.
 func (d *Document) Merge(other *Document) error {
 	hashes := d.getChangesAdded(other)
 	changes := make([]Change, 0, len(hashes))
@@ -73,13 +99,13 @@ func (d *Document) Merge(other *Document) error {
 		}
 		changes = append(changes, c)
 	}
-	return d.ApplyChangesWithActorMap(changes, nil)
+	return d.ApplyChangesWithActorMap(changes, nil, nil)
 }
 
-func (d *Document) getChangesAdded(other *Document) []model.ChangeHash {
+func (d *Document) getChangesAdded(other *Document) []ChangeHash {
 	stack := other.Heads()
-	seen := make(map[model.ChangeHash]struct{})
-	out := make([]model.ChangeHash, 0)
+	seen := make(map[ChangeHash]struct{})
+	out := make([]ChangeHash, 0)
 
 	for len(stack) > 0 {
 		h := stack[len(stack)-1]
@@ -103,7 +129,7 @@ func (d *Document) getChangesAdded(other *Document) []model.ChangeHash {
 	return out
 }
 
-func (d *Document) isCausallyReady(c Change, ready map[model.ChangeHash]struct{}) bool {
+func (d *Document) isCausallyReady(c Change, ready map[ChangeHash]struct{}) bool {
 	for _, dep := range c.Deps {
 		if d.hasChange(dep) {
 			continue
@@ -116,7 +142,7 @@ func (d *Document) isCausallyReady(c Change, ready map[model.ChangeHash]struct{}
 	return true
 }
 
-func (d *Document) popNextCausallyReady(ready map[model.ChangeHash]struct{}) (Change, bool) {
+func (d *Document) popNextCausallyReady(ready map[ChangeHash]struct{}) (Change, bool) {
 	for i := 0; i < len(d.queue); i++ {
 		c := d.queue[i]
 		if !d.isCausallyReady(c, ready) {
@@ -129,10 +155,27 @@ func (d *Document) popNextCausallyReady(ready map[model.ChangeHash]struct{}) (Ch
 	return Change{}, false
 }
 
-func (d *Document) applyOneChange(c Change) error {
+// applyOneChange applies the ops of c and records it. A change that fails
+// partway is reverted along with its patches, so it is applied whole or not
+// at all.
+func (d *Document) applyOneChange(c Change, log *PatchLog) error {
+	before := d.ops.Checkpoint()
+	logged := 0
+	if log.IsActive() {
+		logged = len(log.patches)
+	}
+	undo := func(err error) error {
+		d.ops.Revert(before)
+		if log.IsActive() {
+			log.patches = log.patches[:logged]
+		}
+		return err
+	}
 	for _, op := range c.Operations {
-		if err := applyChangeOperation(d.ops, c.Actor, op); err != nil {
-			return err
+		if err := d.recordOp(log, op.ObjID, func() error {
+			return applyChangeOperation(d.ops, c.Actor, op)
+		}); err != nil {
+			return undo(err)
 		}
 	}
 	if err := d.graph.AddChange(changegraph.ChangeMeta{
@@ -142,7 +185,7 @@ func (d *Document) applyOneChange(c Change) error {
 		Seq:   c.Seq,
 		MaxOp: c.MaxOp,
 	}); err != nil {
-		return fmt.Errorf("graph add change: %w", err)
+		return undo(fmt.Errorf("graph add change: %w", err))
 	}
 	cp := deepCopyChange(c)
 	d.changes[c.Hash] = cp
@@ -152,6 +195,9 @@ func (d *Document) applyOneChange(c Change) error {
 }
 
 func applyChangeOperation(ops *opset.OpSet, actor uint32, op ChangeOperation) error {
+	if op.Identified && op.Kind != OpMark && op.Kind != OpIncrement {
+		return applyIdentifiedOperation(ops, actor, op)
+	}
 	seq := op.OpID.Counter
 	switch op.Kind {
 	case OpPut:
@@ -178,7 +224,40 @@ func applyChangeOperation(ops *opset.OpSet, actor uint32, op ChangeOperation) er
 		_, err := ops.SpliceText(op.ObjID, op.Index, op.DeleteCount, op.InsertText, actor, start)
 		return err
 	case OpMark:
-		return ops.AddMark(op.ObjID, op.Start, op.End, op.MarkName, op.Value, op.OpID, actor, seq)
+		if op.Identified {
+			return ops.AddMarkAnchored(op.ObjID, op.StartAnchor, op.EndAnchor, op.MarkName, op.Value, op.Expand, op.OpID, actor, seq)
+		}
+		return ops.AddMark(op.ObjID, op.Start, op.End, op.MarkName, op.Value, op.Expand, op.OpID, actor, seq)
+	default:
+		return fmt.Errorf("unknown change operation kind %d", op.Kind)
+	}
+}
+
+// applyIdentifiedOperation applies an op that names its elements and
+// predecessors, so it lands on the same ones as on the peer that made it.
+func applyIdentifiedOperation(ops *opset.OpSet, actor uint32, op ChangeOperation) error {
+	seq := op.OpID.Counter
+	switch op.Kind {
+	case OpPut:
+		return ops.PutMapRaw(op.ObjID, op.Key, opset.NewScalarValue(op.Value), op.OpID, actor, seq, op.Pred)
+	case OpPutObject:
+		ops.CreateObject(op.ChildObjID, opset.ObjType(op.ObjType))
+		return ops.PutMapRaw(op.ObjID, op.Key, opset.NewObjectValue(op.ChildObjID, opset.ObjType(op.ObjType)), op.OpID, actor, seq, op.Pred)
+	case OpInsert:
+		_, err := ops.InsertListAfter(op.ObjID, op.Elem, opset.NewScalarValue(op.Value), op.OpID, actor, seq)
+		return err
+	case OpInsertObject:
+		ops.CreateObject(op.ChildObjID, opset.ObjType(op.ObjType))
+		_, err := ops.InsertListAfter(op.ObjID, op.Elem, opset.NewObjectValue(op.ChildObjID, opset.ObjType(op.ObjType)), op.OpID, actor, seq)
+		return err
+	case OpDeleteMap:
+		return ops.DeleteMapRaw(op.ObjID, op.Key, op.OpID, actor, seq, op.Pred)
+	case OpDeleteList:
+		_, err := ops.DeleteListElem(op.ObjID, op.Elem, op.OpID, actor, seq)
+		return err
+	case OpSpliceText:
+		_, err := ops.SpliceTextAfter(op.ObjID, op.Deleted, op.Elem, op.InsertText, actor, seq-1)
+		return err
 	default:
 		return fmt.Errorf("unknown change operation kind %d", op.Kind)
 	}
@@ -192,7 +271,7 @@ func orderChangeIndicesTopologically(in []Change) []int {
 		}
 		return out
 	}
-	indexByHash := make(map[model.ChangeHash]int, len(in))
+	indexByHash := make(map[ChangeHash]int, len(in))
 	for i, c := range in {
 		indexByHash[c.Hash] = i
 	}
@@ -289,11 +368,33 @@ func (h *changeIndexHeap) Pop() int {
 
 func deepCopyChange(c Change) Change {
 	cp := c
-	cp.Deps = append([]model.ChangeHash(nil), c.Deps...)
+	cp.Deps = append([]ChangeHash(nil), c.Deps...)
 	cp.Operations = append([]ChangeOperation(nil), c.Operations...)
 	return cp
 }
 
+// remapElem renames the actor of an element reference, leaving the zero OpID
+// that stands for the start or end of a sequence alone.
+func remapElem(id OpID, actorMap map[uint32]uint32) OpID {
+	if id == (OpID{}) {
+		return id
+	}
+	return intapply.RemapActor(id, actorMap)
+}
+
+// remapOpIDs returns a remapped copy of ids, leaving the slice shared with
+// the original change untouched.
+func remapOpIDs(ids []OpID, actorMap map[uint32]uint32) []OpID {
+	if ids == nil {
+		return nil
+	}
+	out := make([]OpID, len(ids))
+	for i, id := range ids {
+		out[i] = intapply.RemapActor(id, actorMap)
+	}
+	return out
+}
+
 func remapChangeActors(c Change, actorMap map[uint32]uint32) Change {
 	if actorMap == nil {
 		return c
@@ -306,6 +407,11 @@ func remapChangeActors(c Change, actorMap map[uint32]uint32) Change {
 		cp.Operations[i].OpID = intapply.RemapActor(cp.Operations[i].OpID, actorMap)
 		cp.Operations[i].ObjID = intapply.RemapObjID(cp.Operations[i].ObjID, actorMap)
 		cp.Operations[i].ChildObjID = intapply.RemapObjID(cp.Operations[i].ChildObjID, actorMap)
+		cp.Operations[i].StartAnchor.Elem = remapElem(cp.Operations[i].StartAnchor.Elem, actorMap)
+		cp.Operations[i].EndAnchor.Elem = remapElem(cp.Operations[i].EndAnchor.Elem, actorMap)
+		cp.Operations[i].Elem = remapElem(cp.Operations[i].Elem, actorMap)
+		cp.Operations[i].Deleted = remapOpIDs(cp.Operations[i].Deleted, actorMap)
+		cp.Operations[i].Pred = remapOpIDs(cp.Operations[i].Pred, actorMap)
 	}
 	return cp
 }
@@ -40,7 +40,7 @@ func TestApplyChangesQueueDrainOutOfOrder(t *testing.T) {
 	if gotQueue := len(target.queue); gotQueue != 0 {
 		t.Fatalf("expected empty queue after drain, got %d", gotQueue)
 	}
-	v, ok := target.GetMap(model.RootObjID(), "k", nil)
+	v, ok := target.GetMap(model.RootObjID(), "k")
 	if !ok || v.Scalar.String != "v2" {
 		t.Fatalf("unexpected merged value: %#v ok=%v", v, ok)
 	}
@@ -92,10 +92,10 @@ func TestMergeChangesAddedTraversal(t *testing.T) {
 	if err := doc1.Merge(doc2); err != nil {
 		t.Fatal(err)
 	}
-	if _, ok := doc1.GetMap(model.RootObjID(), "a", nil); !ok {
+	if _, ok := doc1.GetMap(model.RootObjID(), "a"); !ok {
 		t.Fatal("missing local key after merge")
 	}
-	if v, ok := doc1.GetMap(model.RootObjID(), "b", nil); !ok || v.Scalar.String != "2" {
+	if v, ok := doc1.GetMap(model.RootObjID(), "b"); !ok || v.Scalar.String != "2" {
 		t.Fatalf("missing merged key b: %#v ok=%v", v, ok)
 	}
 }
@@ -127,8 +127,8 @@ func TestRandomizedPermutedApplyConverges(t *testing.T) {
 	if got, want := len(target.Heads()), len(source.Heads()); got != want {
 		t.Fatalf("head count mismatch: got %d want %d", got, want)
 	}
-	srcV, _ := source.GetMap(model.RootObjID(), "k", nil)
-	tgtV, _ := target.GetMap(model.RootObjID(), "k", nil)
+	srcV, _ := source.GetMap(model.RootObjID(), "k")
+	tgtV, _ := target.GetMap(model.RootObjID(), "k")
 	if !srcV.Equal(tgtV) {
 		t.Fatalf("state diverged: source=%#v target=%#v", srcV, tgtV)
 	}
@@ -137,10 +137,10 @@ func TestRandomizedPermutedApplyConverges(t *testing.T) {
 func TestApplyChangesWithActorMap(t *testing.T) {
 	c := makeSinglePutChange(t, 1, "mapped", "yes")
 	target := NewDocument()
-	if err := target.ApplyChangesWithActorMap([]Change{c}, map[uint32]uint32{1: 9}); err != nil {
+	if err := target.ApplyChangesWithActorMap([]Change{c}, map[uint32]uint32{1: 9}, nil); err != nil {
 		t.Fatal(err)
 	}
-	clk, err := target.ClockForHeads(target.Heads())
+	clk, err := target.clockForHeads(target.Heads())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -148,3 +148,51 @@ func TestApplyChangesWithActorMap(t *testing.T) {
 		t.Fatalf("expected remapped actor seq, got %d", got)
 	}
 }
+
+func TestMergeToleratesConcurrentlyDeletedIndexes(t *testing.T) {
+	a := NewDocument()
+	var text ObjID
+	commitTx(t, a, func(tx *Transaction) error {
+		text, _ = tx.PutObject(RootObjID(), "text", ObjText)
+		return tx.SpliceText(text, 0, 0, "ab")
+	})
+	b, err := a.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = b.SetActor(2)
+	commitTx(t, a, func(tx *Transaction) error { return tx.SpliceText(text, 0, 2, "") })
+	commitTx(t, b, func(tx *Transaction) error { return tx.SpliceText(text, 2, 0, "X") })
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if err := b.Merge(a); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if a.Text(text) != "X" || b.Text(text) != "X" {
+		t.Fatalf("peers diverged: %q vs %q", a.Text(text), b.Text(text))
+	}
+}
+
+func TestApplyChangesRevertsChangeThatFailsPartway(t *testing.T) {
+	c := makeSinglePutChange(t, 2, "k", "v")
+	c.Operations = append(c.Operations, ChangeOperation{
+		Kind:  OpIncrement,
+		ObjID: RootObjID(),
+		Key:   "missing",
+		By:    1,
+		OpID:  OpID{Counter: c.StartOp + 1, Actor: 2},
+	})
+	d := NewDocument()
+	log := ActivePatchLog()
+	if err := d.ApplyChangesWithActorMap([]Change{c}, nil, log); err == nil {
+		t.Fatal("expected the increment to fail")
+	}
+	if _, ok := d.GetMap(RootObjID(), "k"); ok || len(d.Heads()) != 0 {
+		t.Fatal("the failed change left its first op applied")
+	}
+	if patches := log.MakePatches(); len(patches) != 0 {
+		t.Fatalf("the failed change left patches: %#v", patches)
+	}
+}
@@ -0,0 +1,401 @@
+package automerge
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/cjanietz/automerge-native-go/internal/model"
+	"github.com/cjanietz/automerge-native-go/internal/opset"
+	inttext "github.com/cjanietz/automerge-native-go/internal/text"
+)
+
+var (
+	ErrApplyPatchTarget = errors.New("apply patch invalid target")
+	ErrApplyPatchPath   = errors.New("apply patch path not found")
+	ErrApplyPatchType   = errors.New("apply patch type mismatch")
+)
+
+type ApplyPatchesOptions struct {
+	// TextEncoding must match the DiffOptions.TextEncoding the patches were
+	// made with.
+	TextEncoding Encoding
+}
+
+func DefaultApplyPatchesOptions() ApplyPatchesOptions {
+	return ApplyPatchesOptions{TextEncoding: EncodingUTF8}
+}
+
+// ApplyPatches updates a plain Go snapshot of the document root with patches,
+// so a read model can follow Diff or DiffIncremental output without exporting
+// the whole document again.
+//
+// target is a map[string]any or a pointer to a map, a slice or a struct.
+// Below it, maps, lists and text objects may be map[string]any, []any and
+// string, or typed maps, slices, structs and pointers to them. Struct fields
+// are matched by their json tag name, or else by their field name ignoring
+// case. New objects start empty and are filled by the patches that follow.
+// Scalars are stored as nil, []byte, string, int64, uint64, float64, bool and
+// time.Time (counters as int64) in untyped slots, and converted to the field
+// type between numeric kinds. Mark patches are ignored.
+func ApplyPatches(target any, patches []Patch) error {
+	return ApplyPatchesWithOptions(target, patches, DefaultApplyPatchesOptions())
+}
+
+func ApplyPatchesWithOptions(target any, patches []Patch, opts ApplyPatchesOptions) error {
+	root := reflect.ValueOf(target)
+	switch {
+	case root.Kind() == reflect.Map && !root.IsNil():
+		// Maps are references; wrap it so the root is settable like any other slot.
+		cp := reflect.New(root.Type()).Elem()
+		cp.Set(root)
+		root = cp
+	case root.Kind() == reflect.Pointer && !root.IsNil():
+		root = root.Elem()
+	default:
+		return fmt.Errorf("%w: %T", ErrApplyPatchTarget, target)
+	}
+	a := patchApplier{opts: opts}
+	for _, p := range patches {
+		props := make([]Prop, 0, len(p.Path))
+		for _, el := range p.Path {
+			props = append(props, el.Prop)
+		}
+		if err := a.apply(root, props, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type patchApplier struct {
+	opts ApplyPatchesOptions
+}
+
+// apply walks props down from the settable slot v and applies p to the
+// container at the end. Map entries and interface values are not addressable,
+// so they are copied into a fresh slot, updated and stored back.
+func (a patchApplier) apply(v reflect.Value, props []Prop, p Patch) error {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("%w: nil at %s", ErrApplyPatchPath, jsonPointer(p.Path))
+		}
+		elem := v.Elem()
+		cp := reflect.New(elem.Type()).Elem()
+		cp.Set(elem)
+		if err := a.apply(cp, props, p); err != nil {
+			return err
+		}
+		v.Set(cp)
+		return nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return a.apply(v.Elem(), props, p)
+	}
+	if len(props) == 0 {
+		return a.applyHere(v, p)
+	}
+	prop := props[0]
+	switch v.Kind() {
+	case reflect.Map:
+		if prop.Seq || v.IsNil() {
+			return fmt.Errorf("%w: %s", ErrApplyPatchPath, jsonPointer(p.Path))
+		}
+		key := reflect.ValueOf(prop.Key).Convert(v.Type().Key())
+		cur := v.MapIndex(key)
+		if !cur.IsValid() {
+			return fmt.Errorf("%w: %s", ErrApplyPatchPath, jsonPointer(p.Path))
+		}
+		cp := reflect.New(v.Type().Elem()).Elem()
+		cp.Set(cur)
+		if err := a.apply(cp, props[1:], p); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, cp)
+		return nil
+	case reflect.Slice:
+		if !prop.Seq || prop.Index < 0 || prop.Index >= v.Len() {
+			return fmt.Errorf("%w: %s", ErrApplyPatchPath, jsonPointer(p.Path))
+		}
+		return a.apply(v.Index(prop.Index), props[1:], p)
+	case reflect.Struct:
+		f, ok := structField(v, prop)
+		if !ok {
+			// Like setMember, skip what the read model has no field for.
+			return nil
+		}
+		return a.apply(f, props[1:], p)
+	default:
+		return fmt.Errorf("%w: %s", ErrApplyPatchPath, jsonPointer(p.Path))
+	}
+}
+
+// applyHere applies p to v, the container p.ObjID maps to.
+func (a patchApplier) applyHere(v reflect.Value, p Patch) error {
+	switch p.Kind {
+	case PatchMapPut:
+		return setMember(v, p.Key, func(typ reflect.Type) (reflect.Value, error) { return goValue(*p.NewValue, typ) })
+	case PatchMapDelete:
+		switch v.Kind() {
+		case reflect.Map:
+			v.SetMapIndex(reflect.ValueOf(p.Key).Convert(v.Type().Key()), reflect.Value{})
+			return nil
+		case reflect.Struct:
+			if f, ok := structField(v, MapProp(p.Key)); ok {
+				f.SetZero()
+			}
+			return nil
+		}
+	case PatchIncrement:
+		return setMember(v, p.Key, func(typ reflect.Type) (reflect.Value, error) {
+			cur, err := member(v, p.Key)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return incremented(cur, typ, p.Delta)
+		})
+	case PatchListInsert:
+		if v.Kind() != reflect.Slice || p.Index < 0 || p.Index > v.Len() {
+			break
+		}
+		vals := reflect.MakeSlice(v.Type(), len(p.Values), len(p.Values))
+		for i, pv := range p.Values {
+			gv, err := goValue(pv, v.Type().Elem())
+			if err != nil {
+				return err
+			}
+			vals.Index(i).Set(gv)
+		}
+		tail := reflect.AppendSlice(vals, v.Slice(p.Index, v.Len()))
+		v.Set(reflect.AppendSlice(v.Slice(0, p.Index), tail))
+		return nil
+	case PatchListDelete:
+		if v.Kind() != reflect.Slice || p.Index < 0 || p.Index+p.Count > v.Len() {
+			break
+		}
+		v.Set(reflect.AppendSlice(v.Slice(0, p.Index), v.Slice(p.Index+p.Count, v.Len())))
+		return nil
+	case PatchListPut:
+		if v.Kind() != reflect.Slice || p.Index < 0 || p.Index >= v.Len() {
+			break
+		}
+		gv, err := goValue(*p.NewValue, v.Type().Elem())
+		if err != nil {
+			return err
+		}
+		v.Index(p.Index).Set(gv)
+		return nil
+	case PatchTextSplice:
+		if v.Kind() != reflect.String {
+			break
+		}
+		s := v.String()
+		start := inttext.ConvertIndex(s, p.Index, a.opts.TextEncoding, inttext.EncodingUTF8Bytes)
+		end := inttext.ConvertIndex(s, p.Index+p.DeleteCount, a.opts.TextEncoding, inttext.EncodingUTF8Bytes)
+		v.SetString(s[:start] + p.InsertText + s[end:])
+		return nil
+	case PatchMark, PatchUnmark:
+		return nil
+	}
+	return fmt.Errorf("%w: patch kind %d on %s at %s", ErrApplyPatchType, p.Kind, v.Type(), jsonPointer(p.Path))
+}
+
+// setMember stores build(typ) under key of a map or struct, where typ is the
+// slot type.
+func setMember(v reflect.Value, key string, build func(reflect.Type) (reflect.Value, error)) error {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		nv, err := build(v.Type().Elem())
+		if err != nil {
+			return err
+		}
+		v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), nv)
+		return nil
+	case reflect.Struct:
+		f, ok := structField(v, MapProp(key))
+		if !ok {
+			// Keys without a field are not part of the read model.
+			return nil
+		}
+		nv, err := build(f.Type())
+		if err != nil {
+			return err
+		}
+		f.Set(nv)
+		return nil
+	default:
+		return fmt.Errorf("%w: set %q on %s", ErrApplyPatchType, key, v.Type())
+	}
+}
+
+func member(v reflect.Value, key string) (reflect.Value, error) {
+	var cur reflect.Value
+	switch v.Kind() {
+	case reflect.Map:
+		cur = v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+	case reflect.Struct:
+		cur, _ = structField(v, MapProp(key))
+	}
+	if !cur.IsValid() {
+		return reflect.Value{}, fmt.Errorf("%w: %q", ErrApplyPatchPath, key)
+	}
+	if cur.Kind() == reflect.Interface && !cur.IsNil() {
+		cur = cur.Elem()
+	}
+	return cur, nil
+}
+
+func incremented(cur reflect.Value, typ reflect.Type, delta int64) (reflect.Value, error) {
+	out := reflect.New(cur.Type()).Elem()
+	switch {
+	case cur.CanInt():
+		out.SetInt(cur.Int() + delta)
+	case cur.CanUint():
+		out.SetUint(uint64(int64(cur.Uint()) + delta))
+	case cur.CanFloat():
+		out.SetFloat(cur.Float() + float64(delta))
+	default:
+		return reflect.Value{}, fmt.Errorf("%w: increment %s", ErrApplyPatchType, cur.Type())
+	}
+	return convertTo(out, typ)
+}
+
+// goValue builds the value stored for v in a slot of type typ.
+func goValue(v Value, typ reflect.Type) (reflect.Value, error) {
+	if v.Kind == opset.ValueObject {
+		return emptyObject(v.Object.Type, typ)
+	}
+	var nat any
+	s := v.Scalar
+	switch s.Kind {
+	case model.ScalarNull:
+		return reflect.Zero(typ), nil
+	case model.ScalarBytes, model.ScalarUnknown:
+		nat = append([]byte(nil), s.Bytes...)
+	case model.ScalarString:
+		nat = s.String
+	case model.ScalarInt:
+		nat = s.Int
+	case model.ScalarUint:
+		nat = s.Uint
+	case model.ScalarF64:
+		nat = s.F64
+	case model.ScalarCounter:
+		nat = s.Counter
+	case model.ScalarTimestamp:
+		nat = time.UnixMilli(s.Time).UTC()
+	case model.ScalarBoolean:
+		nat = s.Boolean
+	default:
+		return reflect.Value{}, fmt.Errorf("%w: scalar kind %d", ErrApplyPatchType, s.Kind)
+	}
+	return convertTo(reflect.ValueOf(nat), typ)
+}
+
+func convertTo(v reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	if v.Type().AssignableTo(typ) {
+		return v, nil
+	}
+	if typ.Kind() == reflect.Pointer {
+		inner, err := convertTo(v, typ.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ptr := reflect.New(typ.Elem())
+		ptr.Elem().Set(inner)
+		return ptr, nil
+	}
+	numeric := func(k reflect.Kind) bool {
+		return (k >= reflect.Int && k <= reflect.Uint64) || k == reflect.Float32 || k == reflect.Float64
+	}
+	// Only convert between like kinds; Go also converts ints to strings, which
+	// is never what a patch means.
+	if v.Kind() == typ.Kind() || (numeric(v.Kind()) && numeric(typ.Kind())) {
+		return v.Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("%w: %s into %s", ErrApplyPatchType, v.Type(), typ)
+}
+
+func emptyObject(objType ObjType, typ reflect.Type) (reflect.Value, error) {
+	if typ.Kind() == reflect.Interface {
+		switch objType {
+		case ObjMap:
+			return reflect.ValueOf(map[string]any{}), nil
+		case ObjList:
+			return reflect.ValueOf([]any{}), nil
+		default:
+			return reflect.ValueOf(""), nil
+		}
+	}
+	switch typ.Kind() {
+	case reflect.Pointer:
+		inner, err := emptyObject(objType, typ.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ptr := reflect.New(typ.Elem())
+		ptr.Elem().Set(inner)
+		return ptr, nil
+	case reflect.Map:
+		if objType == ObjMap {
+			return reflect.MakeMap(typ), nil
+		}
+	case reflect.Struct:
+		if objType == ObjMap {
+			return reflect.New(typ).Elem(), nil
+		}
+	case reflect.Slice:
+		if objType == ObjList {
+			return reflect.MakeSlice(typ, 0, 0), nil
+		}
+	case reflect.String:
+		if objType == ObjText {
+			return reflect.New(typ).Elem(), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("%w: %s object into %s", ErrApplyPatchType, objType, typ)
+}
+
+// structField finds the exported field of v named by prop, following
+// encoding/json naming.
+func structField(v reflect.Value, prop Prop) (reflect.Value, bool) {
+	if prop.Seq {
+		return reflect.Value{}, false
+	}
+	t := v.Type()
+	fold := -1
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		if name == prop.Key {
+			return v.Field(i), true
+		}
+		if fold < 0 && strings.EqualFold(name, prop.Key) {
+			fold = i
+		}
+	}
+	if fold >= 0 {
+		return v.Field(fold), true
+	}
+	return reflect.Value{}, false
+}
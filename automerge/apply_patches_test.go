@@ -0,0 +1,130 @@
+package automerge
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func buildApplyPatchesDoc(t *testing.T) (*Document, []ChangeHash, []ChangeHash) {
+	t.Helper()
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	_ = tx1.Put(RootObjID(), "title", StringValue("draft"))
+	_ = tx1.Put(RootObjID(), "views", CounterValue(1))
+	todos, _ := tx1.PutObject(RootObjID(), "todos", ObjList)
+	item, _ := tx1.InsertObject(todos, 0, ObjMap)
+	_ = tx1.Put(item, "done", BoolValue(false))
+	note, _ := tx1.PutObject(item, "note", ObjText)
+	_ = tx1.SpliceText(note, 0, 0, "buy mlk")
+	if _, err := tx1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	h1 := d.Heads()
+
+	tx2, _ := d.Begin()
+	_ = tx2.Put(RootObjID(), "title", StringValue("final"))
+	_ = tx2.Increment(RootObjID(), "views", 4)
+	_ = tx2.Put(item, "done", BoolValue(true))
+	_ = tx2.SpliceText(note, 5, 0, "i")
+	second, _ := tx2.InsertObject(todos, 1, ObjMap)
+	_ = tx2.Put(second, "done", BoolValue(false))
+	_ = tx2.Insert(todos, 0, StringValue("header"))
+	_ = tx2.DeleteList(todos, 0)
+	if _, err := tx2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return d, h1, d.Heads()
+}
+
+func TestApplyPatchesToPlainTree(t *testing.T) {
+	d, h1, h2 := buildApplyPatchesDoc(t)
+
+	tree := map[string]any{}
+	if err := ApplyPatches(tree, d.Diff(nil, h1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyPatches(tree, d.Diff(h1, h2)); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"title": "final",
+		"views": int64(5),
+		"todos": []any{
+			map[string]any{"done": true, "note": "buy milk"},
+			map[string]any{"done": false},
+		},
+	}
+	if !reflect.DeepEqual(tree, want) {
+		t.Fatalf("unexpected tree:\n got %#v\nwant %#v", tree, want)
+	}
+}
+
+func TestApplyPatchesToTaggedStruct(t *testing.T) {
+	type todo struct {
+		Done bool   `json:"done"`
+		Note string `json:"note"`
+	}
+	type doc struct {
+		Title string  `json:"title"`
+		Views int     `json:"views"`
+		Todos []*todo `json:"todos"`
+		Extra string  `json:"-"`
+	}
+	d, h1, h2 := buildApplyPatchesDoc(t)
+
+	var got doc
+	if err := ApplyPatches(&got, d.Diff(nil, h1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyPatches(&got, d.Diff(h1, h2)); err != nil {
+		t.Fatal(err)
+	}
+	want := doc{Title: "final", Views: 5, Todos: []*todo{{Done: true, Note: "buy milk"}, {}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected struct: %+v", got)
+	}
+
+	// The struct matches what encoding/json makes of the export.
+	var exported doc
+	raw, _ := d.ToJSON(RootObjID(), nil)
+	_ = json.Unmarshal(raw, &exported)
+	if !reflect.DeepEqual(got, exported) {
+		t.Fatalf("struct differs from export: %+v vs %+v", got, exported)
+	}
+}
+
+func TestApplyPatchesErrors(t *testing.T) {
+	d, _, h2 := buildApplyPatchesDoc(t)
+	patches := d.Diff(nil, h2)
+
+	var notPointer map[string]any
+	if err := ApplyPatches(notPointer, patches); !errors.Is(err, ErrApplyPatchTarget) {
+		t.Fatalf("expected target error, got %v", err)
+	}
+	var wrong struct {
+		Title int `json:"title"`
+	}
+	if err := ApplyPatches(&wrong, patches); !errors.Is(err, ErrApplyPatchType) {
+		t.Fatalf("expected type error, got %v", err)
+	}
+
+	// A UTF-16 diff needs the matching encoding to splice correctly.
+	tx, _ := d.Begin()
+	text, _ := tx.PutObject(RootObjID(), "emoji", ObjText)
+	_ = tx.SpliceText(text, 0, 0, "😀b")
+	_, _ = tx.Commit()
+	h3 := d.Heads()
+	tx2, _ := d.Begin()
+	_ = tx2.SpliceText(text, 1, 0, "a")
+	_, _ = tx2.Commit()
+	tree := map[string]any{"emoji": "😀b"}
+	utf16 := DiffOptions{TextEncoding: EncodingUTF16}
+	if err := ApplyPatchesWithOptions(tree, d.DiffWithOptions(h3, d.Heads(), utf16), ApplyPatchesOptions{TextEncoding: EncodingUTF16}); err != nil {
+		t.Fatal(err)
+	}
+	if tree["emoji"] != "😀ab" {
+		t.Fatalf("unexpected utf-16 splice result: %q", tree["emoji"])
+	}
+}
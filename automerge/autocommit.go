@@ -1,10 +1,8 @@
 package automerge
 
-import "github.com/cjanietz/automerge-native-go/internal/model"
-
 type AutoCommit struct {
 	doc        *Document
-	diffCursor []model.ChangeHash
+	diffCursor []ChangeHash
 }
 
 func NewAutoCommit() *AutoCommit {
@@ -15,12 +13,12 @@ func (a *AutoCommit) Document() *Document {
 	return a.doc
 }
 
-func (a *AutoCommit) Diff(before, after []model.ChangeHash) []Patch {
+func (a *AutoCommit) Diff(before, after []ChangeHash) []Patch {
 	return a.doc.Diff(before, after)
 }
 
-func (a *AutoCommit) DiffCursor() []model.ChangeHash {
-	out := make([]model.ChangeHash, len(a.diffCursor))
+func (a *AutoCommit) DiffCursor() []ChangeHash {
+	out := make([]ChangeHash, len(a.diffCursor))
 	copy(out, a.diffCursor)
 	return out
 }
@@ -44,7 +42,7 @@ func (a *AutoCommit) SetActor(actor uint32) error {
 	return a.doc.SetActor(actor)
 }
 
-func (a *AutoCommit) Put(obj model.ObjID, key string, value model.ScalarValue) (*Change, error) {
+func (a *AutoCommit) Put(obj ObjID, key string, value ScalarValue) (*Change, error) {
 	tx, err := a.doc.Begin()
 	if err != nil {
 		return nil, err
@@ -56,24 +54,24 @@ func (a *AutoCommit) Put(obj model.ObjID, key string, value model.ScalarValue) (
 	return tx.Commit()
 }
 
-func (a *AutoCommit) PutObject(obj model.ObjID, key string, typ ObjType) (model.ObjID, *Change, error) {
+func (a *AutoCommit) PutObject(obj ObjID, key string, typ ObjType) (ObjID, *Change, error) {
 	tx, err := a.doc.Begin()
 	if err != nil {
-		return model.ObjID{}, nil, err
+		return ObjID{}, nil, err
 	}
 	objID, err := tx.PutObject(obj, key, typ)
 	if err != nil {
 		_ = tx.Rollback()
-		return model.ObjID{}, nil, err
+		return ObjID{}, nil, err
 	}
 	change, err := tx.Commit()
 	if err != nil {
-		return model.ObjID{}, nil, err
+		return ObjID{}, nil, err
 	}
 	return objID, change, nil
 }
 
-func (a *AutoCommit) Insert(obj model.ObjID, index int, value model.ScalarValue) (*Change, error) {
+func (a *AutoCommit) Insert(obj ObjID, index int, value ScalarValue) (*Change, error) {
 	tx, err := a.doc.Begin()
 	if err != nil {
 		return nil, err
@@ -85,24 +83,24 @@ func (a *AutoCommit) Insert(obj model.ObjID, index int, value model.ScalarValue)
 	return tx.Commit()
 }
 
-func (a *AutoCommit) InsertObject(obj model.ObjID, index int, typ ObjType) (model.ObjID, *Change, error) {
+func (a *AutoCommit) InsertObject(obj ObjID, index int, typ ObjType) (ObjID, *Change, error) {
 	tx, err := a.doc.Begin()
 	if err != nil {
-		return model.ObjID{}, nil, err
+		return ObjID{}, nil, err
 	}
 	objID, err := tx.InsertObject(obj, index, typ)
 	if err != nil {
 		_ = tx.Rollback()
-		return model.ObjID{}, nil, err
+		return ObjID{}, nil, err
 	}
 	change, err := tx.Commit()
 	if err != nil {
-		return model.ObjID{}, nil, err
+		return ObjID{}, nil, err
 	}
 	return objID, change, nil
 }
 
-func (a *AutoCommit) DeleteMap(obj model.ObjID, key string) (*Change, error) {
+func (a *AutoCommit) DeleteMap(obj ObjID, key string) (*Change, error) {
 	tx, err := a.doc.Begin()
 	if err != nil {
 		return nil, err
@@ -114,7 +112,7 @@ func (a *AutoCommit) DeleteMap(obj model.ObjID, key string) (*Change, error) {
 	return tx.Commit()
 }
 
-func (a *AutoCommit) DeleteList(obj model.ObjID, index int) (*Change, error) {
+func (a *AutoCommit) DeleteList(obj ObjID, index int) (*Change, error) {
 	tx, err := a.doc.Begin()
 	if err != nil {
 		return nil, err
@@ -126,7 +124,7 @@ func (a *AutoCommit) DeleteList(obj model.ObjID, index int) (*Change, error) {
 	return tx.Commit()
 }
 
-func (a *AutoCommit) Increment(obj model.ObjID, key string, by int64) (*Change, error) {
+func (a *AutoCommit) Increment(obj ObjID, key string, by int64) (*Change, error) {
 	tx, err := a.doc.Begin()
 	if err != nil {
 		return nil, err
@@ -138,7 +136,7 @@ func (a *AutoCommit) Increment(obj model.ObjID, key string, by int64) (*Change,
 	return tx.Commit()
 }
 
-func (a *AutoCommit) SpliceText(obj model.ObjID, index int, deleteCount int, insert string) (*Change, error) {
+func (a *AutoCommit) SpliceText(obj ObjID, index int, deleteCount int, insert string) (*Change, error) {
 	tx, err := a.doc.Begin()
 	if err != nil {
 		return nil, err
@@ -150,7 +148,19 @@ func (a *AutoCommit) SpliceText(obj model.ObjID, index int, deleteCount int, ins
 	return tx.Commit()
 }
 
-func (a *AutoCommit) Mark(obj model.ObjID, start int, end int, name string, value model.ScalarValue) (*Change, error) {
+func (a *AutoCommit) UpdateText(obj ObjID, newText string) (*Change, error) {
+	tx, err := a.doc.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.UpdateText(obj, newText); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return tx.Commit()
+}
+
+func (a *AutoCommit) Mark(obj ObjID, start int, end int, name string, value ScalarValue) (*Change, error) {
 	tx, err := a.doc.Begin()
 	if err != nil {
 		return nil, err
@@ -161,3 +171,27 @@ func (a *AutoCommit) Mark(obj model.ObjID, start int, end int, name string, valu
 	}
 	return tx.Commit()
 }
+
+func (a *AutoCommit) MarkWithExpand(obj ObjID, start int, end int, name string, value ScalarValue, expand ExpandMark) (*Change, error) {
+	tx, err := a.doc.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.MarkWithExpand(obj, start, end, name, value, expand); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return tx.Commit()
+}
+
+func (a *AutoCommit) Unmark(obj ObjID, start int, end int, name string) (*Change, error) {
+	tx, err := a.doc.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Unmark(obj, start, end, name); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return tx.Commit()
+}
@@ -25,12 +25,12 @@ func TestAutoCommitMutations(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	vals := ac.Document().ListRange(listID, 0, -1, nil)
+	vals := ac.Document().ListRange(listID, 0, -1)
 	if len(vals) != 1 || vals[0].Scalar.String != "y" {
 		t.Fatalf("unexpected list values: %#v", vals)
 	}
 
-	v, ok := ac.Document().GetMap(model.RootObjID(), "title", nil)
+	v, ok := ac.Document().GetMap(model.RootObjID(), "title")
 	if !ok || v.Scalar.String != "hello" {
 		t.Fatalf("unexpected title value: %#v ok=%v", v, ok)
 	}
@@ -0,0 +1,147 @@
+package automerge
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cjanietz/automerge-native-go/internal/changegraph"
+	"github.com/cjanietz/automerge-native-go/internal/opset"
+	inttext "github.com/cjanietz/automerge-native-go/internal/text"
+)
+
+// ErrNotBlock is returned by JoinBlock for an index that holds no block marker.
+var ErrNotBlock = errors.New("no block marker at index")
+
+// Block is a section of a text object, such as a paragraph, heading or list
+// item, started by a block marker. A block marker is a map inserted into the
+// text; it reads as a newline in Text and its keys are the block's attributes.
+type Block struct {
+	// ID is the map of the block marker.
+	ID    ObjID
+	Attrs map[string]Value
+	// Start and End bound the text of the block, from right after its marker
+	// up to the next marker or the end of the text, counted in the document's
+	// text encoding. The marker itself is at Start-1.
+	Start int
+	End   int
+}
+
+// SplitBlock inserts a block marker at index of the text object obj and
+// returns its map, so the caller can set attributes such as the block type
+// with Put. Text after index up to the next marker belongs to the new block.
+func (tx *Transaction) SplitBlock(obj ObjID, index int) (ObjID, error) {
+	if err := tx.ensureOpen(); err != nil {
+		return ObjID{}, err
+	}
+	if err := tx.ensureText(obj); err != nil {
+		return ObjID{}, err
+	}
+	index, _, err := tx.textRange(obj, index, index, tx.doc.textOpts.Encoding)
+	if err != nil {
+		return ObjID{}, err
+	}
+	return tx.InsertObject(obj, index, ObjMap)
+}
+
+// JoinBlock deletes the block marker at index, so the text of its block joins
+// the block before it.
+func (tx *Transaction) JoinBlock(obj ObjID, index int) error {
+	if err := tx.ensureOpen(); err != nil {
+		return err
+	}
+	if err := tx.ensureText(obj); err != nil {
+		return err
+	}
+	index, _, err := tx.textRange(obj, index, index, tx.doc.textOpts.Encoding)
+	if err != nil {
+		return err
+	}
+	if v, ok := tx.view().GetList(obj, index); !ok || !isBlockMarker(v) {
+		return fmt.Errorf("%w: %d", ErrNotBlock, index)
+	}
+	return tx.DeleteList(obj, index)
+}
+
+func (tx *Transaction) ensureText(obj ObjID) error {
+	typ, ok := tx.view().ObjectType(obj)
+	if !ok {
+		return fmt.Errorf("%w: %s", opset.ErrUnknownObject, obj)
+	}
+	if typ != ObjText {
+		return fmt.Errorf("%w: have=%s", opset.ErrWrongObjectType, typ)
+	}
+	return nil
+}
+
+func (a *AutoCommit) SplitBlock(obj ObjID, index int) (ObjID, *Change, error) {
+	tx, err := a.doc.Begin()
+	if err != nil {
+		return ObjID{}, nil, err
+	}
+	block, err := tx.SplitBlock(obj, index)
+	if err != nil {
+		_ = tx.Rollback()
+		return ObjID{}, nil, err
+	}
+	change, err := tx.Commit()
+	return block, change, err
+}
+
+func (a *AutoCommit) JoinBlock(obj ObjID, index int) (*Change, error) {
+	tx, err := a.doc.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.JoinBlock(obj, index); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return tx.Commit()
+}
+
+// Blocks lists the blocks of a text object in order. Text before the first
+// marker belongs to no block. Empty heads read the current state.
+func (d *Document) Blocks(obj ObjID, heads []ChangeHash) ([]Block, error) {
+	var at *changegraph.Clock
+	if len(heads) > 0 {
+		clk, err := d.clockFromHeads(heads)
+		if err != nil {
+			return nil, err
+		}
+		at = clk
+	}
+	view := d.ops.ViewAt(at)
+	typ, ok := view.ObjectType(obj)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", opset.ErrUnknownObject, obj)
+	}
+	if typ != ObjText {
+		return nil, fmt.Errorf("%w: have=%s", opset.ErrWrongObjectType, typ)
+	}
+	var out []Block
+	pos := 0
+	for _, e := range view.ListElements(obj) {
+		if isBlockMarker(e.Value) {
+			if n := len(out); n > 0 {
+				out[n-1].End = pos
+			}
+			attrs := map[string]Value{}
+			for k, v := range view.MapEntries(e.Value.Object.ID) {
+				attrs[k] = v
+			}
+			out = append(out, Block{ID: e.Value.Object.ID, Attrs: attrs})
+		}
+		pos += inttext.Length(elementText(e), d.textOpts.Encoding)
+		if isBlockMarker(e.Value) {
+			out[len(out)-1].Start = pos
+		}
+	}
+	if n := len(out); n > 0 {
+		out[n-1].End = pos
+	}
+	return out, nil
+}
+
+func isBlockMarker(v Value) bool {
+	return v.Kind == ValueObject && v.Object.Type == ObjMap
+}
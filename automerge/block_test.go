@@ -0,0 +1,154 @@
+package automerge
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBlocksListAttributesAndRanges(t *testing.T) {
+	d := NewDocument()
+	root := RootObjID()
+	var text, heading, item ObjID
+	commitTx(t, d, func(tx *Transaction) error {
+		text, _ = tx.PutObject(root, "text", ObjText)
+		if err := tx.SpliceText(text, 0, 0, "TitleFirstSecond"); err != nil {
+			return err
+		}
+		var err error
+		if heading, err = tx.SplitBlock(text, 0); err != nil {
+			return err
+		}
+		_ = tx.Put(heading, "type", StringValue("heading"))
+		if item, err = tx.SplitBlock(text, 6); err != nil {
+			return err
+		}
+		_ = tx.Put(item, "type", StringValue("ul"))
+		_ = tx.Put(item, "depth", IntValue(1))
+		second, err := tx.SplitBlock(text, 12)
+		if err != nil {
+			return err
+		}
+		return tx.Put(second, "type", StringValue("ul"))
+	})
+	if got := d.Text(text); got != "\nTitle\nFirst\nSecond" {
+		t.Fatalf("unexpected text: %q", got)
+	}
+	blocks, err := d.Blocks(text, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 blocks, got %#v", blocks)
+	}
+	if b := blocks[0]; b.ID != heading || b.Start != 1 || b.End != 6 || b.Attrs["type"].Scalar.String != "heading" {
+		t.Fatalf("unexpected heading block: %#v", b)
+	}
+	if b := blocks[1]; b.ID != item || b.Start != 7 || b.End != 12 || b.Attrs["depth"].Scalar.Int != 1 {
+		t.Fatalf("unexpected list item block: %#v", b)
+	}
+	if b := blocks[2]; b.Start != 13 || b.End != 19 {
+		t.Fatalf("unexpected last block: %#v", b)
+	}
+
+	// Joining the second item into the first leaves one longer block.
+	commitTx(t, d, func(tx *Transaction) error {
+		if err := tx.JoinBlock(text, 3); !errors.Is(err, ErrNotBlock) {
+			t.Fatalf("expected ErrNotBlock for a character, got %v", err)
+		}
+		return tx.JoinBlock(text, 12)
+	})
+	blocks, _ = d.Blocks(text, nil)
+	if len(blocks) != 2 || blocks[1].End != 18 || d.Text(text) != "\nTitle\nFirstSecond" {
+		t.Fatalf("unexpected blocks after join: %q %#v", d.Text(text), blocks)
+	}
+
+	peer := NewDocument()
+	if err := peer.Merge(d); err != nil {
+		t.Fatal(err)
+	}
+	if peerBlocks, _ := peer.Blocks(text, nil); len(peerBlocks) != 2 || peerBlocks[0].Attrs["type"].Scalar.String != "heading" {
+		t.Fatalf("peer diverged: %#v", peerBlocks)
+	}
+}
+
+func TestUndoRestoresDeletedBlock(t *testing.T) {
+	d := NewDocument()
+	root := RootObjID()
+	var text ObjID
+	commitTx(t, d, func(tx *Transaction) error {
+		text, _ = tx.PutObject(root, "text", ObjText)
+		if err := tx.SpliceText(text, 0, 0, "ab"); err != nil {
+			return err
+		}
+		block, err := tx.SplitBlock(text, 1)
+		if err != nil {
+			return err
+		}
+		return tx.Put(block, "type", StringValue("paragraph"))
+	})
+	um := NewUndoManager(d, d.Actor())
+	defer um.Close()
+	commitTx(t, d, func(tx *Transaction) error { return tx.SpliceText(text, 0, 3, "") })
+	if _, err := um.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	blocks, _ := d.Blocks(text, nil)
+	if d.Text(text) != "a\nb" || len(blocks) != 1 || blocks[0].Attrs["type"].Scalar.String != "paragraph" {
+		t.Fatalf("unexpected content after undo: %q %#v", d.Text(text), blocks)
+	}
+}
+
+func TestRevertToRestoresBlocks(t *testing.T) {
+	d := NewDocument()
+	var text ObjID
+	commitTx(t, d, func(tx *Transaction) error {
+		text, _ = tx.PutObject(RootObjID(), "text", ObjText)
+		if err := tx.SpliceText(text, 0, 0, "ab"); err != nil {
+			return err
+		}
+		block, err := tx.SplitBlock(text, 1)
+		if err != nil {
+			return err
+		}
+		return tx.Put(block, "type", StringValue("paragraph"))
+	})
+	h1 := d.Heads()
+	commitTx(t, d, func(tx *Transaction) error { return tx.SpliceText(text, 0, 3, "") })
+
+	if _, err := d.RevertTo(h1); err != nil {
+		t.Fatal(err)
+	}
+	blocks, _ := d.Blocks(text, nil)
+	if d.Text(text) != "a\nb" || len(blocks) != 1 || blocks[0].Attrs["type"].Scalar.String != "paragraph" {
+		t.Fatalf("unexpected content after revert: %q %#v", d.Text(text), blocks)
+	}
+	if change, err := d.RevertTo(h1); err != nil || change != nil {
+		t.Fatalf("expected nothing left to revert, got %v %v", change, err)
+	}
+}
+
+func TestRevertToTellsBlocksFromNewlines(t *testing.T) {
+	d := NewDocument()
+	var text ObjID
+	commitTx(t, d, func(tx *Transaction) error {
+		text, _ = tx.PutObject(RootObjID(), "text", ObjText)
+		if err := tx.SpliceText(text, 0, 0, "ab"); err != nil {
+			return err
+		}
+		_, err := tx.SplitBlock(text, 1)
+		return err
+	})
+	h1 := d.Heads()
+	// A plain newline replaces the marker; the text reads the same.
+	commitTx(t, d, func(tx *Transaction) error { return tx.SpliceText(text, 1, 1, "\n") })
+	if blocks, _ := d.Blocks(text, nil); len(blocks) != 0 {
+		t.Fatalf("expected the marker to be gone, got %#v", blocks)
+	}
+
+	if _, err := d.RevertTo(h1); err != nil {
+		t.Fatal(err)
+	}
+	if blocks, _ := d.Blocks(text, nil); d.Text(text) != "a\nb" || len(blocks) != 1 {
+		t.Fatalf("unexpected content after revert: %q %#v", d.Text(text), blocks)
+	}
+}
@@ -1,9 +1,5 @@
 package automerge
 
-import (
-	"github.com/cjanietz/automerge-native-go/internal/model"
-)
-
 type ChangeOperationKind uint8
 
 const (
@@ -20,13 +16,13 @@ const (
 
 type ChangeOperation struct {
 	Kind  ChangeOperationKind
-	ObjID model.ObjID
+	ObjID ObjID
 	// ChildObjID is set for object-creation operations.
-	ChildObjID model.ObjID
+	ChildObjID ObjID
 	Key        string
 	Index      int
 
-	Value   model.ScalarValue
+	Value   ScalarValue
 	ObjType ObjType
 	By      int64
 
@@ -36,17 +32,36 @@ type ChangeOperation struct {
 	Start    int
 	End      int
 	MarkName string
+	// Expand is the mark's policy for text inserted at its edges.
+	Expand ExpandMark
+
+	// Identified is set when the op names what it acts on by OpID rather than
+	// by index, so every peer applies it to the same elements and versions
+	// whatever it has applied concurrently. Index, Start and End then only
+	// record the indexes in the state the op was made against. Ops from older
+	// encoders lack it and are applied by index against the current state.
+	Identified bool
+	// Elem is the element an OpInsert, OpInsertObject or OpSpliceText goes
+	// after, zero for the head, or the element an OpDeleteList deletes.
+	Elem OpID
+	// Deleted are the elements an OpSpliceText deletes.
+	Deleted []OpID
+	// Pred are the versions an OpPut, OpPutObject or OpDeleteMap supersedes.
+	Pred []OpID
+	// StartAnchor and EndAnchor are the gaps at the edges of an OpMark.
+	StartAnchor MarkAnchor
+	EndAnchor   MarkAnchor
 
-	OpID model.OpID
+	OpID OpID
 }
 
 type Change struct {
-	Hash    model.ChangeHash
+	Hash    ChangeHash
 	Actor   uint32
 	Seq     uint64
 	StartOp uint64
 	MaxOp   uint64
-	Deps    []model.ChangeHash
+	Deps    []ChangeHash
 
 	Message *string
 	Time    *int64
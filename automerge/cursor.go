@@ -4,7 +4,6 @@ import (
 	"fmt"
 
 	"github.com/cjanietz/automerge-native-go/internal/changegraph"
-	"github.com/cjanietz/automerge-native-go/internal/model"
 	inttext "github.com/cjanietz/automerge-native-go/internal/text"
 )
 
@@ -16,17 +15,17 @@ const (
 )
 
 type Cursor struct {
-	ObjID        model.ObjID
-	Anchor       model.OpID
+	ObjID        ObjID
+	Anchor       OpID
 	Side         CursorSide
 	FallbackRune int
 }
 
-func (d *Document) CursorForText(obj model.ObjID, index int, enc inttext.Encoding) (Cursor, error) {
+func (d *Document) CursorForText(obj ObjID, index int, enc Encoding) (Cursor, error) {
 	return d.cursorForText(obj, index, enc, nil)
 }
 
-func (d *Document) CursorForTextAt(obj model.ObjID, index int, enc inttext.Encoding, heads []model.ChangeHash) (Cursor, error) {
+func (d *Document) CursorForTextAt(obj ObjID, index int, enc Encoding, heads []ChangeHash) (Cursor, error) {
 	clk, err := d.clockFromHeads(heads)
 	if err != nil {
 		return Cursor{}, err
@@ -34,11 +33,11 @@ func (d *Document) CursorForTextAt(obj model.ObjID, index int, enc inttext.Encod
 	return d.cursorForText(obj, index, enc, clk)
 }
 
-func (d *Document) ResolveTextCursor(c Cursor, enc inttext.Encoding) (int, error) {
+func (d *Document) ResolveTextCursor(c Cursor, enc Encoding) (int, error) {
 	return d.resolveTextCursor(c, enc, nil)
 }
 
-func (d *Document) ResolveTextCursorAt(c Cursor, enc inttext.Encoding, heads []model.ChangeHash) (int, error) {
+func (d *Document) ResolveTextCursorAt(c Cursor, enc Encoding, heads []ChangeHash) (int, error) {
 	clk, err := d.clockFromHeads(heads)
 	if err != nil {
 		return 0, err
@@ -46,7 +45,7 @@ func (d *Document) ResolveTextCursorAt(c Cursor, enc inttext.Encoding, heads []m
 	return d.resolveTextCursor(c, enc, clk)
 }
 
-func (d *Document) cursorForText(obj model.ObjID, index int, enc inttext.Encoding, at *changegraph.Clock) (Cursor, error) {
+func (d *Document) cursorForText(obj ObjID, index int, enc Encoding, at *changegraph.Clock) (Cursor, error) {
 	typ, ok := d.ops.ObjectType(obj)
 	if !ok {
 		return Cursor{}, fmt.Errorf("unknown object: %s", obj)
@@ -55,11 +54,7 @@ func (d *Document) cursorForText(obj model.ObjID, index int, enc inttext.Encodin
 		return Cursor{}, fmt.Errorf("wrong object type: have=%s", typ)
 	}
 	txt := d.ops.Text(obj, at)
-	norm := inttext.NormalizeIndexForEncoding(txt, index, enc)
-	runeIndex := norm
-	if enc == inttext.EncodingUTF16 {
-		runeIndex = inttext.UTF16IndexToRune(txt, norm)
-	}
+	runeIndex := inttext.ConvertIndex(txt, index, enc, inttext.EncodingUTF8)
 	ids := d.ops.SequenceElementIDs(obj, at)
 	if len(ids) == 0 {
 		return Cursor{ObjID: obj, Side: CursorAfter, FallbackRune: 0}, nil
@@ -73,7 +68,7 @@ func (d *Document) cursorForText(obj model.ObjID, index int, enc inttext.Encodin
 	return Cursor{ObjID: obj, Anchor: ids[runeIndex-1], Side: CursorAfter, FallbackRune: runeIndex}, nil
 }
 
-func (d *Document) resolveTextCursor(c Cursor, enc inttext.Encoding, at *changegraph.Clock) (int, error) {
+func (d *Document) resolveTextCursor(c Cursor, enc Encoding, at *changegraph.Clock) (int, error) {
 	typ, ok := d.ops.ObjectType(c.ObjID)
 	if !ok {
 		return 0, fmt.Errorf("unknown object: %s", c.ObjID)
@@ -100,9 +95,9 @@ func (d *Document) resolveTextCursor(c Cursor, enc inttext.Encoding, at *changeg
 	if runeIndex > len(ids) {
 		runeIndex = len(ids)
 	}
-	if enc == inttext.EncodingUTF16 {
+	if enc != inttext.EncodingUTF8 {
 		txt := d.ops.Text(c.ObjID, at)
-		return inttext.RuneIndexToUTF16(txt, runeIndex), nil
+		return inttext.ConvertIndex(txt, runeIndex, inttext.EncodingUTF8, enc), nil
 	}
 	return runeIndex, nil
 }
@@ -100,7 +100,347 @@ func TestDiffTextPatch(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(patches) != 1 || patches[0].Kind != PatchTextSplice || patches[0].BeforeText != "hello" || patches[0].AfterText != "halo" {
+	if len(patches) != 1 || patches[0].Kind != PatchTextSplice || patches[0].Index != 1 || patches[0].DeleteCount != 2 || patches[0].InsertText != "a" {
 		t.Fatalf("unexpected text diff patches: %#v", patches)
 	}
 }
+
+func TestDiffTextSplicesFollowElementsAndEncoding(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	textID, _ := tx1.PutObject(RootObjID(), "text", ObjText)
+	_ = tx1.SpliceText(textID, 0, 0, "😀aa😀")
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+
+	// Replace the second "a" and append; string comparison would see the
+	// replaced "a" as unchanged.
+	tx2, _ := d.Begin()
+	_ = tx2.SpliceText(textID, 2, 1, "a")
+	_ = tx2.SpliceText(textID, 4, 0, "!")
+	_, _ = tx2.Commit()
+	h2 := d.Heads()
+
+	patches, err := d.DiffObjWithOptions(textID, h1, h2, false, DiffOptions{TextEncoding: EncodingUTF16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Patch{
+		{Kind: PatchTextSplice, ObjID: textID, Index: 3, DeleteCount: 1, InsertText: "a"},
+		{Kind: PatchTextSplice, ObjID: textID, Index: 6, InsertText: "!"},
+	}
+	if len(patches) != len(want) {
+		t.Fatalf("unexpected text patches: %#v", patches)
+	}
+	for i := range want {
+		p := patches[i]
+		if p.Kind != want[i].Kind || p.Index != want[i].Index || p.DeleteCount != want[i].DeleteCount || p.InsertText != want[i].InsertText {
+			t.Fatalf("patch %d mismatch: got %#v want %#v", i, p, want[i])
+		}
+	}
+
+	bytesPatches, _ := d.DiffObjWithOptions(textID, h1, h2, false, DiffOptions{TextEncoding: EncodingUTF8Bytes})
+	if len(bytesPatches) != 2 || bytesPatches[0].Index != 5 || bytesPatches[1].Index != 10 {
+		t.Fatalf("unexpected byte-offset patches: %#v", bytesPatches)
+	}
+}
+
+func TestDiffListInsertDeletePutAndNested(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	listID, _ := tx1.PutObject(RootObjID(), "list", ObjList)
+	_ = tx1.Insert(listID, 0, StringValue("a"))
+	_ = tx1.Insert(listID, 1, StringValue("b"))
+	_ = tx1.Insert(listID, 2, StringValue("c"))
+	_ = tx1.Insert(listID, 3, StringValue("c"))
+	childID, _ := tx1.InsertObject(listID, 4, ObjMap)
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+
+	tx2, _ := d.Begin()
+	_ = tx2.DeleteList(listID, 0)
+	_ = tx2.DeleteList(listID, 1) // the first "c"
+	_ = tx2.Insert(listID, 1, StringValue("c"))
+	_ = tx2.Insert(listID, 2, StringValue("x"))
+	_ = tx2.Put(childID, "k", IntValue(1))
+	_, _ = tx2.Commit()
+	h2 := d.Heads()
+
+	patches, err := d.DiffObj(listID, h1, h2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "c" replaced by an equal value is still a delete plus an insert.
+	if len(patches) != 4 {
+		t.Fatalf("unexpected list patches: %#v", patches)
+	}
+	if p := patches[0]; p.Kind != PatchListDelete || p.Index != 0 || p.Count != 1 {
+		t.Fatalf("unexpected first patch: %#v", p)
+	}
+	if p := patches[1]; p.Kind != PatchListInsert || p.Index != 1 || len(p.Values) != 2 || p.Values[0].Scalar.String != "c" || p.Values[1].Scalar.String != "x" {
+		t.Fatalf("unexpected insert patch: %#v", p)
+	}
+	if p := patches[2]; p.Kind != PatchListDelete || p.Index != 3 || p.Count != 1 {
+		t.Fatalf("unexpected second delete: %#v", p)
+	}
+	if p := patches[3]; p.Kind != PatchMapPut || p.ObjID != childID || p.Key != "k" {
+		t.Fatalf("expected nested map patch, got %#v", p)
+	}
+}
+
+func TestDiffListPutWithConflict(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	listID, _ := tx1.PutObject(RootObjID(), "list", ObjList)
+	_ = tx1.Insert(listID, 0, StringValue("a"))
+	_ = tx1.Insert(listID, 1, StringValue("b"))
+	_, _ = tx1.Commit()
+	before, _ := d.clockFromHeads(d.Heads())
+
+	// Overwrite the second element with two concurrent values.
+	_ = d.ops.SetListRaw(listID, 1, opsetString("x"), OpID{Counter: 10, Actor: 1}, 1, 10, nil)
+	_ = d.ops.SetListRaw(listID, 1, opsetString("y"), OpID{Counter: 10, Actor: 2}, 2, 10, nil)
+
+	c := &diffContext{before: d.ops.ViewAt(before), after: d.ops.ViewAt(nil), opts: DefaultDiffOptions(), seen: map[ObjID]struct{}{}}
+	patches, err := c.diffObj(listID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 {
+		t.Fatalf("unexpected put patches: %#v", patches)
+	}
+	p := patches[0]
+	if p.Kind != PatchListPut || p.Index != 1 || !p.Conflict || p.NewValue.Scalar.String != "y" || p.OldValue.Scalar.String != "b" {
+		t.Fatalf("unexpected put patch: %#v", p)
+	}
+}
+
+func TestDiffPatchPathsThroughListsAndMaps(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	listID, _ := tx1.PutObject(RootObjID(), "todos", ObjList)
+	_ = tx1.Insert(listID, 0, StringValue("first"))
+	itemID, _ := tx1.InsertObject(listID, 1, ObjMap)
+	titleID, _ := tx1.PutObject(itemID, "title", ObjText)
+	_ = tx1.SpliceText(titleID, 0, 0, "buy")
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+
+	tx2, _ := d.Begin()
+	_ = tx2.Insert(listID, 0, StringValue("zeroth"))
+	_ = tx2.SpliceText(titleID, 3, 0, " milk")
+	_, _ = tx2.Commit()
+	h2 := d.Heads()
+
+	patches := d.Diff(h1, h2)
+	if len(patches) != 2 {
+		t.Fatalf("unexpected patches: %#v", patches)
+	}
+	if p := patches[0]; p.Kind != PatchListInsert || len(p.Path) != 1 || p.Path[0].Prop != MapProp("todos") {
+		t.Fatalf("unexpected list patch path: %#v", p)
+	}
+	text := patches[1]
+	want := []PathElement{
+		{ObjID: RootObjID(), Prop: MapProp("todos")},
+		{ObjID: listID, Prop: SeqProp(2)},
+		{ObjID: itemID, Prop: MapProp("title")},
+	}
+	if text.Kind != PatchTextSplice || len(text.Path) != len(want) {
+		t.Fatalf("unexpected text patch: %#v", text)
+	}
+	for i := range want {
+		if text.Path[i] != want[i] {
+			t.Fatalf("path element %d: got %#v want %#v", i, text.Path[i], want[i])
+		}
+	}
+
+	sub, err := d.DiffObj(titleID, h1, h2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sub) != 1 || len(sub[0].Path) != 3 || sub[0].Path[1].Prop != SeqProp(2) {
+		t.Fatalf("expected DiffObj to resolve the full path, got %#v", sub)
+	}
+
+	// Objects created between the heads are reported with their content.
+	created := d.Diff(nil, h1)
+	var sawTitle bool
+	for _, p := range created {
+		if p.ObjID == titleID && p.Kind == PatchTextSplice && p.InsertText == "buy" && len(p.Path) == 3 && p.Path[1].Prop == SeqProp(1) {
+			sawTitle = true
+		}
+	}
+	if !sawTitle {
+		t.Fatalf("expected nested text content in patches from empty: %#v", created)
+	}
+}
+
+func TestDiffIncrementPatch(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	_ = tx1.Put(RootObjID(), "n", CounterValue(5))
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+
+	tx2, _ := d.Begin()
+	_ = tx2.Increment(RootObjID(), "n", 3)
+	_ = tx2.Increment(RootObjID(), "n", -1)
+	_, _ = tx2.Commit()
+	h2 := d.Heads()
+
+	patches := d.Diff(h1, h2)
+	if len(patches) != 1 || patches[0].Kind != PatchIncrement || patches[0].Key != "n" || patches[0].Delta != 2 {
+		t.Fatalf("unexpected increment patches: %#v", patches)
+	}
+	if back := d.Diff(h2, h1); len(back) != 1 || back[0].Kind != PatchIncrement || back[0].Delta != -2 {
+		t.Fatalf("unexpected backward increment patches: %#v", back)
+	}
+
+	// Putting a new counter replaces the old one instead of incrementing it.
+	tx3, _ := d.Begin()
+	_ = tx3.Put(RootObjID(), "n", CounterValue(0))
+	_, _ = tx3.Commit()
+	if p := d.Diff(h2, d.Heads()); len(p) != 1 || p[0].Kind != PatchMapPut {
+		t.Fatalf("expected a put for a replaced counter, got %#v", p)
+	}
+}
+
+func TestDiffMarkPatches(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	textID, _ := tx1.PutObject(RootObjID(), "text", ObjText)
+	_ = tx1.SpliceText(textID, 0, 0, "héllo world")
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+
+	tx2, _ := d.Begin()
+	_ = tx2.Mark(textID, 0, 5, "bold", BoolValue(true))
+	_ = tx2.Mark(textID, 6, 11, "link", StringValue("a"))
+	_ = tx2.Mark(textID, 8, 11, "link", StringValue("b"))
+	_, _ = tx2.Commit()
+	h2 := d.Heads()
+
+	patches := d.DiffWithOptions(h1, h2, DiffOptions{TextEncoding: EncodingUTF8Bytes})
+	if len(patches) != 1 || patches[0].Kind != PatchMark || patches[0].ObjID != textID {
+		t.Fatalf("unexpected mark patches: %#v", patches)
+	}
+	want := []MarkRange{
+		{Name: "bold", Value: BoolValue(true), Start: 0, End: 6},
+		{Name: "link", Value: StringValue("a"), Start: 7, End: 9},
+		{Name: "link", Value: StringValue("b"), Start: 9, End: 12},
+	}
+	got := patches[0].Marks
+	if len(got) != len(want) {
+		t.Fatalf("unexpected mark ranges: %#v", got)
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name || !got[i].Value.Equal(want[i].Value) || got[i].Start != want[i].Start || got[i].End != want[i].End {
+			t.Fatalf("mark range %d: got %#v want %#v", i, got[i], want[i])
+		}
+	}
+
+	back := d.Diff(h2, h1)
+	if len(back) != 1 || back[0].Kind != PatchUnmark || len(back[0].Marks) != 2 {
+		t.Fatalf("unexpected unmark patches: %#v", back)
+	}
+	if r := back[0].Marks[1]; r.Name != "link" || r.Start != 6 || r.End != 11 || r.Value.Kind != ScalarNull {
+		t.Fatalf("unexpected unmark range: %#v", r)
+	}
+}
+
+func TestPatchLogRecordsCommitAndApplyOps(t *testing.T) {
+	d := NewDocument()
+	log := ActivePatchLog()
+	tx, _ := d.Begin()
+	textID, _ := tx.PutObject(RootObjID(), "text", ObjText)
+	_ = tx.SpliceText(textID, 0, 0, "hey")
+	_ = tx.Put(RootObjID(), "n", CounterValue(1))
+	_ = tx.Increment(RootObjID(), "n", 4)
+	_ = tx.Mark(textID, 0, 1, "bold", BoolValue(true))
+	if _, err := tx.CommitWith(CommitOptions{PatchLog: log}); err != nil {
+		t.Fatal(err)
+	}
+	kinds := []PatchKind{PatchMapPut, PatchTextSplice, PatchMapPut, PatchIncrement, PatchMark}
+	patches := log.MakePatches()
+	if len(patches) != len(kinds) {
+		t.Fatalf("unexpected commit patches: %#v", patches)
+	}
+	for i, k := range kinds {
+		if patches[i].Kind != k {
+			t.Fatalf("patch %d: got kind %d want %d", i, patches[i].Kind, k)
+		}
+	}
+	if p := patches[1]; p.InsertText != "hey" || len(p.Path) != 1 || p.Path[0].Prop != MapProp("text") {
+		t.Fatalf("unexpected splice patch: %#v", p)
+	}
+	if p := patches[3]; p.Delta != 4 {
+		t.Fatalf("unexpected increment patch: %#v", p)
+	}
+	if h := log.Heads(); len(h) != 1 || h[0] != d.Heads()[0] {
+		t.Fatalf("unexpected logged heads: %#v", h)
+	}
+
+	other := NewDocument()
+	remote := ActivePatchLog()
+	if err := other.ApplyChangesWithActorMap(d.AllChanges(), nil, remote); err != nil {
+		t.Fatal(err)
+	}
+	if got := remote.MakePatches(); len(got) != len(kinds) || got[1].InsertText != "hey" {
+		t.Fatalf("unexpected applied patches: %#v", got)
+	}
+
+	// An inactive or nil log records nothing.
+	inactive := InactivePatchLog()
+	tx2, _ := d.Begin()
+	_ = tx2.Put(RootObjID(), "k", StringValue("v"))
+	if _, err := tx2.CommitWith(CommitOptions{PatchLog: inactive}); err != nil {
+		t.Fatal(err)
+	}
+	if len(inactive.MakePatches()) != 0 {
+		t.Fatal("expected no patches in an inactive log")
+	}
+}
+
+func TestPatchLogTextEncodingAndPaths(t *testing.T) {
+	d := NewDocument()
+	var list, text ObjID
+	commitTx(t, d, func(tx *Transaction) error {
+		list, _ = tx.PutObject(RootObjID(), "list", ObjList)
+		_ = tx.Insert(list, 0, StringValue("a"))
+		var err error
+		text, err = tx.InsertObject(list, 1, ObjText)
+		if err != nil {
+			return err
+		}
+		return tx.SpliceText(text, 0, 0, "😀")
+	})
+
+	// Inserting before the text in the same change shifts its path.
+	d.SetTextOptions(TextOptions{Encoding: EncodingUTF16})
+	log := ActivePatchLog()
+	tx, _ := d.Begin()
+	_ = tx.Insert(list, 0, StringValue("b"))
+	_ = tx.SpliceText(text, 2, 0, "x")
+	if _, err := tx.CommitWith(CommitOptions{PatchLog: log}); err != nil {
+		t.Fatal(err)
+	}
+	patches := log.MakePatches()
+	if len(patches) != 2 {
+		t.Fatalf("unexpected patches: %#v", patches)
+	}
+	p := patches[1]
+	if p.Index != 2 || p.InsertText != "x" || len(p.Path) != 2 || p.Path[1].Prop != SeqProp(2) {
+		t.Fatalf("expected a UTF-16 splice at list index 2, got %#v", p)
+	}
+
+	// A log with options overrides the document encoding.
+	other := NewDocument()
+	remote := ActivePatchLogWithOptions(DiffOptions{TextEncoding: EncodingUTF8Bytes})
+	if err := other.ApplyChangesWithActorMap(d.AllChanges(), nil, remote); err != nil {
+		t.Fatal(err)
+	}
+	last := remote.MakePatches()[len(remote.MakePatches())-1]
+	if last.Kind != PatchTextSplice || last.Index != 4 || last.Path[1].Prop != SeqProp(2) {
+		t.Fatalf("expected a byte-indexed splice, got %#v", last)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/cjanietz/automerge-native-go/internal/changegraph"
 	"github.com/cjanietz/automerge-native-go/internal/model"
 	"github.com/cjanietz/automerge-native-go/internal/opset"
+	inttext "github.com/cjanietz/automerge-native-go/internal/text"
 )
 
 var (
@@ -20,7 +21,7 @@ type Document struct {
 	graph *changegraph.Graph
 	ops   *opset.OpSet
 
-	changes   map[model.ChangeHash]Change
+	changes   map[ChangeHash]Change
 	queue     []Change
 	legacyRaw []byte
 	saveCache map[saveCacheKey][]byte
@@ -28,6 +29,15 @@ type Document struct {
 	actor uint32
 	open  *Transaction
 	last  *Change
+	// held are changes received while a transaction was open, applied once it
+	// closes. heldErr collects the failures applying them until FlushHeld
+	// reports them.
+	held    []heldChanges
+	heldErr error
+
+	subs []*subscription
+
+	textOpts TextOptions
 }
 
 type saveCacheKey struct {
@@ -39,11 +49,12 @@ func NewDocument() *Document {
 	return &Document{
 		graph:     changegraph.New(),
 		ops:       opset.New(),
-		changes:   make(map[model.ChangeHash]Change),
+		changes:   make(map[ChangeHash]Change),
 		queue:     nil,
 		legacyRaw: nil,
 		saveCache: make(map[saveCacheKey][]byte),
 		actor:     1,
+		textOpts:  DefaultTextOptions(),
 	}
 }
 
@@ -59,7 +70,7 @@ func (d *Document) Actor() uint32 {
 	return d.actor
 }
 
-func (d *Document) Heads() []model.ChangeHash {
+func (d *Document) Heads() []ChangeHash {
 	return d.graph.Heads()
 }
 
@@ -68,7 +79,7 @@ func (d *Document) LastChange() (*Change, error) {
 		return nil, ErrNoLastCommittedChange
 	}
 	cp := *d.last
-	cp.Deps = append([]model.ChangeHash(nil), d.last.Deps...)
+	cp.Deps = append([]ChangeHash(nil), d.last.Deps...)
 	cp.Operations = append([]ChangeOperation(nil), d.last.Operations...)
 	return &cp, nil
 }
@@ -104,24 +115,57 @@ func (d *Document) transactionClosed(tx *Transaction) {
 	}
 }
 
-func (d *Document) GetMap(obj model.ObjID, key string, at *changegraph.Clock) (opset.Value, bool) {
-	return d.ops.GetMap(obj, key, at)
+type heldChanges struct {
+	changes []Change
+	log     *PatchLog
+}
+
+// applyHeld applies the changes received while the last transaction was open
+// and keeps their failures for FlushHeld.
+func (d *Document) applyHeld() {
+	held := d.held
+	d.held = nil
+	for _, h := range held {
+		if err := d.ApplyChangesWithActorMap(h.changes, nil, h.log); err != nil {
+			d.heldErr = errors.Join(d.heldErr, err)
+		}
+	}
+}
+
+// FlushHeld reports the failures applying changes that were received while a
+// transaction was open, such as through ApplyChanges, Merge or a sync message,
+// and applied when it closed. Those calls returned nil for them and the
+// transaction reports only on itself, so this is where such errors surface.
+// Each failure is reported once. It fails with ErrTransactionOpen while a
+// transaction is open, as the changes are still held.
+func (d *Document) FlushHeld() error {
+	if d.open != nil {
+		return ErrTransactionOpen
+	}
+	d.applyHeld()
+	err := d.heldErr
+	d.heldErr = nil
+	return err
+}
+
+func (d *Document) GetMap(obj ObjID, key string) (Value, bool) {
+	return d.ops.GetMap(obj, key, nil)
 }
 
-func (d *Document) GetMapAt(obj model.ObjID, key string, heads []model.ChangeHash) (opset.Value, bool, error) {
+func (d *Document) GetMapAt(obj ObjID, key string, heads []ChangeHash) (Value, bool, error) {
 	clk, err := d.clockFromHeads(heads)
 	if err != nil {
-		return opset.Value{}, false, err
+		return Value{}, false, err
 	}
 	v, ok := d.ops.GetMap(obj, key, clk)
 	return v, ok, nil
 }
 
-func (d *Document) GetAllMap(obj model.ObjID, key string, at *changegraph.Clock) []opset.Value {
-	return d.ops.GetAllMap(obj, key, at)
+func (d *Document) GetAllMap(obj ObjID, key string) []Value {
+	return d.ops.GetAllMap(obj, key, nil)
 }
 
-func (d *Document) GetAllMapAt(obj model.ObjID, key string, heads []model.ChangeHash) ([]opset.Value, error) {
+func (d *Document) GetAllMapAt(obj ObjID, key string, heads []ChangeHash) ([]Value, error) {
 	clk, err := d.clockFromHeads(heads)
 	if err != nil {
 		return nil, err
@@ -129,11 +173,11 @@ func (d *Document) GetAllMapAt(obj model.ObjID, key string, heads []model.Change
 	return d.ops.GetAllMap(obj, key, clk), nil
 }
 
-func (d *Document) Text(obj model.ObjID, at *changegraph.Clock) string {
-	return d.ops.Text(obj, at)
+func (d *Document) Text(obj ObjID) string {
+	return d.ops.Text(obj, nil)
 }
 
-func (d *Document) TextAt(obj model.ObjID, heads []model.ChangeHash) (string, error) {
+func (d *Document) TextAt(obj ObjID, heads []ChangeHash) (string, error) {
 	clk, err := d.clockFromHeads(heads)
 	if err != nil {
 		return "", err
@@ -141,35 +185,79 @@ func (d *Document) TextAt(obj model.ObjID, heads []model.ChangeHash) (string, er
 	return d.ops.Text(obj, clk), nil
 }
 
-func (d *Document) Marks(obj model.ObjID, at *changegraph.Clock) []opset.Mark {
-	return d.ops.Marks(obj, at)
+// Marks returns the marks in effect on a text object, with ranges counted in
+// the document's text encoding. Where marks of the same name overlap the one
+// with the highest OpID wins, so a mark comes back split around ranges that
+// were unmarked or marked again.
+func (d *Document) Marks(obj ObjID) []Mark {
+	return d.marks(obj, d.textOpts.Encoding, nil)
+}
+
+func (d *Document) MarksAt(obj ObjID, heads []ChangeHash) ([]Mark, error) {
+	clk, err := d.clockFromHeads(heads)
+	if err != nil {
+		return nil, err
+	}
+	return d.marks(obj, d.textOpts.Encoding, clk), nil
+}
+
+func (d *Document) MarksAtIndex(obj ObjID, index int) []Mark {
+	return d.marksAtIndex(obj, index, d.textOpts.Encoding, nil)
 }
 
-func (d *Document) MarksAt(obj model.ObjID, heads []model.ChangeHash) ([]opset.Mark, error) {
+func (d *Document) MarksAtIndexAt(obj ObjID, index int, heads []ChangeHash) ([]Mark, error) {
 	clk, err := d.clockFromHeads(heads)
 	if err != nil {
 		return nil, err
 	}
-	return d.ops.Marks(obj, clk), nil
+	return d.marksAtIndex(obj, index, d.textOpts.Encoding, clk), nil
 }
 
-func (d *Document) MarksAtIndex(obj model.ObjID, index int, at *changegraph.Clock) []opset.Mark {
-	return d.ops.MarksAtIndex(obj, index, at)
+// Length returns the number of keys in a map, elements in a list, or
+// characters in a text object, counted in the document's text encoding.
+// Unknown objects have length 0.
+func (d *Document) Length(obj ObjID) int {
+	return objectLength(d.ops.ViewAt(nil), obj, d.textOpts.Encoding)
 }
 
-func (d *Document) MarksAtIndexAt(obj model.ObjID, index int, heads []model.ChangeHash) ([]opset.Mark, error) {
+func (d *Document) LengthAt(obj ObjID, heads []ChangeHash) (int, error) {
+	clk, err := d.clockFromHeads(heads)
+	if err != nil {
+		return 0, err
+	}
+	return objectLength(d.ops.ViewAt(clk), obj, d.textOpts.Encoding), nil
+}
+
+func (d *Document) GetList(obj ObjID, index int) (Value, bool) {
+	return d.ops.GetList(obj, index, nil)
+}
+
+func (d *Document) GetListAt(obj ObjID, index int, heads []ChangeHash) (Value, bool, error) {
+	clk, err := d.clockFromHeads(heads)
+	if err != nil {
+		return Value{}, false, err
+	}
+	v, ok := d.ops.GetList(obj, index, clk)
+	return v, ok, nil
+}
+
+func (d *Document) GetAllList(obj ObjID, index int) []Value {
+	return d.ops.GetAllList(obj, index, nil)
+}
+
+func (d *Document) GetAllListAt(obj ObjID, index int, heads []ChangeHash) ([]Value, error) {
 	clk, err := d.clockFromHeads(heads)
 	if err != nil {
 		return nil, err
 	}
-	return d.ops.MarksAtIndex(obj, index, clk), nil
+	return d.ops.GetAllList(obj, index, clk), nil
 }
 
-func (d *Document) ListRange(obj model.ObjID, start, end int, at *changegraph.Clock) []opset.Value {
-	return d.ops.ListRange(obj, start, end, at)
+func (d *Document) ListRange(obj ObjID, start, end int) []Value {
+	return d.ops.ListRange(obj, start, end, nil)
 }
 
-func (d *Document) ListRangeAt(obj model.ObjID, start, end int, heads []model.ChangeHash) ([]opset.Value, error) {
+func (d *Document) ListRangeAt(obj ObjID, start, end int, heads []ChangeHash) ([]Value, error) {
 	clk, err := d.clockFromHeads(heads)
 	if err != nil {
 		return nil, err
@@ -177,14 +265,14 @@ func (d *Document) ListRangeAt(obj model.ObjID, start, end int, heads []model.Ch
 	return d.ops.ListRange(obj, start, end, clk), nil
 }
 
-func (d *Document) ClockForHeads(heads []model.ChangeHash) (changegraph.Clock, error) {
+func (d *Document) clockForHeads(heads []ChangeHash) (changegraph.Clock, error) {
 	if len(heads) == 0 {
 		heads = d.graph.Heads()
 	}
 	return d.graph.ClockForHeads(heads)
 }
 
-func (d *Document) KeysMapAt(obj model.ObjID, heads []model.ChangeHash) ([]string, error) {
+func (d *Document) KeysMapAt(obj ObjID, heads []ChangeHash) ([]string, error) {
 	clk, err := d.clockFromHeads(heads)
 	if err != nil {
 		return nil, err
@@ -192,7 +280,7 @@ func (d *Document) KeysMapAt(obj model.ObjID, heads []model.ChangeHash) ([]strin
 	return d.ops.KeysMap(obj, clk), nil
 }
 
-func (d *Document) ValuesMapAt(obj model.ObjID, heads []model.ChangeHash) ([]opset.Value, error) {
+func (d *Document) ValuesMapAt(obj ObjID, heads []ChangeHash) ([]Value, error) {
 	clk, err := d.clockFromHeads(heads)
 	if err != nil {
 		return nil, err
@@ -200,9 +288,9 @@ func (d *Document) ValuesMapAt(obj model.ObjID, heads []model.ChangeHash) ([]ops
 	return d.ops.ValuesMap(obj, clk), nil
 }
 
-func (d *Document) IterMapAt(obj model.ObjID, heads []model.ChangeHash) ([]struct {
+func (d *Document) IterMapAt(obj ObjID, heads []ChangeHash) ([]struct {
 	Key   string
-	Value opset.Value
+	Value Value
 }, error) {
 	clk, err := d.clockFromHeads(heads)
 	if err != nil {
@@ -211,7 +299,21 @@ func (d *Document) IterMapAt(obj model.ObjID, heads []model.ChangeHash) ([]struc
 	return d.ops.IterMap(obj, clk), nil
 }
 
-func (d *Document) dependenciesForActorSeq(actor uint32, seq uint64) []model.ChangeHash {
+func objectLength(view *opset.View, obj ObjID, enc Encoding) int {
+	typ, ok := view.ObjectType(obj)
+	if !ok {
+		return 0
+	}
+	if typ == opset.ObjMap {
+		return len(view.KeysMap(obj))
+	}
+	if typ == opset.ObjText && enc != EncodingUTF8 {
+		return inttext.Length(view.Text(obj), enc)
+	}
+	return view.ListLength(obj)
+}
+
+func (d *Document) dependenciesForActorSeq(actor uint32, seq uint64) []ChangeHash {
 	deps := d.graph.Heads()
 	if seq > 1 {
 		if prev, ok := d.graph.HashForActorSeq(actor, seq-1); ok && !slices.Contains(deps, prev) {
@@ -222,7 +324,7 @@ func (d *Document) dependenciesForActorSeq(actor uint32, seq uint64) []model.Cha
 	return deps
 }
 
-func (d *Document) hasChange(hash model.ChangeHash) bool {
+func (d *Document) hasChange(hash ChangeHash) bool {
 	return d.graph.HasChange(hash)
 }
 
@@ -0,0 +1,66 @@
+package automerge
+
+import (
+	"math/rand/v2"
+	"slices"
+)
+
+// Fork returns an independent copy of the document with a fresh actor. Changes
+// made on either side can be merged into the other.
+func (d *Document) Fork() (*Document, error) {
+	fork, err := d.ForkAt(d.Heads())
+	if err != nil {
+		return nil, err
+	}
+	if len(d.changes) == 0 && len(d.legacyRaw) > 0 {
+		fork.legacyRaw = append([]byte(nil), d.legacyRaw...)
+	}
+	return fork, nil
+}
+
+// ForkAt is like Fork, but the copy only holds the history up to heads.
+// Empty heads fork the empty document. The fork keeps the text options, so
+// text indexes mean the same on both sides.
+func (d *Document) ForkAt(heads []ChangeHash) (*Document, error) {
+	fork := NewDocument()
+	fork.actor = d.freshActor()
+	fork.textOpts = d.textOpts
+	if len(heads) == 0 {
+		return fork, nil
+	}
+	hashes, err := d.graph.GetHashesFromHeads(heads)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]Change, 0, len(hashes))
+	for _, h := range hashes {
+		changes = append(changes, d.changes[h])
+	}
+	if err := fork.ApplyChanges(changes); err != nil {
+		return nil, err
+	}
+	return fork, nil
+}
+
+// freshActor picks a random actor that has no changes in the history and is
+// not the document's own, so forks of the same document, and the changes of
+// BeginAt made under it, do not collide.
+func (d *Document) freshActor() uint32 {
+	used := d.graph.ActorIDs()
+	for {
+		a := rand.Uint32()
+		if a != 0 && a != d.actor && !slices.Contains(used, a) {
+			return a
+		}
+	}
+}
+
+// Fork returns an AutoCommit over a fork of the document. The diff cursor is
+// carried over.
+func (a *AutoCommit) Fork() (*AutoCommit, error) {
+	doc, err := a.doc.Fork()
+	if err != nil {
+		return nil, err
+	}
+	return &AutoCommit{doc: doc, diffCursor: a.DiffCursor()}, nil
+}
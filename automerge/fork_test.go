@@ -0,0 +1,113 @@
+package automerge
+
+import "testing"
+
+func TestForkAndMergeBack(t *testing.T) {
+	d := NewDocument()
+	tx, _ := d.Begin()
+	_ = tx.Put(RootObjID(), "title", StringValue("draft"))
+	_, _ = tx.Commit()
+
+	fork, err := d.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.Actor() == d.Actor() || fork.Actor() == 0 {
+		t.Fatalf("expected a fresh actor, got %d", fork.Actor())
+	}
+	if v, ok := fork.GetMap(RootObjID(), "title"); !ok || v.Scalar.String != "draft" {
+		t.Fatalf("fork missing history: %#v", v)
+	}
+
+	ftx, _ := fork.Begin()
+	_ = ftx.Put(RootObjID(), "title", StringValue("reviewed"))
+	_, _ = ftx.Commit()
+	dtx, _ := d.Begin()
+	_ = dtx.Put(RootObjID(), "body", StringValue("text"))
+	_, _ = dtx.Commit()
+
+	if v, _ := d.GetMap(RootObjID(), "title"); v.Scalar.String != "draft" {
+		t.Fatalf("fork changes leaked into the original: %#v", v)
+	}
+	if err := d.Merge(fork); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := d.GetMap(RootObjID(), "title"); v.Scalar.String != "reviewed" {
+		t.Fatalf("unexpected merged title: %#v", v)
+	}
+	if err := fork.Merge(d); err != nil {
+		t.Fatal(err)
+	}
+	if !hashesEqual(fork.Heads(), d.Heads()) {
+		t.Fatalf("documents did not converge: %v vs %v", fork.Heads(), d.Heads())
+	}
+}
+
+func TestForkAtHeads(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	_ = tx1.Put(RootObjID(), "k", StringValue("v1"))
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+	tx2, _ := d.Begin()
+	_ = tx2.Put(RootObjID(), "k", StringValue("v2"))
+	_, _ = tx2.Commit()
+
+	fork, err := d.ForkAt(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hashesEqual(fork.Heads(), h1) {
+		t.Fatalf("unexpected fork heads: %v", fork.Heads())
+	}
+	if v, _ := fork.GetMap(RootObjID(), "k"); v.Scalar.String != "v1" {
+		t.Fatalf("unexpected forked value: %#v", v)
+	}
+
+	// Two forks of the same document can both merge back.
+	other, _ := d.ForkAt(h1)
+	for _, f := range []*Document{fork, other} {
+		ftx, _ := f.Begin()
+		_ = ftx.Put(RootObjID(), "k", IntValue(int64(f.Actor())))
+		_, _ = ftx.Commit()
+		if err := d.Merge(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(d.Heads()); got != 3 {
+		t.Fatalf("expected three concurrent heads, got %d", got)
+	}
+
+	if _, err := d.ForkAt([]ChangeHash{{1}}); err == nil {
+		t.Fatal("expected an error for unknown heads")
+	}
+}
+
+func TestForkKeepsTextOptions(t *testing.T) {
+	d := NewDocument()
+	d.SetTextOptions(TextOptions{Encoding: EncodingUTF16, Graphemes: true})
+	var text ObjID
+	commitTx(t, d, func(tx *Transaction) error {
+		text, _ = tx.PutObject(RootObjID(), "text", ObjText)
+		return tx.SpliceText(text, 0, 0, "😀b")
+	})
+	h1 := d.Heads()
+	fork, err := d.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	at, err := d.ForkAt(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []*Document{fork, at} {
+		if f.TextOptions() != d.TextOptions() {
+			t.Fatalf("unexpected fork text options: %#v", f.TextOptions())
+		}
+		// Index 2 is after the emoji in UTF-16 units.
+		commitTx(t, f, func(tx *Transaction) error { return tx.SpliceText(text, 2, 0, "!") })
+		if got := f.Text(text); got != "😀!b" {
+			t.Fatalf("unexpected text on fork: %q", got)
+		}
+	}
+}
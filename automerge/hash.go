@@ -18,10 +18,10 @@ func computeChangeHash(
 	seq uint64,
 	startOp uint64,
 	maxOp uint64,
-	deps []model.ChangeHash,
+	deps []ChangeHash,
 	opts CommitOptions,
 	ops []ChangeOperation,
-) model.ChangeHash {
+) ChangeHash {
 	h := changeHasherPool.Get().(*model.ChangeHasher)
 	h.Reset()
 	defer changeHasherPool.Put(h)
@@ -59,6 +59,28 @@ func computeChangeHash(
 		h.WriteInt64(int64(op.Start))
 		h.WriteInt64(int64(op.End))
 		h.WriteString(op.MarkName)
+		if op.Expand != ExpandAfter {
+			// Only hashed when set, so changes made before marks had a
+			// policy keep their hashes.
+			h.WriteUint64(uint64(op.Expand))
+		}
+		if op.Identified {
+			// Likewise only hashed for ops that carry identities.
+			h.WriteBool(true)
+			h.WriteOpID(op.StartAnchor.Elem)
+			h.WriteBool(op.StartAnchor.After)
+			h.WriteOpID(op.EndAnchor.Elem)
+			h.WriteBool(op.EndAnchor.After)
+			h.WriteOpID(op.Elem)
+			h.WriteUint64(uint64(len(op.Deleted)))
+			for _, id := range op.Deleted {
+				h.WriteOpID(id)
+			}
+			h.WriteUint64(uint64(len(op.Pred)))
+			for _, id := range op.Pred {
+				h.WriteOpID(id)
+			}
+		}
 		h.WriteUint64(uint64(op.ObjType))
 		h.WriteOpID(op.OpID)
 		h.WriteUint64(uint64(op.Value.Kind))
@@ -0,0 +1,32 @@
+package automerge
+
+import "github.com/cjanietz/automerge-native-go/internal/model"
+
+// BeginAt opens a transaction isolated at heads. Its mutations are resolved
+// against the document as of heads plus the transaction's own edits: indexes
+// count the elements visible then, and puts and deletes supersede only the
+// values visible then, so they conflict with later concurrent writes instead
+// of overwriting them. The resulting change depends on heads alone and merges
+// as a concurrent change, on this document and on any peer.
+//
+// A change depends on the previous change of its actor, so when the
+// document's actor has changes that heads does not include, the transaction
+// commits under a fresh actor instead of the document's own.
+func (d *Document) BeginAt(heads []ChangeHash) (*Transaction, error) {
+	if d.open != nil {
+		return nil, ErrTransactionOpen
+	}
+	clk, err := d.clockFromHeads(heads)
+	if err != nil {
+		return nil, err
+	}
+	tx := newTransaction(d)
+	if !clk.Covers(d.actor, d.graph.MaxOpForActor(d.actor)) {
+		tx.cp.actor, tx.cp.seq = d.freshActor(), 1
+	}
+	tx.isolated = clk
+	tx.cp.deps = append([]ChangeHash(nil), heads...)
+	model.SortChangeHashes(tx.cp.deps)
+	d.open = tx
+	return tx, nil
+}
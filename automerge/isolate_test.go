@@ -0,0 +1,198 @@
+package automerge
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBeginAtMapWritesConflictWithLaterChanges(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	_ = tx1.Put(RootObjID(), "k", StringValue("v1"))
+	_ = tx1.Put(RootObjID(), "gone", StringValue("x"))
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+	tx2, _ := d.Begin()
+	_ = tx2.Put(RootObjID(), "k", StringValue("v2"))
+	_, _ = tx2.Commit()
+	h2 := d.Heads()
+
+	iso, err := d.BeginAt(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Begin(); !errors.Is(err, ErrTransactionOpen) {
+		t.Fatalf("expected an open transaction error, got %v", err)
+	}
+	_ = iso.Put(RootObjID(), "k", StringValue("suggested"))
+	_ = iso.DeleteMap(RootObjID(), "gone")
+	change, err := iso.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hashesEqual(change.Deps, h1) {
+		t.Fatalf("expected deps %v, got %v", h1, change.Deps)
+	}
+	if heads := d.Heads(); len(heads) != 2 {
+		t.Fatalf("expected the isolated change to be concurrent, heads=%v", heads)
+	}
+	if all := d.GetAllMap(RootObjID(), "k"); len(all) != 2 {
+		t.Fatalf("expected v2 and the suggestion to conflict, got %#v", all)
+	}
+	if _, ok := d.GetMap(RootObjID(), "gone"); ok {
+		t.Fatal("expected the isolated delete to apply")
+	}
+	if v, _, _ := d.GetMapAt(RootObjID(), "k", h2); v.Scalar.String != "v2" {
+		t.Fatalf("unexpected value at h2: %#v", v)
+	}
+}
+
+func TestBeginAtResolvesIndexesAtHeads(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	text, _ := tx1.PutObject(RootObjID(), "text", ObjText)
+	_ = tx1.SpliceText(text, 0, 0, "abcd")
+	list, _ := tx1.PutObject(RootObjID(), "list", ObjList)
+	_ = tx1.Insert(list, 0, IntValue(1))
+	_ = tx1.Insert(list, 1, IntValue(2))
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+
+	tx2, _ := d.Begin()
+	_ = tx2.SpliceText(text, 0, 0, "XY")
+	_ = tx2.Insert(list, 0, IntValue(0))
+	_, _ = tx2.Commit()
+
+	iso, _ := d.BeginAt(h1)
+	_ = iso.SpliceText(text, 1, 2, "BC")
+	_ = iso.SpliceText(text, 4, 0, "!")
+	_ = iso.Insert(list, 1, IntValue(15))
+	_ = iso.DeleteList(list, 0)
+	if _, err := iso.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Text(text); got != "XYaBCd!" {
+		t.Fatalf("unexpected text: %q", got)
+	}
+	vals := d.ListRange(list, 0, -1)
+	if len(vals) != 3 || vals[0].Scalar.Int != 0 || vals[1].Scalar.Int != 15 || vals[2].Scalar.Int != 2 {
+		t.Fatalf("unexpected list: %#v", vals)
+	}
+	// The history at h1 is untouched by both later changes.
+	if got, _ := d.TextAt(text, h1); got != "abcd" {
+		t.Fatalf("unexpected text at h1: %q", got)
+	}
+}
+
+func TestBeginAtChangeReadsOnlyItsHeads(t *testing.T) {
+	d := NewDocument()
+	commitTx(t, d, func(tx *Transaction) error { return tx.Put(RootObjID(), "x", IntValue(1)) })
+	h1 := d.Heads()
+	commitTx(t, d, func(tx *Transaction) error { return tx.Put(RootObjID(), "x", IntValue(2)) })
+
+	iso, err := d.BeginAt(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = iso.Put(RootObjID(), "y", IntValue(3))
+	change, err := iso.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The actor's second change is not below h1, so the isolated change
+	// cannot be its third.
+	if change.Actor == d.Actor() || change.Seq != 1 {
+		t.Fatalf("expected a fresh actor, got actor=%d seq=%d", change.Actor, change.Seq)
+	}
+	at := []ChangeHash{change.Hash}
+	if v, _, _ := d.GetMapAt(RootObjID(), "x", at); v.Scalar.Int != 1 {
+		t.Fatalf("expected x=1 at the isolated change, got %#v", v)
+	}
+	if v, _, _ := d.GetMapAt(RootObjID(), "y", at); v.Scalar.Int != 3 {
+		t.Fatalf("expected y=3 at the isolated change, got %#v", v)
+	}
+
+	// At the latest heads the actor is kept.
+	iso, _ = d.BeginAt(d.Heads())
+	_ = iso.Put(RootObjID(), "z", IntValue(4))
+	if change, err := iso.Commit(); err != nil || change.Actor != d.Actor() {
+		t.Fatalf("expected the document's actor, got %#v %v", change, err)
+	}
+}
+
+func TestBeginAtSpliceOverConcurrentDelete(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	text, _ := tx1.PutObject(RootObjID(), "text", ObjText)
+	_ = tx1.SpliceText(text, 0, 0, "abc")
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+	tx2, _ := d.Begin()
+	_ = tx2.SpliceText(text, 1, 1, "")
+	_, _ = tx2.Commit()
+
+	// "b" is already gone; the replacement still lands where it was.
+	iso, _ := d.BeginAt(h1)
+	if err := iso.SpliceText(text, 1, 1, "B"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iso.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Text(text); got != "aBc" {
+		t.Fatalf("unexpected text: %q", got)
+	}
+}
+
+func TestBeginAtChangeAppliesOnPeerAtHeads(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	text, _ := tx1.PutObject(RootObjID(), "text", ObjText)
+	_ = tx1.SpliceText(text, 0, 0, "abcd")
+	_ = tx1.Put(RootObjID(), "k", StringValue("v1"))
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+	peer, err := d.ForkAt(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.SetActor(2)
+	tx2, _ := d.Begin()
+	_ = tx2.SpliceText(text, 0, 0, "XY")
+	_ = tx2.Put(RootObjID(), "k", StringValue("v2"))
+	_, _ = tx2.Commit()
+
+	iso, _ := d.BeginAt(h1)
+	_ = iso.SpliceText(text, 4, 0, "!")
+	_ = iso.Put(RootObjID(), "k", StringValue("suggested"))
+	change, err := iso.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Operations[0].Index != 4 {
+		t.Fatalf("expected the index at h1, got %d", change.Operations[0].Index)
+	}
+
+	// The peer has only h1, so it applies the change without the later one.
+	if err := peer.ApplyChanges([]Change{*change}); err != nil {
+		t.Fatal(err)
+	}
+	if got := peer.Text(text); got != "abcd!" {
+		t.Fatalf("unexpected text on peer: %q", got)
+	}
+	if v, _ := peer.GetMap(RootObjID(), "k"); v.Scalar.String != "suggested" {
+		t.Fatalf("unexpected value on peer: %#v", v)
+	}
+	// Once it has both, it agrees with the document.
+	if err := peer.Merge(d); err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []*Document{d, peer} {
+		if got := doc.Text(text); got != "XYabcd!" {
+			t.Fatalf("unexpected text: %q", got)
+		}
+		if all := doc.GetAllMap(RootObjID(), "k"); len(all) != 2 {
+			t.Fatalf("expected v2 and the suggestion to conflict, got %#v", all)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package automerge
+
+import (
+	"iter"
+	"unicode/utf8"
+
+	"github.com/cjanietz/automerge-native-go/internal/model"
+	"github.com/cjanietz/automerge-native-go/internal/opset"
+)
+
+// MapEntries yields the current key/value pairs of a map in sorted key order.
+// Only the keys are collected up front; each value is read as it is reached,
+// so a key deleted while iterating is skipped and a key added is not seen.
+func (d *Document) MapEntries(obj ObjID) iter.Seq2[string, Value] {
+	return d.ops.CurrentView().MapEntries(obj)
+}
+
+// ListItems yields the current elements of a list or text object with their
+// indexes, reading one element at a time. Iteration carries on after the
+// element yielded last, so an edit made while iterating is seen only if it
+// lands after it.
+func (d *Document) ListItems(obj ObjID) iter.Seq2[int, Value] {
+	return d.ops.CurrentView().ListItems(obj)
+}
+
+// TextRunes yields the runes of a text object with their rune indexes. Like
+// ListItems it reads the text as it goes.
+func (d *Document) TextRunes(obj ObjID) iter.Seq2[int, rune] {
+	return func(yield func(int, rune) bool) {
+		index := 0
+		for _, v := range d.ops.CurrentView().ListItems(obj) {
+			if v.Kind != opset.ValueScalar || v.Scalar.Kind != model.ScalarString {
+				continue
+			}
+			for s := v.Scalar.String; s != ""; {
+				r, size := utf8.DecodeRuneInString(s)
+				s = s[size:]
+				if !yield(index, r) {
+					return
+				}
+				index++
+			}
+		}
+	}
+}
+
+// Changes yields every applied change in causal order, keyed by hash.
+func (d *Document) Changes() iter.Seq2[ChangeHash, Change] {
+	return func(yield func(ChangeHash, Change) bool) {
+		hashes, err := d.graph.GetHashesFromHeads(d.Heads())
+		if err != nil {
+			return
+		}
+		for _, h := range hashes {
+			c, ok := d.changes[h]
+			if !ok {
+				continue
+			}
+			if !yield(h, deepCopyChange(c)) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package automerge
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cjanietz/automerge-native-go/internal/changegraph"
+	"github.com/cjanietz/automerge-native-go/internal/model"
+	"github.com/cjanietz/automerge-native-go/internal/opset"
+)
+
+var (
+	ErrJSONUnsupportedValue = errors.New("json unsupported value")
+	ErrJSONInvalidNumber    = errors.New("json invalid number")
+	ErrJSONNotObject        = errors.New("json value is not an object")
+	ErrJSONTrailingData     = errors.New("json trailing data after value")
+	ErrJSONConflictsKey     = errors.New("json map has a key named like the conflicts member")
+)
+
+// JSONConflictsKey is the member added to exported maps that hold
+// conflicting values when JSONOptions.IncludeConflicts is set. Exporting with
+// conflicts fails with ErrJSONConflictsKey for a map that has a key of that
+// name, which could not be told apart from the member.
+const JSONConflictsKey = "_conflicts"
+
+// JSONOptions controls Document.ToJSONWithOptions.
+//
+// Scalars are encoded as follows: strings, booleans and null as their JSON
+// counterparts; int, uint, f64 and counter values as numbers; timestamps as
+// RFC 3339 strings in UTC; bytes and unknown values as standard base64
+// strings. Text objects are exported as plain strings. NaN and infinite
+// floats cannot be represented and fail with ErrJSONUnsupportedValue.
+type JSONOptions struct {
+	// IncludeConflicts adds a JSONConflictsKey member to every map that has
+	// conflicting keys. It maps each such key to all of its values in OpID
+	// order; the last entry is the winner that also appears under the key.
+	IncludeConflicts bool
+}
+
+func DefaultJSONOptions() JSONOptions {
+	return JSONOptions{IncludeConflicts: false}
+}
+
+// ToJSON exports obj and everything below it. Empty heads export the current
+// state.
+func (d *Document) ToJSON(obj ObjID, heads []ChangeHash) ([]byte, error) {
+	return d.ToJSONWithOptions(obj, heads, DefaultJSONOptions())
+}
+
+func (d *Document) ToJSONWithOptions(obj ObjID, heads []ChangeHash, opts JSONOptions) ([]byte, error) {
+	var at *changegraph.Clock
+	if len(heads) > 0 {
+		clk, err := d.clockFromHeads(heads)
+		if err != nil {
+			return nil, err
+		}
+		at = clk
+	}
+	view := d.ops.ViewAt(at)
+	typ, ok := view.ObjectType(obj)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", opset.ErrUnknownObject, obj)
+	}
+	tree, err := exportObjectJSON(view, obj, typ, opts)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tree)
+}
+
+func exportObjectJSON(view *opset.View, obj ObjID, typ opset.ObjType, opts JSONOptions) (any, error) {
+	switch typ {
+	case opset.ObjMap:
+		keys := view.KeysMap(obj)
+		if _, found := slices.BinarySearch(keys, JSONConflictsKey); opts.IncludeConflicts && found {
+			return nil, fmt.Errorf("%w: %s in %s", ErrJSONConflictsKey, JSONConflictsKey, obj)
+		}
+		out := make(map[string]any, len(keys))
+		var conflicts map[string]any
+		for _, k := range keys {
+			v, ok := view.GetMap(obj, k)
+			if !ok {
+				continue
+			}
+			ev, err := exportValueJSON(view, v, opts)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = ev
+			if !opts.IncludeConflicts {
+				continue
+			}
+			all := view.GetAllMap(obj, k)
+			if len(all) < 2 {
+				continue
+			}
+			alts := make([]any, 0, len(all))
+			for _, alt := range all {
+				ea, err := exportValueJSON(view, alt, opts)
+				if err != nil {
+					return nil, err
+				}
+				alts = append(alts, ea)
+			}
+			if conflicts == nil {
+				conflicts = make(map[string]any)
+			}
+			conflicts[k] = alts
+		}
+		if conflicts != nil {
+			out[JSONConflictsKey] = conflicts
+		}
+		return out, nil
+	case opset.ObjList:
+		vals := view.ListRange(obj, 0, -1)
+		out := make([]any, 0, len(vals))
+		for _, v := range vals {
+			ev, err := exportValueJSON(view, v, opts)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, ev)
+		}
+		return out, nil
+	case opset.ObjText:
+		return view.Text(obj), nil
+	default:
+		return nil, fmt.Errorf("%w: object type %s", ErrJSONUnsupportedValue, typ)
+	}
+}
+
+func exportValueJSON(view *opset.View, v Value, opts JSONOptions) (any, error) {
+	if v.Kind == opset.ValueObject {
+		return exportObjectJSON(view, v.Object.ID, v.Object.Type, opts)
+	}
+	return scalarToJSON(v.Scalar)
+}
+
+func scalarToJSON(s ScalarValue) (any, error) {
+	switch s.Kind {
+	case model.ScalarNull:
+		return nil, nil
+	case model.ScalarBytes, model.ScalarUnknown:
+		return base64.StdEncoding.EncodeToString(s.Bytes), nil
+	case model.ScalarString:
+		return s.String, nil
+	case model.ScalarInt:
+		return s.Int, nil
+	case model.ScalarUint:
+		return s.Uint, nil
+	case model.ScalarF64:
+		if math.IsNaN(s.F64) || math.IsInf(s.F64, 0) {
+			return nil, fmt.Errorf("%w: f64 %v", ErrJSONUnsupportedValue, s.F64)
+		}
+		return s.F64, nil
+	case model.ScalarCounter:
+		return s.Counter, nil
+	case model.ScalarTimestamp:
+		return time.UnixMilli(s.Time).UTC().Format(time.RFC3339Nano), nil
+	case model.ScalarBoolean:
+		return s.Boolean, nil
+	default:
+		return nil, fmt.Errorf("%w: scalar kind %d", ErrJSONUnsupportedValue, s.Kind)
+	}
+}
+
+type JSONStringMode uint8
+
+const (
+	// JSONStringScalar imports strings as string scalars.
+	JSONStringScalar JSONStringMode = iota
+	// JSONStringText imports strings as text objects.
+	JSONStringText
+)
+
+type JSONNumberMode uint8
+
+const (
+	// JSONNumberAuto imports integral numbers as int, integral numbers above
+	// math.MaxInt64 as uint, and everything else as f64.
+	JSONNumberAuto JSONNumberMode = iota
+	JSONNumberInt
+	JSONNumberUint
+	JSONNumberF64
+)
+
+// JSONImportOptions controls how Transaction.PutJSONWithOptions maps JSON
+// values onto document values. Objects always become maps and arrays lists;
+// object members are written in sorted key order so that identical input
+// produces identical changes.
+type JSONImportOptions struct {
+	Strings JSONStringMode
+	Numbers JSONNumberMode
+}
+
+func DefaultJSONImportOptions() JSONImportOptions {
+	return JSONImportOptions{Strings: JSONStringScalar, Numbers: JSONNumberAuto}
+}
+
+func (tx *Transaction) PutJSON(obj ObjID, key string, raw json.RawMessage) error {
+	return tx.PutJSONWithOptions(obj, key, raw, DefaultJSONImportOptions())
+}
+
+func (tx *Transaction) PutJSONWithOptions(obj ObjID, key string, raw json.RawMessage, opts JSONImportOptions) error {
+	if err := tx.ensureOpen(); err != nil {
+		return err
+	}
+	v, err := decodeJSONValue(raw)
+	if err != nil {
+		return err
+	}
+	return tx.importJSONValue(jsonSlot{obj: obj, key: key}, v, opts)
+}
+
+// FromJSON writes every member of the JSON object raw into the root map as a
+// single change.
+func (a *AutoCommit) FromJSON(raw json.RawMessage) (*Change, error) {
+	return a.FromJSONWithOptions(raw, DefaultJSONImportOptions())
+}
+
+func (a *AutoCommit) FromJSONWithOptions(raw json.RawMessage, opts JSONImportOptions) (*Change, error) {
+	v, err := decodeJSONValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	members, ok := v.(map[string]any)
+	if !ok {
+		return nil, ErrJSONNotObject
+	}
+	tx, err := a.doc.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.importJSONMembers(model.RootObjID(), members, opts); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return tx.Commit()
+}
+
+// decodeJSONValue decodes raw, which must hold a single JSON value and
+// nothing but whitespace after it.
+func decodeJSONValue(raw json.RawMessage) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, ErrJSONTrailingData
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%w: %v", ErrJSONTrailingData, err)
+	}
+	return v, nil
+}
+
+// jsonSlot is the map key or list index an imported value is written to.
+type jsonSlot struct {
+	obj    ObjID
+	key    string
+	index  int
+	inList bool
+}
+
+func (tx *Transaction) putJSONObject(slot jsonSlot, typ ObjType) (ObjID, error) {
+	if slot.inList {
+		return tx.InsertObject(slot.obj, slot.index, typ)
+	}
+	return tx.PutObject(slot.obj, slot.key, typ)
+}
+
+func (tx *Transaction) putJSONScalar(slot jsonSlot, value ScalarValue) error {
+	if slot.inList {
+		return tx.Insert(slot.obj, slot.index, value)
+	}
+	return tx.Put(slot.obj, slot.key, value)
+}
+
+func (tx *Transaction) importJSONValue(slot jsonSlot, v any, opts JSONImportOptions) error {
+	switch x := v.(type) {
+	case map[string]any:
+		child, err := tx.putJSONObject(slot, ObjMap)
+		if err != nil {
+			return err
+		}
+		return tx.importJSONMembers(child, x, opts)
+	case []any:
+		child, err := tx.putJSONObject(slot, ObjList)
+		if err != nil {
+			return err
+		}
+		for i, item := range x {
+			if err := tx.importJSONValue(jsonSlot{obj: child, index: i, inList: true}, item, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	case string:
+		if opts.Strings == JSONStringText {
+			child, err := tx.putJSONObject(slot, ObjText)
+			if err != nil {
+				return err
+			}
+			return tx.SpliceText(child, 0, 0, x)
+		}
+		return tx.putJSONScalar(slot, model.StringValue(x))
+	case json.Number:
+		n, err := jsonNumberToScalar(x, opts.Numbers)
+		if err != nil {
+			return err
+		}
+		return tx.putJSONScalar(slot, n)
+	case bool:
+		return tx.putJSONScalar(slot, model.BoolValue(x))
+	case nil:
+		return tx.putJSONScalar(slot, model.Null())
+	default:
+		return fmt.Errorf("%w: %T", ErrJSONUnsupportedValue, v)
+	}
+}
+
+func (tx *Transaction) importJSONMembers(obj ObjID, members map[string]any, opts JSONImportOptions) error {
+	keys := make([]string, 0, len(members))
+	for k := range members {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := tx.importJSONValue(jsonSlot{obj: obj, key: k}, members[k], opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func jsonNumberToScalar(n json.Number, mode JSONNumberMode) (ScalarValue, error) {
+	s := n.String()
+	switch mode {
+	case JSONNumberInt:
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return ScalarValue{}, fmt.Errorf("%w: %s as int", ErrJSONInvalidNumber, s)
+		}
+		return model.IntValue(v), nil
+	case JSONNumberUint:
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return ScalarValue{}, fmt.Errorf("%w: %s as uint", ErrJSONInvalidNumber, s)
+		}
+		return model.UintValue(v), nil
+	case JSONNumberF64:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return ScalarValue{}, fmt.Errorf("%w: %s as f64", ErrJSONInvalidNumber, s)
+		}
+		return model.F64Value(v), nil
+	default:
+		if !strings.ContainsAny(s, ".eE") {
+			if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return model.IntValue(v), nil
+			}
+			if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+				return model.UintValue(v), nil
+			}
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return ScalarValue{}, fmt.Errorf("%w: %s", ErrJSONInvalidNumber, s)
+		}
+		return model.F64Value(v), nil
+	}
+}
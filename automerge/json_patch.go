@@ -0,0 +1,178 @@
+package automerge
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cjanietz/automerge-native-go/internal/changegraph"
+	"github.com/cjanietz/automerge-native-go/internal/opset"
+)
+
+// Operation names used in JSONPatchOp.Op. Add, remove and replace are the
+// RFC 6902 operations; JSONPatchSplice is an extension for text edits whose
+// value is a JSONSpliceValue.
+const (
+	JSONPatchAdd     = "add"
+	JSONPatchRemove  = "remove"
+	JSONPatchReplace = "replace"
+	JSONPatchSplice  = "x-splice"
+)
+
+// JSONPatchOp is one RFC 6902 operation. Path is a JSON Pointer (RFC 6901)
+// from the document root.
+type JSONPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONSpliceValue is the value of a JSONPatchSplice op: delete Remove units of
+// the string at Path starting at Index, then insert Insert there. Units are
+// those of the DiffOptions.TextEncoding the patches were made with.
+type JSONSpliceValue struct {
+	Index  int    `json:"index"`
+	Remove int    `json:"remove"`
+	Insert string `json:"insert"`
+}
+
+type JSONTextMode uint8
+
+const (
+	// JSONTextReplace turns the splices of a text object into one replace of
+	// the whole string with its value at the target heads. Plain RFC 6902
+	// clients can apply it.
+	JSONTextReplace JSONTextMode = iota
+	// JSONTextSplice keeps each splice as a JSONPatchSplice op.
+	JSONTextSplice
+)
+
+// JSONPatchOptions controls Document.ToJSONPatchWithOptions. Values are encoded
+// as in JSONOptions; new maps, lists and text objects are added empty and
+// filled by the ops that follow. Mark patches have no JSON form and are
+// dropped.
+type JSONPatchOptions struct {
+	Text JSONTextMode
+}
+
+func DefaultJSONPatchOptions() JSONPatchOptions {
+	return JSONPatchOptions{Text: JSONTextReplace}
+}
+
+// ToJSONPatch converts patches, as returned by Diff, into JSON Patch
+// operations. heads are the after heads of the diff, used to resolve counter
+// values and whole strings; empty heads mean the current state.
+func (d *Document) ToJSONPatch(patches []Patch, heads []ChangeHash) ([]JSONPatchOp, error) {
+	return d.ToJSONPatchWithOptions(patches, heads, DefaultJSONPatchOptions())
+}
+
+func (d *Document) ToJSONPatchWithOptions(patches []Patch, heads []ChangeHash, opts JSONPatchOptions) ([]JSONPatchOp, error) {
+	var at *changegraph.Clock
+	if len(heads) > 0 {
+		clk, err := d.clockFromHeads(heads)
+		if err != nil {
+			return nil, err
+		}
+		at = clk
+	}
+	view := d.ops.ViewAt(at)
+	replacedText := map[ObjID]struct{}{}
+
+	var out []JSONPatchOp
+	emit := func(op, path string, value any) error {
+		jp := JSONPatchOp{Op: op, Path: path}
+		if op != JSONPatchRemove {
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			jp.Value = raw
+		}
+		out = append(out, jp)
+		return nil
+	}
+	for _, p := range patches {
+		base := jsonPointer(p.Path)
+		var err error
+		switch p.Kind {
+		case PatchMapPut:
+			op := JSONPatchReplace
+			if p.OldValue == nil {
+				op = JSONPatchAdd
+			}
+			err = emitJSONValue(emit, op, base+"/"+escapeJSONPointer(p.Key), p.NewValue)
+		case PatchMapDelete:
+			err = emit(JSONPatchRemove, base+"/"+escapeJSONPointer(p.Key), nil)
+		case PatchIncrement:
+			v, ok := view.GetMap(p.ObjID, p.Key)
+			if !ok {
+				continue
+			}
+			err = emitJSONValue(emit, JSONPatchReplace, base+"/"+escapeJSONPointer(p.Key), &v)
+		case PatchListInsert:
+			for i := range p.Values {
+				if err = emitJSONValue(emit, JSONPatchAdd, fmt.Sprintf("%s/%d", base, p.Index+i), &p.Values[i]); err != nil {
+					break
+				}
+			}
+		case PatchListDelete:
+			for range p.Count {
+				if err = emit(JSONPatchRemove, fmt.Sprintf("%s/%d", base, p.Index), nil); err != nil {
+					break
+				}
+			}
+		case PatchListPut:
+			err = emitJSONValue(emit, JSONPatchReplace, fmt.Sprintf("%s/%d", base, p.Index), p.NewValue)
+		case PatchTextSplice:
+			if opts.Text == JSONTextSplice {
+				err = emit(JSONPatchSplice, base, JSONSpliceValue{Index: p.Index, Remove: p.DeleteCount, Insert: p.InsertText})
+				break
+			}
+			if _, ok := replacedText[p.ObjID]; ok {
+				continue
+			}
+			replacedText[p.ObjID] = struct{}{}
+			err = emit(JSONPatchReplace, base, view.Text(p.ObjID))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// emitJSONValue encodes a patch value. Objects are emitted empty, since the
+// patches describing their content follow.
+func emitJSONValue(emit func(op, path string, value any) error, op, path string, v *Value) error {
+	if v == nil {
+		return emit(op, path, nil)
+	}
+	if v.Kind == opset.ValueObject {
+		switch v.Object.Type {
+		case opset.ObjMap:
+			return emit(op, path, map[string]any{})
+		case opset.ObjList:
+			return emit(op, path, []any{})
+		default:
+			return emit(op, path, "")
+		}
+	}
+	s, err := scalarToJSON(v.Scalar)
+	if err != nil {
+		return err
+	}
+	return emit(op, path, s)
+}
+
+func jsonPointer(path []PathElement) string {
+	var b strings.Builder
+	for _, el := range path {
+		b.WriteByte('/')
+		b.WriteString(escapeJSONPointer(el.Prop.String()))
+	}
+	return b.String()
+}
+
+func escapeJSONPointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
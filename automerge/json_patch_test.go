@@ -0,0 +1,147 @@
+package automerge
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestToJSONPatchOps(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	_ = tx1.Put(RootObjID(), "a/b", StringValue("x"))
+	_ = tx1.Put(RootObjID(), "n", CounterValue(1))
+	listID, _ := tx1.PutObject(RootObjID(), "list", ObjList)
+	_ = tx1.Insert(listID, 0, IntValue(1))
+	_ = tx1.Insert(listID, 1, IntValue(2))
+	textID, _ := tx1.PutObject(RootObjID(), "text", ObjText)
+	_ = tx1.SpliceText(textID, 0, 0, "hello")
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+
+	tx2, _ := d.Begin()
+	_ = tx2.DeleteMap(RootObjID(), "a/b")
+	_ = tx2.Increment(RootObjID(), "n", 2)
+	_ = tx2.DeleteList(listID, 0)
+	_ = tx2.Insert(listID, 1, BoolValue(true))
+	_ = tx2.SpliceText(textID, 1, 1, "a")
+	_, _ = tx2.Commit()
+	h2 := d.Heads()
+
+	ops, err := d.ToJSONPatch(d.Diff(h1, h2), h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []JSONPatchOp{
+		{Op: JSONPatchRemove, Path: "/a~1b"},
+		{Op: JSONPatchRemove, Path: "/list/0"},
+		{Op: JSONPatchAdd, Path: "/list/1", Value: json.RawMessage(`true`)},
+		{Op: JSONPatchReplace, Path: "/n", Value: json.RawMessage(`3`)},
+		{Op: JSONPatchReplace, Path: "/text", Value: json.RawMessage(`"hallo"`)},
+	}
+	if !reflect.DeepEqual(ops, want) {
+		t.Fatalf("unexpected ops:\n got %s\nwant %s", mustJSON(t, ops), mustJSON(t, want))
+	}
+
+	splices, err := d.ToJSONPatchWithOptions(d.Diff(h1, h2), h2, JSONPatchOptions{Text: JSONTextSplice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := splices[len(splices)-1]
+	if last.Op != JSONPatchSplice || last.Path != "/text" || string(last.Value) != `{"index":1,"remove":1,"insert":"a"}` {
+		t.Fatalf("unexpected splice op: %s", mustJSON(t, last))
+	}
+}
+
+func TestToJSONPatchReproducesExport(t *testing.T) {
+	d := NewDocument()
+	tx1, _ := d.Begin()
+	_ = tx1.Put(RootObjID(), "title", StringValue("t"))
+	_, _ = tx1.Commit()
+	h1 := d.Heads()
+
+	tx2, _ := d.Begin()
+	todos, _ := tx2.PutObject(RootObjID(), "todos", ObjList)
+	item, _ := tx2.InsertObject(todos, 0, ObjMap)
+	_ = tx2.Put(item, "done", BoolValue(false))
+	note, _ := tx2.PutObject(item, "note", ObjText)
+	_ = tx2.SpliceText(note, 0, 0, "milk")
+	_ = tx2.Put(RootObjID(), "title", Null())
+	_, _ = tx2.Commit()
+	h2 := d.Heads()
+
+	var doc any
+	before, _ := d.ToJSON(RootObjID(), h1)
+	_ = json.Unmarshal(before, &doc)
+	ops, err := d.ToJSONPatch(d.Diff(h1, h2), h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range ops {
+		doc = applyJSONPatchOp(t, doc, op)
+	}
+	var want any
+	after, _ := d.ToJSON(RootObjID(), h2)
+	_ = json.Unmarshal(after, &want)
+	if !reflect.DeepEqual(doc, want) {
+		t.Fatalf("patched export differs:\n got %s\nwant %s", mustJSON(t, doc), after)
+	}
+}
+
+// applyJSONPatchOp is a minimal RFC 6902 applier for add, remove and replace.
+func applyJSONPatchOp(t *testing.T, doc any, op JSONPatchOp) any {
+	t.Helper()
+	var value any
+	if op.Value != nil {
+		_ = json.Unmarshal(op.Value, &value)
+	}
+	tokens := strings.Split(op.Path, "/")[1:]
+	for i := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(tokens[i])
+	}
+	var walk func(node any, tokens []string) any
+	walk = func(node any, tokens []string) any {
+		last := len(tokens) == 1
+		switch n := node.(type) {
+		case map[string]any:
+			if !last {
+				n[tokens[0]] = walk(n[tokens[0]], tokens[1:])
+			} else if op.Op == JSONPatchRemove {
+				delete(n, tokens[0])
+			} else {
+				n[tokens[0]] = value
+			}
+			return n
+		case []any:
+			idx, err := strconv.Atoi(tokens[0])
+			if err != nil {
+				t.Fatalf("bad index in %q", op.Path)
+			}
+			switch {
+			case !last:
+				n[idx] = walk(n[idx], tokens[1:])
+			case op.Op == JSONPatchAdd:
+				n = append(n[:idx], append([]any{value}, n[idx:]...)...)
+			case op.Op == JSONPatchRemove:
+				n = append(n[:idx], n[idx+1:]...)
+			default:
+				n[idx] = value
+			}
+			return n
+		}
+		t.Fatalf("cannot apply %s to %T", op.Path, node)
+		return nil
+	}
+	return walk(doc, tokens)
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
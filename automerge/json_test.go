@@ -0,0 +1,204 @@
+package automerge
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/cjanietz/automerge-native-go/internal/model"
+	"github.com/cjanietz/automerge-native-go/internal/opset"
+)
+
+func TestToJSONNestedObjectsAndScalars(t *testing.T) {
+	doc := NewDocument()
+	tx, _ := doc.Begin()
+	root := model.RootObjID()
+	_ = tx.Put(root, "title", model.StringValue("todo"))
+	_ = tx.Put(root, "count", model.CounterValue(3))
+	_ = tx.Put(root, "at", model.TimestampValue(1700000000123))
+	_ = tx.Put(root, "raw", model.BytesValue([]byte{1, 2, 3}))
+	_ = tx.Put(root, "none", model.Null())
+	listID, _ := tx.PutObject(root, "items", ObjList)
+	_ = tx.Insert(listID, 0, model.IntValue(-1))
+	_ = tx.Insert(listID, 1, model.BoolValue(true))
+	nested, _ := tx.InsertObject(listID, 2, ObjMap)
+	_ = tx.Put(nested, "f", model.F64Value(1.5))
+	textID, _ := tx.PutObject(root, "body", ObjText)
+	_ = tx.SpliceText(textID, 0, 0, "hello")
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := doc.ToJSON(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"at":"2023-11-14T22:13:20.123Z","body":"hello","count":3,"items":[-1,true,{"f":1.5}],"none":null,"raw":"AQID","title":"todo"}`
+	if string(raw) != want {
+		t.Fatalf("unexpected json:\n got %s\nwant %s", raw, want)
+	}
+
+	sub, err := doc.ToJSON(listID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sub) != `[-1,true,{"f":1.5}]` {
+		t.Fatalf("unexpected subtree json: %s", sub)
+	}
+}
+
+func TestToJSONAtHistoricalHeads(t *testing.T) {
+	doc := NewDocument()
+	tx1, _ := doc.Begin()
+	_ = tx1.Put(model.RootObjID(), "k", model.StringValue("v1"))
+	_, _ = tx1.Commit()
+	h1 := doc.Heads()
+	tx2, _ := doc.Begin()
+	_ = tx2.Put(model.RootObjID(), "k", model.StringValue("v2"))
+	_, _ = tx2.Commit()
+
+	raw, err := doc.ToJSON(model.RootObjID(), h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != `{"k":"v1"}` {
+		t.Fatalf("unexpected historical json: %s", raw)
+	}
+}
+
+func TestToJSONIncludeConflicts(t *testing.T) {
+	a := NewDocument()
+	root := model.RootObjID()
+	// Merged changes currently supersede each other, so seed the conflict
+	// directly in the op set.
+	_ = a.ops.PutMapRaw(root, "name", opsetString("alice"), model.OpID{Counter: 1, Actor: 1}, 1, 1, nil)
+	_ = a.ops.PutMapRaw(root, "name", opsetString("ally"), model.OpID{Counter: 1, Actor: 2}, 2, 1, nil)
+
+	plain, err := a.ToJSON(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != `{"name":"ally"}` {
+		t.Fatalf("unexpected plain json: %s", plain)
+	}
+
+	raw, err := a.ToJSONWithOptions(root, nil, JSONOptions{IncludeConflicts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	conflicts, ok := got[JSONConflictsKey].(map[string]any)
+	if !ok {
+		t.Fatalf("expected conflicts member, got %s", raw)
+	}
+	alts, ok := conflicts["name"].([]any)
+	if !ok || len(alts) != 2 || alts[0] != "alice" || alts[1] != "ally" {
+		t.Fatalf("unexpected conflict alternatives: %#v", conflicts["name"])
+	}
+}
+
+func TestToJSONConflictsRejectsClashingKey(t *testing.T) {
+	doc := NewDocument()
+	var nested ObjID
+	commitTx(t, doc, func(tx *Transaction) error {
+		nested, _ = tx.PutObject(model.RootObjID(), "nested", ObjMap)
+		return tx.Put(nested, JSONConflictsKey, model.StringValue("user data"))
+	})
+	// Without conflicts the key is plain data.
+	if raw, err := doc.ToJSON(model.RootObjID(), nil); err != nil || string(raw) != `{"nested":{"_conflicts":"user data"}}` {
+		t.Fatalf("unexpected json: %s %v", raw, err)
+	}
+	if _, err := doc.ToJSONWithOptions(model.RootObjID(), nil, JSONOptions{IncludeConflicts: true}); !errors.Is(err, ErrJSONConflictsKey) {
+		t.Fatalf("expected ErrJSONConflictsKey, got %v", err)
+	}
+}
+
+func TestToJSONRejectsNonFiniteFloat(t *testing.T) {
+	doc := NewDocument()
+	tx, _ := doc.Begin()
+	_ = tx.Put(model.RootObjID(), "nan", model.F64Value(math.NaN()))
+	_, _ = tx.Commit()
+	if _, err := doc.ToJSON(model.RootObjID(), nil); !errors.Is(err, ErrJSONUnsupportedValue) {
+		t.Fatalf("expected ErrJSONUnsupportedValue, got %v", err)
+	}
+}
+
+func opsetString(s string) opset.Value {
+	return opset.NewScalarValue(model.StringValue(s))
+}
+
+func TestAutoCommitFromJSONRoundTrip(t *testing.T) {
+	ac := NewAutoCommit()
+	input := `{"title":"todo","done":false,"n":-2,"big":18446744073709551615,"ratio":0.25,"tags":["a",{"x":null}]}`
+	change, err := ac.FromJSON(json.RawMessage(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change == nil || len(ac.Document().Heads()) != 1 {
+		t.Fatalf("expected a single change, got %#v", change)
+	}
+	big, ok := ac.Document().GetMap(model.RootObjID(), "big")
+	if !ok || big.Scalar.Kind != model.ScalarUint {
+		t.Fatalf("expected uint for out-of-range integer, got %#v", big)
+	}
+	raw, err := ac.Document().ToJSON(model.RootObjID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"big":18446744073709551615,"done":false,"n":-2,"ratio":0.25,"tags":["a",{"x":null}],"title":"todo"}`
+	if string(raw) != want {
+		t.Fatalf("unexpected round trip:\n got %s\nwant %s", raw, want)
+	}
+}
+
+func TestTransactionPutJSONOptions(t *testing.T) {
+	doc := NewDocument()
+	tx, _ := doc.Begin()
+	opts := JSONImportOptions{Strings: JSONStringText, Numbers: JSONNumberF64}
+	if err := tx.PutJSONWithOptions(model.RootObjID(), "note", json.RawMessage(`{"body":"hi","n":3}`), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	note, ok := doc.GetMap(model.RootObjID(), "note")
+	if !ok || note.Kind != opset.ValueObject {
+		t.Fatalf("expected nested map, got %#v", note)
+	}
+	body, _ := doc.GetMap(note.Object.ID, "body")
+	if body.Kind != opset.ValueObject || body.Object.Type != ObjText || doc.Text(body.Object.ID) != "hi" {
+		t.Fatalf("expected text object for string, got %#v", body)
+	}
+	n, _ := doc.GetMap(note.Object.ID, "n")
+	if n.Scalar.Kind != model.ScalarF64 || n.Scalar.F64 != 3 {
+		t.Fatalf("expected f64 number, got %#v", n)
+	}
+
+	tx2, _ := doc.Begin()
+	err := tx2.PutJSONWithOptions(model.RootObjID(), "bad", json.RawMessage(`1.5`), JSONImportOptions{Numbers: JSONNumberInt})
+	if !errors.Is(err, ErrJSONInvalidNumber) {
+		t.Fatalf("expected ErrJSONInvalidNumber, got %v", err)
+	}
+	_ = tx2.Rollback()
+
+	if _, err := NewAutoCommit().FromJSON(json.RawMessage(`[1]`)); !errors.Is(err, ErrJSONNotObject) {
+		t.Fatalf("expected ErrJSONNotObject, got %v", err)
+	}
+	for _, input := range []string{`{"a":1} garbage`, `{"a":1}{"b":2}`, `{"a":1}]`} {
+		if _, err := NewAutoCommit().FromJSON(json.RawMessage(input)); !errors.Is(err, ErrJSONTrailingData) {
+			t.Fatalf("expected ErrJSONTrailingData for %s, got %v", input, err)
+		}
+	}
+	tx3, _ := doc.Begin()
+	if err := tx3.PutJSON(model.RootObjID(), "x", json.RawMessage("1 2")); !errors.Is(err, ErrJSONTrailingData) {
+		t.Fatalf("expected ErrJSONTrailingData, got %v", err)
+	}
+	_ = tx3.Rollback()
+	if _, err := NewAutoCommit().FromJSON(json.RawMessage("{\"a\":1}\n\t ")); err != nil {
+		t.Fatalf("trailing whitespace should be accepted: %v", err)
+	}
+}
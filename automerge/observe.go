@@ -0,0 +1,149 @@
+package automerge
+
+import "slices"
+
+type EventKind uint8
+
+const (
+	// EventLocalChange follows a transaction commit on the document.
+	EventLocalChange EventKind = iota
+	// EventRemoteChanges follows changes applied from elsewhere, whether by
+	// ApplyChanges, Merge, LoadIncremental or ReceiveSyncMessage.
+	EventRemoteChanges
+	// EventHeadsChanged follows either of the above when the heads moved.
+	EventHeadsChanged
+)
+
+// Event describes one update of a Document. Changes lists the hashes that
+// were committed or applied, and Heads the heads afterwards. Patches holds the
+// patches of the applied ops that pass the subscription filter; it is empty
+// for EventHeadsChanged.
+type Event struct {
+	Kind    EventKind
+	Changes []ChangeHash
+	Heads   []ChangeHash
+	Patches []Patch
+}
+
+// SubscribeOptions limits a subscription to part of the document. A patch
+// passes when it targets, or is nested below, one of Objects, or when its path
+// starts with PathPrefix. With no filter set every event is delivered;
+// otherwise updates without a passing patch are skipped entirely.
+type SubscribeOptions struct {
+	Objects    []ObjID
+	PathPrefix []Prop
+}
+
+type subscription struct {
+	fn   func(Event)
+	opts SubscribeOptions
+	// changesOnly subscriptions are internal and take no patches, so they do
+	// not make updates record them.
+	changesOnly bool
+}
+
+func (o SubscribeOptions) filtered() bool {
+	return len(o.Objects) > 0 || len(o.PathPrefix) > 0
+}
+
+func (o SubscribeOptions) matches(p Patch) bool {
+	for _, obj := range o.Objects {
+		if p.ObjID == obj {
+			return true
+		}
+		for _, el := range p.Path {
+			if el.ObjID == obj {
+				return true
+			}
+		}
+	}
+	if len(o.PathPrefix) == 0 {
+		return false
+	}
+	props := patchProps(p)
+	if len(props) < len(o.PathPrefix) {
+		return false
+	}
+	return slices.Equal(props[:len(o.PathPrefix)], o.PathPrefix)
+}
+
+// patchProps is the full property path a patch touches: its object's path plus
+// the key or index it writes, where that is a single value.
+func patchProps(p Patch) []Prop {
+	props := make([]Prop, 0, len(p.Path)+1)
+	for _, el := range p.Path {
+		props = append(props, el.Prop)
+	}
+	switch p.Kind {
+	case PatchMapPut, PatchMapDelete, PatchIncrement:
+		props = append(props, MapProp(p.Key))
+	case PatchListPut:
+		props = append(props, SeqProp(p.Index))
+	}
+	return props
+}
+
+// Subscribe registers fn for every document event. See SubscribeWithOptions.
+func (d *Document) Subscribe(fn func(Event)) (unsubscribe func()) {
+	return d.SubscribeWithOptions(fn, SubscribeOptions{})
+}
+
+// SubscribeWithOptions registers fn for document events passing opts. The
+// returned func removes the subscription; calling it more than once is safe.
+// Callbacks run synchronously after the update, so they may read the
+// document or open a new transaction.
+func (d *Document) SubscribeWithOptions(fn func(Event), opts SubscribeOptions) (unsubscribe func()) {
+	return d.subscribe(&subscription{fn: fn, opts: opts})
+}
+
+func (d *Document) subscribe(sub *subscription) (unsubscribe func()) {
+	d.subs = append(d.subs, sub)
+	return func() {
+		d.subs = slices.DeleteFunc(d.subs, func(s *subscription) bool { return s == sub })
+	}
+}
+
+// observeLog returns the log ops should be recorded into while an update runs,
+// and the position its new patches start at. Without subscribers it is log
+// itself; with them it is log if active, or a private active log otherwise.
+func (d *Document) observeLog(log *PatchLog) (*PatchLog, int) {
+	if !slices.ContainsFunc(d.subs, func(s *subscription) bool { return !s.changesOnly }) {
+		return log, 0
+	}
+	if log.IsActive() {
+		return log, len(log.patches)
+	}
+	return ActivePatchLog(), 0
+}
+
+// notify delivers an update to subscribers. patches are all the patches the
+// update produced; each subscriber receives only the ones passing its filter.
+func (d *Document) notify(kind EventKind, changes, beforeHeads []ChangeHash, patches []Patch) {
+	if len(d.subs) == 0 || len(changes) == 0 {
+		return
+	}
+	heads := d.Heads()
+	headsChanged := !slices.Equal(beforeHeads, heads)
+	for _, sub := range slices.Clone(d.subs) {
+		if !slices.Contains(d.subs, sub) {
+			// Unsubscribed by an earlier callback of this update.
+			continue
+		}
+		selected := patches
+		if sub.opts.filtered() {
+			selected = nil
+			for _, p := range patches {
+				if sub.opts.matches(p) {
+					selected = append(selected, p)
+				}
+			}
+			if len(selected) == 0 {
+				continue
+			}
+		}
+		sub.fn(Event{Kind: kind, Changes: slices.Clone(changes), Heads: slices.Clone(heads), Patches: slices.Clone(selected)})
+		if headsChanged {
+			sub.fn(Event{Kind: EventHeadsChanged, Changes: slices.Clone(changes), Heads: slices.Clone(heads)})
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package automerge
+
+import "testing"
+
+func TestSubscribeLocalAndRemoteEvents(t *testing.T) {
+	d := NewDocument()
+	var events []Event
+	unsubscribe := d.Subscribe(func(e Event) { events = append(events, e) })
+
+	tx, _ := d.Begin()
+	_ = tx.Put(RootObjID(), "k", StringValue("v"))
+	change, err := tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Kind != EventLocalChange || events[1].Kind != EventHeadsChanged {
+		t.Fatalf("unexpected local events: %#v", events)
+	}
+	if events[0].Changes[0] != change.Hash || len(events[0].Patches) != 1 || events[0].Patches[0].Key != "k" {
+		t.Fatalf("unexpected local event: %#v", events[0])
+	}
+
+	other := NewDocument()
+	_ = other.SetActor(2)
+	otx, _ := other.Begin()
+	_ = otx.Put(RootObjID(), "remote", IntValue(1))
+	_, _ = otx.Commit()
+
+	events = nil
+	if err := d.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Kind != EventRemoteChanges || events[0].Patches[0].Key != "remote" {
+		t.Fatalf("unexpected merge events: %#v", events)
+	}
+	if len(events[1].Heads) != 2 {
+		t.Fatalf("expected two heads after merge: %#v", events[1])
+	}
+
+	// Merging again applies nothing and stays silent.
+	events = nil
+	_ = d.Merge(other)
+	if len(events) != 0 {
+		t.Fatalf("unexpected events for a no-op merge: %#v", events)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	tx2, _ := d.Begin()
+	_ = tx2.Put(RootObjID(), "k", StringValue("w"))
+	_, _ = tx2.Commit()
+	if len(events) != 0 {
+		t.Fatalf("unexpected events after unsubscribe: %#v", events)
+	}
+}
+
+func TestSubscribeFiresForSyncAndLoadIncremental(t *testing.T) {
+	src := NewDocument()
+	tx, _ := src.Begin()
+	_ = tx.Put(RootObjID(), "k", StringValue("v"))
+	_, _ = tx.Commit()
+
+	peer := NewDocument()
+	_ = peer.SetActor(2)
+	var remote int
+	peer.Subscribe(func(e Event) {
+		if e.Kind == EventRemoteChanges {
+			remote++
+		}
+	})
+	ours, theirs := NewSyncState(), NewSyncState()
+	for i := 0; i < 10; i++ {
+		msg, err := src.Sync().GenerateSyncMessage(ours)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil {
+			break
+		}
+		if err := peer.Sync().ReceiveSyncMessage(theirs, *msg); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := peer.Sync().GenerateSyncMessage(theirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply != nil {
+			if err := src.Sync().ReceiveSyncMessage(ours, *reply); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if remote != 1 {
+		t.Fatalf("expected one remote event from sync, got %d", remote)
+	}
+
+	loaded := NewDocument()
+	var got []Event
+	loaded.Subscribe(func(e Event) { got = append(got, e) })
+	full, err := src.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.LoadIncremental(full); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Kind != EventRemoteChanges || got[1].Kind != EventHeadsChanged {
+		t.Fatalf("unexpected LoadIncremental events: %#v", got)
+	}
+}
+
+func TestSubscribeFilters(t *testing.T) {
+	d := NewDocument()
+	tx, _ := d.Begin()
+	todos, _ := tx.PutObject(RootObjID(), "todos", ObjList)
+	item, _ := tx.InsertObject(todos, 0, ObjMap)
+	_ = tx.Put(RootObjID(), "title", StringValue("t"))
+	_, _ = tx.Commit()
+
+	var byObject, byPath []Event
+	d.SubscribeWithOptions(func(e Event) { byObject = append(byObject, e) }, SubscribeOptions{Objects: []ObjID{todos}})
+	d.SubscribeWithOptions(func(e Event) { byPath = append(byPath, e) }, SubscribeOptions{PathPrefix: []Prop{MapProp("todos"), SeqProp(0)}})
+
+	tx2, _ := d.Begin()
+	_ = tx2.Put(RootObjID(), "title", StringValue("u"))
+	_, _ = tx2.Commit()
+	if len(byObject) != 0 || len(byPath) != 0 {
+		t.Fatalf("expected unrelated changes to be filtered: %#v %#v", byObject, byPath)
+	}
+
+	tx3, _ := d.Begin()
+	_ = tx3.Put(item, "done", BoolValue(true))
+	_ = tx3.Put(RootObjID(), "title", StringValue("v"))
+	_, _ = tx3.Commit()
+	if len(byObject) != 2 || len(byObject[0].Patches) != 1 || byObject[0].Patches[0].ObjID != item {
+		t.Fatalf("unexpected object-filtered events: %#v", byObject)
+	}
+	if len(byPath) != 2 || len(byPath[0].Patches) != 1 || byPath[0].Patches[0].Key != "done" {
+		t.Fatalf("unexpected path-filtered events: %#v", byPath)
+	}
+}
@@ -2,11 +2,15 @@ package automerge
 
 import (
 	"errors"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/cjanietz/automerge-native-go/internal/changegraph"
 	"github.com/cjanietz/automerge-native-go/internal/model"
 	"github.com/cjanietz/automerge-native-go/internal/opset"
+	inttext "github.com/cjanietz/automerge-native-go/internal/text"
 )
 
 var ErrDiffUnknownObject = errors.New("diff unknown object")
@@ -17,28 +21,108 @@ const (
 	PatchMapPut PatchKind = iota
 	PatchMapDelete
 	PatchTextSplice
-	PatchListReplace
+	PatchListInsert
+	PatchListDelete
+	PatchListPut
+	PatchIncrement
+	PatchMark
+	PatchUnmark
 )
 
 type Patch struct {
-	Kind       PatchKind
-	ObjID      model.ObjID
-	Key        string
-	OldValue   *opset.Value
-	NewValue   *opset.Value
-	BeforeText string
-	AfterText  string
-	BeforeList []opset.Value
-	AfterList  []opset.Value
+	Kind  PatchKind
+	ObjID ObjID
+	// Path leads from the root to ObjID at the after heads. It is empty for
+	// patches on the root map.
+	Path     []PathElement
+	Key      string
+	OldValue *Value
+	NewValue *Value
+
+	// Index, DeleteCount and InsertText describe a PatchTextSplice: delete
+	// DeleteCount units at Index, then insert InsertText there. Units follow
+	// DiffOptions.TextEncoding, and each splice applies to the text left by
+	// the previous one.
+	Index       int
+	DeleteCount int
+	InsertText  string
+
+	// Values is the run of elements inserted at Index by PatchListInsert.
+	Values []Value
+	// Count is the number of elements removed at Index by PatchListDelete.
+	Count int
+	// Conflict is set on PatchListPut when the element at Index holds
+	// concurrent values; NewValue is the winner.
+	Conflict bool
+
+	// Delta is the amount a PatchIncrement added to the counter at Key.
+	Delta int64
+	// Marks lists the ranges a PatchMark formats, or a PatchUnmark clears, in
+	// the text after the splices of the same diff. Ranges use
+	// DiffOptions.TextEncoding units.
+	Marks []MarkRange
+}
+
+// MarkRange is a named mark over the units [Start, End) of a text object.
+// Value is the null scalar in PatchUnmark ranges.
+type MarkRange struct {
+	Name  string
+	Value ScalarValue
+	Start int
+	End   int
+}
+
+// Prop addresses a value inside an object: a map key, or an index when Seq
+// is set.
+type Prop struct {
+	Key   string
+	Index int
+	Seq   bool
+}
+
+func MapProp(key string) Prop { return Prop{Key: key} }
+func SeqProp(index int) Prop  { return Prop{Index: index, Seq: true} }
+
+func (p Prop) String() string {
+	if p.Seq {
+		return strconv.Itoa(p.Index)
+	}
+	return p.Key
+}
+
+// PathElement is one step of a Patch path: the child at Prop of ObjID.
+type PathElement struct {
+	ObjID ObjID
+	Prop  Prop
+}
+
+type DiffOptions struct {
+	// TextEncoding selects the units of text splice indexes and lengths.
+	TextEncoding Encoding
+}
+
+func DefaultDiffOptions() DiffOptions {
+	return DiffOptions{TextEncoding: EncodingUTF8}
 }
 
 type PatchLog struct {
 	active  bool
 	patches []Patch
-	heads   []model.ChangeHash
+	heads   []ChangeHash
+	// opts is nil to follow the text encoding of the document written to.
+	opts *DiffOptions
+}
+
+// ActivePatchLog records patches with text indexes in the encoding of the
+// document it is used with.
+func ActivePatchLog() *PatchLog { return &PatchLog{active: true} }
+
+// ActivePatchLogWithOptions records patches as DiffWithOptions would with
+// opts.
+func ActivePatchLogWithOptions(opts DiffOptions) *PatchLog {
+	return &PatchLog{active: true, opts: &opts}
 }
 
-func ActivePatchLog() *PatchLog    { return &PatchLog{active: true} }
 func InactivePatchLog() *PatchLog  { return &PatchLog{active: false} }
 func (p *PatchLog) IsActive() bool { return p != nil && p.active }
 func (p *PatchLog) Reset() {
@@ -52,10 +136,16 @@ func (p *PatchLog) Add(patch Patch) {
 		p.patches = append(p.patches, patch)
 	}
 }
-func (p *PatchLog) setHeads(heads []model.ChangeHash) {
+func (p *PatchLog) setHeads(heads []ChangeHash) {
 	if p != nil {
-		p.heads = append([]model.ChangeHash(nil), heads...)
+		p.heads = append([]ChangeHash(nil), heads...)
+	}
+}
+func (p *PatchLog) since(start int) []Patch {
+	if p == nil || start >= len(p.patches) {
+		return nil
 	}
+	return p.patches[start:]
 }
 func (p *PatchLog) MakePatches() []Patch {
 	if p == nil {
@@ -65,32 +155,82 @@ func (p *PatchLog) MakePatches() []Patch {
 	copy(out, p.patches)
 	return out
 }
-func (p *PatchLog) Heads() []model.ChangeHash {
+func (p *PatchLog) Heads() []ChangeHash {
 	if p == nil {
 		return nil
 	}
-	out := make([]model.ChangeHash, len(p.heads))
+	out := make([]ChangeHash, len(p.heads))
 	copy(out, p.heads)
 	return out
 }
 
-func (d *Document) Diff(beforeHeads, afterHeads []model.ChangeHash) []Patch {
-	patches, _ := d.DiffObj(model.RootObjID(), beforeHeads, afterHeads, true)
+// patchOptions returns the options patches are recorded into log with.
+func (d *Document) patchOptions(log *PatchLog) DiffOptions {
+	if log != nil && log.opts != nil {
+		return *log.opts
+	}
+	return DiffOptions{TextEncoding: d.textOpts.Encoding}
+}
+
+// recordOp runs apply, which applies one operation to obj, and adds the
+// patches it caused to log. Only obj is snapshotted and compared, and its path
+// is found from the parent links, so the cost follows the size of the touched
+// object rather than the document.
+func (d *Document) recordOp(log *PatchLog, obj ObjID, apply func() error) error {
+	if !log.IsActive() {
+		return apply()
+	}
+	before := d.ops.SnapshotObject(obj)
+	if err := apply(); err != nil {
+		return err
+	}
+	return logObjectPatches(log, d.ops, before, d.ops.CurrentView(), obj, d.patchOptions(log))
+}
+
+// logObjectPatches adds the patches turning obj in before into obj in after,
+// without descending into child objects.
+func logObjectPatches(log *PatchLog, ops *opset.OpSet, before, after *opset.View, obj ObjID, opts DiffOptions) error {
+	c := &diffContext{
+		before: before,
+		after:  after,
+		opts:   opts,
+		seen:   map[ObjID]struct{}{},
+	}
+	patches, err := c.diffObj(obj, objectPath(ops, after, obj))
+	if err != nil {
+		return err
+	}
+	for _, p := range patches {
+		log.Add(p)
+	}
+	return nil
+}
+
+func (d *Document) Diff(beforeHeads, afterHeads []ChangeHash) []Patch {
+	return d.DiffWithOptions(beforeHeads, afterHeads, DefaultDiffOptions())
+}
+
+func (d *Document) DiffWithOptions(beforeHeads, afterHeads []ChangeHash, opts DiffOptions) []Patch {
+	patches, _ := d.DiffObjWithOptions(RootObjID(), beforeHeads, afterHeads, true, opts)
 	return patches
 }
 
-func (d *Document) DiffToPatchLog(beforeHeads, afterHeads []model.ChangeHash, log *PatchLog) {
+func (d *Document) DiffToPatchLog(beforeHeads, afterHeads []ChangeHash, log *PatchLog) {
 	if log == nil || !log.IsActive() {
 		return
 	}
-	patches := d.Diff(beforeHeads, afterHeads)
+	patches := d.DiffWithOptions(beforeHeads, afterHeads, d.patchOptions(log))
 	for _, p := range patches {
 		log.Add(p)
 	}
 	log.setHeads(afterHeads)
 }
 
-func (d *Document) DiffObj(obj model.ObjID, beforeHeads, afterHeads []model.ChangeHash, recursive bool) ([]Patch, error) {
+func (d *Document) DiffObj(obj ObjID, beforeHeads, afterHeads []ChangeHash, recursive bool) ([]Patch, error) {
+	return d.DiffObjWithOptions(obj, beforeHeads, afterHeads, recursive, DefaultDiffOptions())
+}
+
+func (d *Document) DiffObjWithOptions(obj ObjID, beforeHeads, afterHeads []ChangeHash, recursive bool, opts DiffOptions) ([]Patch, error) {
 	beforeClock, err := d.clockFromHeads(beforeHeads)
 	if err != nil {
 		return nil, err
@@ -99,55 +239,59 @@ func (d *Document) DiffObj(obj model.ObjID, beforeHeads, afterHeads []model.Chan
 	if err != nil {
 		return nil, err
 	}
-	seen := map[model.ObjID]struct{}{}
-	return d.diffObjAt(obj, beforeClock, afterClock, recursive, seen)
+	c := &diffContext{
+		before:    d.ops.ViewAt(beforeClock),
+		after:     d.ops.ViewAt(afterClock),
+		opts:      opts,
+		recursive: recursive,
+		seen:      map[ObjID]struct{}{},
+	}
+	return c.diffObj(obj, objectPath(d.ops, c.after, obj))
 }
 
-func (d *Document) clockFromHeads(heads []model.ChangeHash) (*changegraph.Clock, error) {
+func (d *Document) clockFromHeads(heads []ChangeHash) (*changegraph.Clock, error) {
 	if len(heads) == 0 {
 		return &changegraph.Clock{}, nil
 	}
-	clk, err := d.ClockForHeads(heads)
+	clk, err := d.clockForHeads(heads)
 	if err != nil {
 		return nil, err
 	}
 	return &clk, nil
 }
 
-func (d *Document) diffObjAt(obj model.ObjID, before, after *changegraph.Clock, recursive bool, seen map[model.ObjID]struct{}) ([]Patch, error) {
-	if _, ok := seen[obj]; ok {
+type diffContext struct {
+	before    *opset.View
+	after     *opset.View
+	opts      DiffOptions
+	recursive bool
+	seen      map[ObjID]struct{}
+}
+
+func (c *diffContext) diffObj(obj ObjID, path []PathElement) ([]Patch, error) {
+	if _, ok := c.seen[obj]; ok {
 		return nil, nil
 	}
-	seen[obj] = struct{}{}
-	typ, ok := d.ops.ObjectType(obj)
+	c.seen[obj] = struct{}{}
+	typ, ok := c.after.ObjectType(obj)
 	if !ok {
 		return nil, ErrDiffUnknownObject
 	}
 	switch typ {
 	case opset.ObjMap:
-		return d.diffMap(obj, before, after, recursive, seen)
+		return c.diffMap(obj, path)
 	case opset.ObjText:
-		beforeText := d.ops.Text(obj, before)
-		afterText := d.ops.Text(obj, after)
-		if beforeText == afterText {
-			return nil, nil
-		}
-		return []Patch{{Kind: PatchTextSplice, ObjID: obj, BeforeText: beforeText, AfterText: afterText}}, nil
+		return c.diffText(obj, path), nil
 	case opset.ObjList:
-		beforeList := d.ops.ListRange(obj, 0, -1, before)
-		afterList := d.ops.ListRange(obj, 0, -1, after)
-		if valuesEqual(beforeList, afterList) {
-			return nil, nil
-		}
-		return []Patch{{Kind: PatchListReplace, ObjID: obj, BeforeList: beforeList, AfterList: afterList}}, nil
+		return c.diffList(obj, path)
 	default:
 		return nil, ErrDiffUnknownObject
 	}
 }
 
-func (d *Document) diffMap(obj model.ObjID, before, after *changegraph.Clock, recursive bool, seen map[model.ObjID]struct{}) ([]Patch, error) {
-	beforeMap := d.mapSnapshot(obj, before)
-	afterMap := d.mapSnapshot(obj, after)
+func (c *diffContext) diffMap(obj ObjID, path []PathElement) ([]Patch, error) {
+	beforeMap := mapSnapshot(c.before, obj)
+	afterMap := mapSnapshot(c.after, obj)
 	keysSet := map[string]struct{}{}
 	for k := range beforeMap {
 		keysSet[k] = struct{}{}
@@ -168,45 +312,375 @@ func (d *Document) diffMap(obj model.ObjID, before, after *changegraph.Clock, re
 		switch {
 		case bok && !aok:
 			bcp := bv
-			patches = append(patches, Patch{Kind: PatchMapDelete, ObjID: obj, Key: k, OldValue: &bcp})
+			patches = append(patches, Patch{Kind: PatchMapDelete, ObjID: obj, Path: path, Key: k, OldValue: &bcp})
 		case !bok && aok:
 			acp := av
-			patches = append(patches, Patch{Kind: PatchMapPut, ObjID: obj, Key: k, NewValue: &acp})
+			patches = append(patches, Patch{Kind: PatchMapPut, ObjID: obj, Path: path, Key: k, NewValue: &acp})
 		case bok && aok && !bv.Equal(av):
+			if delta, ok := c.counterDelta(obj, k); ok {
+				patches = append(patches, Patch{Kind: PatchIncrement, ObjID: obj, Path: path, Key: k, Delta: delta})
+				break
+			}
 			bcp := bv
 			acp := av
-			patches = append(patches, Patch{Kind: PatchMapPut, ObjID: obj, Key: k, OldValue: &bcp, NewValue: &acp})
+			patches = append(patches, Patch{Kind: PatchMapPut, ObjID: obj, Path: path, Key: k, OldValue: &bcp, NewValue: &acp})
+		}
+		if aok {
+			sub, err := c.diffChild(path, obj, MapProp(k), av)
+			if err != nil {
+				return nil, err
+			}
+			patches = append(patches, sub...)
 		}
-		if recursive && bok && aok && bv.Kind == opset.ValueObject && av.Kind == opset.ValueObject {
-			if bv.Object.ID == av.Object.ID {
-				sub, err := d.diffObjAt(bv.Object.ID, before, after, true, seen)
-				if err != nil {
+	}
+	return patches, nil
+}
+
+// counterDelta reports the difference between the before and after values of
+// a counter at key, as long as both sides hold the same counter. A counter
+// that was put again, even with a counter value, is a replacement.
+func (c *diffContext) counterDelta(obj ObjID, key string) (int64, bool) {
+	bv, bok := c.before.GetMapVersion(obj, key)
+	av, aok := c.after.GetMapVersion(obj, key)
+	if !bok || !aok || bv.Counter == (OpID{}) || bv.Counter != av.Counter {
+		return 0, false
+	}
+	return av.Value.Scalar.Counter - bv.Value.Scalar.Counter, true
+}
+
+// diffList reports element changes as insert, delete and put patches. Like
+// text, elements are matched by insert OpID, so an overwritten element yields
+// a put at its index instead of a delete and insert.
+func (c *diffContext) diffList(obj ObjID, path []PathElement) ([]Patch, error) {
+	before := c.before.ListElements(obj)
+	after := c.after.ListElements(obj)
+
+	var patches []Patch
+	var children []int
+	pos := 0
+	var run *Patch
+	flush := func() error {
+		if run == nil {
+			return nil
+		}
+		patches = append(patches, *run)
+		for i, ai := range children {
+			sub, err := c.diffChild(path, obj, SeqProp(run.Index+i), after[ai].Value)
+			if err != nil {
+				return err
+			}
+			patches = append(patches, sub...)
+		}
+		run = nil
+		children = children[:0]
+		return nil
+	}
+	for _, step := range diffSequence(before, after) {
+		switch step.kind {
+		case seqDelete:
+			if run != nil && run.Kind != PatchListDelete {
+				if err := flush(); err != nil {
 					return nil, err
 				}
-				patches = append(patches, sub...)
 			}
+			if run == nil {
+				run = &Patch{Kind: PatchListDelete, ObjID: obj, Path: path, Index: pos}
+			}
+			run.Count++
+		case seqInsert:
+			if run != nil && run.Kind != PatchListInsert {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+			if run == nil {
+				run = &Patch{Kind: PatchListInsert, ObjID: obj, Path: path, Index: pos}
+			}
+			run.Values = append(run.Values, after[step.after].Value)
+			children = append(children, step.after)
+			pos++
+		case seqKeep:
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			be, ae := before[step.before], after[step.after]
+			if be.Winner != ae.Winner || be.Conflict != ae.Conflict {
+				bcp, acp := be.Value, ae.Value
+				patches = append(patches, Patch{Kind: PatchListPut, ObjID: obj, Path: path, Index: pos, OldValue: &bcp, NewValue: &acp, Conflict: ae.Conflict})
+			}
+			sub, err := c.diffChild(path, obj, SeqProp(pos), ae.Value)
+			if err != nil {
+				return nil, err
+			}
+			patches = append(patches, sub...)
+			pos++
 		}
 	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
 	return patches, nil
 }
 
-func (d *Document) mapSnapshot(obj model.ObjID, at *changegraph.Clock) map[string]opset.Value {
-	items := d.ops.IterMap(obj, at)
-	out := make(map[string]opset.Value, len(items))
-	for _, item := range items {
-		out[item.Key] = item.Value
+// diffChild descends into the object a value at the after heads refers to.
+// Objects created between the heads are diffed against their empty initial
+// state, so their whole content is reported.
+func (c *diffContext) diffChild(path []PathElement, parent ObjID, prop Prop, v Value) ([]Patch, error) {
+	if !c.recursive || v.Kind != opset.ValueObject {
+		return nil, nil
+	}
+	child := append(path[:len(path):len(path)], PathElement{ObjID: parent, Prop: prop})
+	return c.diffObj(v.Object.ID, child)
+}
+
+// objectPath follows the parent links of obj up to the root of view.
+// Unreachable objects, such as ones deleted at the viewed heads, have a nil
+// path.
+func objectPath(ops *opset.OpSet, view *opset.View, obj ObjID) []PathElement {
+	var path []PathElement
+	for obj != RootObjID() {
+		p, ok := ops.Parent(obj)
+		if !ok {
+			return nil
+		}
+		var prop Prop
+		var v Value
+		if p.Elem == (OpID{}) {
+			if v, ok = view.GetMap(p.Obj, p.Key); !ok {
+				return nil
+			}
+			prop = MapProp(p.Key)
+		} else {
+			var index int
+			if index, v, ok = view.ListIndex(p.Obj, p.Elem); !ok {
+				return nil
+			}
+			prop = SeqProp(index)
+		}
+		if v.Kind != opset.ValueObject || v.Object.ID != obj {
+			return nil
+		}
+		path = append(path, PathElement{ObjID: p.Obj, Prop: prop})
+		obj = p.Obj
+	}
+	slices.Reverse(path)
+	return path
+}
+
+// diffText turns the element changes between the two views into splices.
+// Elements are matched by the OpID of their insert, so the result follows the
+// operations that were applied rather than a comparison of the strings.
+func (c *diffContext) diffText(obj ObjID, path []PathElement) []Patch {
+	before := c.before.ListElements(obj)
+	after := c.after.ListElements(obj)
+	enc := c.opts.TextEncoding
+
+	steps := diffSequence(before, after)
+
+	var patches []Patch
+	pos := 0
+	pending := Patch{Kind: PatchTextSplice, ObjID: obj, Path: path}
+	var insert strings.Builder
+	flush := func() {
+		if pending.DeleteCount == 0 && insert.Len() == 0 {
+			return
+		}
+		pending.InsertText = insert.String()
+		patches = append(patches, pending)
+		pos += inttext.Length(pending.InsertText, enc)
+		pending = Patch{Kind: PatchTextSplice, ObjID: obj, Path: path}
+		insert.Reset()
+	}
+	for _, step := range steps {
+		switch step.kind {
+		case seqKeep:
+			flush()
+			pos += inttext.Length(elementText(after[step.after]), enc)
+		case seqDelete:
+			pending.Index = pos
+			pending.DeleteCount += inttext.Length(elementText(before[step.before]), enc)
+		case seqInsert:
+			pending.Index = pos
+			insert.WriteString(elementText(after[step.after]))
+		}
+	}
+	flush()
+	return append(patches, c.diffMarks(obj, path, after, steps)...)
+}
+
+// diffMarks compares the effective mark of every name on each character of
+// the after text with the one on the same element before, and reports the
+// ranges whose mark was set or changed as a PatchMark and the ranges that lost
+// their mark as a PatchUnmark. Inserted characters have no previous mark, and
+// a null-valued mark counts as no mark.
+func (c *diffContext) diffMarks(obj ObjID, path []PathElement, after []opset.ListElement, steps []seqStep) []Patch {
+	beforeMarks := c.before.Marks(obj)
+	afterMarks := c.after.Marks(obj)
+	if len(beforeMarks) == 0 && len(afterMarks) == 0 {
+		return nil
+	}
+	beforeLen := c.before.ListLength(obj)
+	origin := make([]int, len(after))
+	for _, step := range steps {
+		if step.kind == seqKeep {
+			origin[step.after] = step.before + 1
+		}
+	}
+	offsets := make([]int, len(after)+1)
+	for i, e := range after {
+		offsets[i+1] = offsets[i] + inttext.Length(elementText(e), c.opts.TextEncoding)
+	}
+	names := map[string]struct{}{}
+	for _, m := range beforeMarks {
+		names[m.Name] = struct{}{}
+	}
+	for _, m := range afterMarks {
+		names[m.Name] = struct{}{}
+	}
+	sortedNames := make([]string, 0, len(names))
+	for n := range names {
+		sortedNames = append(sortedNames, n)
+	}
+	sort.Strings(sortedNames)
+
+	var set, cleared []MarkRange
+	for _, name := range sortedNames {
+		was := effectiveMarks(beforeMarks, name, beforeLen)
+		now := effectiveMarks(afterMarks, name, len(after))
+		var run *MarkRange
+		var runSet bool
+		for i := range after {
+			var prev *Mark
+			if origin[i] > 0 {
+				prev = was[origin[i]-1]
+			}
+			cur := now[i]
+			isSet := cur != nil && (prev == nil || !cur.Value.Equal(prev.Value))
+			isCleared := cur == nil && prev != nil
+			if run != nil && (!(isSet || isCleared) || isSet != runSet || (isSet && !run.Value.Equal(cur.Value))) {
+				if runSet {
+					set = append(set, *run)
+				} else {
+					cleared = append(cleared, *run)
+				}
+				run = nil
+			}
+			switch {
+			case run != nil:
+				run.End = offsets[i+1]
+			case isSet:
+				run = &MarkRange{Name: name, Value: cur.Value, Start: offsets[i], End: offsets[i+1]}
+				runSet = true
+			case isCleared:
+				run = &MarkRange{Name: name, Value: model.Null(), Start: offsets[i], End: offsets[i+1]}
+				runSet = false
+			}
+		}
+		if run != nil {
+			if runSet {
+				set = append(set, *run)
+			} else {
+				cleared = append(cleared, *run)
+			}
+		}
+	}
+
+	var patches []Patch
+	if len(set) > 0 {
+		patches = append(patches, Patch{Kind: PatchMark, ObjID: obj, Path: path, Marks: sortMarkRanges(set)})
+	}
+	if len(cleared) > 0 {
+		patches = append(patches, Patch{Kind: PatchUnmark, ObjID: obj, Path: path, Marks: sortMarkRanges(cleared)})
+	}
+	return patches
+}
+
+// effectiveMarks returns, for each of n characters, the mark called name with
+// the highest OpID covering it, or nil where no mark or a null mark applies.
+func effectiveMarks(marks []Mark, name string, n int) []*Mark {
+	out := make([]*Mark, n)
+	for i := range marks {
+		m := &marks[i]
+		if m.Name != name {
+			continue
+		}
+		for j := max(m.Start, 0); j < min(m.End, n); j++ {
+			if out[j] == nil || out[j].OpID.Compare(m.OpID) < 0 {
+				out[j] = m
+			}
+		}
+	}
+	for j, m := range out {
+		if m != nil && m.Value.Kind == model.ScalarNull {
+			out[j] = nil
+		}
 	}
 	return out
 }
 
-func valuesEqual(a, b []opset.Value) bool {
-	if len(a) != len(b) {
-		return false
+func sortMarkRanges(ranges []MarkRange) []MarkRange {
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].Start != ranges[j].Start {
+			return ranges[i].Start < ranges[j].Start
+		}
+		return ranges[i].Name < ranges[j].Name
+	})
+	return ranges
+}
+
+type seqStepKind uint8
+
+const (
+	seqKeep seqStepKind = iota
+	seqDelete
+	seqInsert
+)
+
+type seqStep struct {
+	kind   seqStepKind
+	before int
+	after  int
+}
+
+// diffSequence walks two element sequences and returns the keep, delete and
+// insert steps that turn before into after. Elements keep their identity
+// across versions, so a kept element is one whose insert OpID is visible on
+// both sides. An element that moved behind an already kept one is reported as
+// a delete plus an insert.
+func diffSequence(before, after []opset.ListElement) []seqStep {
+	beforePos := make(map[model.OpID]int, len(before))
+	for i, e := range before {
+		beforePos[e.ID] = i
 	}
-	for i := range a {
-		if !a[i].Equal(b[i]) {
-			return false
+	steps := make([]seqStep, 0, len(after))
+	next := 0
+	for ai, e := range after {
+		bi, ok := beforePos[e.ID]
+		if !ok || bi < next {
+			steps = append(steps, seqStep{kind: seqInsert, before: -1, after: ai})
+			continue
 		}
+		for ; next < bi; next++ {
+			steps = append(steps, seqStep{kind: seqDelete, before: next, after: -1})
+		}
+		steps = append(steps, seqStep{kind: seqKeep, before: bi, after: ai})
+		next = bi + 1
+	}
+	for ; next < len(before); next++ {
+		steps = append(steps, seqStep{kind: seqDelete, before: next, after: -1})
 	}
-	return true
+	return steps
+}
+
+func elementText(e opset.ListElement) string {
+	return opset.TextOf(e.Value)
+}
+
+func mapSnapshot(view *opset.View, obj ObjID) map[string]Value {
+	items := view.IterMap(obj)
+	out := make(map[string]Value, len(items))
+	for _, item := range items {
+		out[item.Key] = item.Value
+	}
+	return out
 }
@@ -0,0 +1,83 @@
+package automerge_test
+
+import (
+	"testing"
+
+	"github.com/cjanietz/automerge-native-go/automerge"
+)
+
+// This test lives outside the package so that it only compiles against
+// exported names and never needs an internal import.
+func TestPublicAPIWithoutInternalImports(t *testing.T) {
+	doc := automerge.NewDocument()
+	tx, err := doc.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := automerge.RootObjID()
+	_ = tx.Put(root, "title", automerge.StringValue("hello"))
+	textID, _ := tx.PutObject(root, "text", automerge.ObjText)
+	_ = tx.SpliceText(textID, 0, 0, "A😀B")
+	_ = tx.Mark(textID, 0, 1, "bold", automerge.BoolValue(true))
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	heads := doc.Heads()
+
+	var v automerge.Value
+	v, ok := doc.GetMap(root, "title")
+	if !ok || v.Kind != automerge.ValueScalar || v.Scalar.Kind != automerge.ScalarString {
+		t.Fatalf("unexpected value: %#v", v)
+	}
+	if got, err := doc.TextAt(textID, heads); err != nil || got != "A😀B" {
+		t.Fatalf("unexpected text: %q err=%v", got, err)
+	}
+	var marks []automerge.Mark = doc.Marks(textID)
+	if len(marks) != 1 {
+		t.Fatalf("unexpected marks: %#v", marks)
+	}
+	cur, err := doc.CursorForText(textID, 3, automerge.EncodingUTF16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx, _ := doc.ResolveTextCursor(cur, automerge.EncodingUTF8); idx != 2 {
+		t.Fatalf("unexpected cursor index: %d", idx)
+	}
+
+	peer := automerge.NewDocument()
+	_ = peer.SetActor(2)
+	ours, theirs := automerge.NewSyncState(), automerge.NewSyncState()
+	for i := 0; i < 10; i++ {
+		msg, err := doc.Sync().GenerateSyncMessage(ours)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil {
+			break
+		}
+		enc, err := msg.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := automerge.DecodeSyncMessage(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := peer.Sync().ReceiveSyncMessage(theirs, dec); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := peer.Sync().GenerateSyncMessage(theirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply != nil {
+			if err := doc.Sync().ReceiveSyncMessage(ours, *reply); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var peerHeads []automerge.ChangeHash = peer.Heads()
+	if len(peerHeads) != 1 || peerHeads[0] != heads[0] {
+		t.Fatalf("peer did not converge: %v vs %v", peerHeads, heads)
+	}
+}
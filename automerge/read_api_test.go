@@ -0,0 +1,142 @@
+package automerge
+
+import (
+	"testing"
+
+	"github.com/cjanietz/automerge-native-go/internal/model"
+)
+
+func TestListReadAPI(t *testing.T) {
+	doc := NewDocument()
+	tx, _ := doc.Begin()
+	listID, _ := tx.PutObject(model.RootObjID(), "items", ObjList)
+	_ = tx.Insert(listID, 0, model.StringValue("a"))
+	_ = tx.Insert(listID, 1, model.StringValue("b"))
+	_, _ = tx.Commit()
+	h1 := doc.Heads()
+
+	tx2, _ := doc.Begin()
+	_ = tx2.Insert(listID, 2, model.StringValue("c"))
+	_, _ = tx2.Commit()
+
+	if got := doc.Length(listID); got != 3 {
+		t.Fatalf("unexpected list length: %d", got)
+	}
+	if got := doc.Length(model.RootObjID()); got != 1 {
+		t.Fatalf("unexpected map length: %d", got)
+	}
+	if got, err := doc.LengthAt(listID, h1); err != nil || got != 2 {
+		t.Fatalf("unexpected historical length: %d err=%v", got, err)
+	}
+	v, ok := doc.GetList(listID, 2)
+	if !ok || v.Scalar.String != "c" {
+		t.Fatalf("unexpected list element: %#v ok=%v", v, ok)
+	}
+	if _, ok := doc.GetList(listID, 3); ok {
+		t.Fatal("expected out-of-range index to be missing")
+	}
+	if _, ok, err := doc.GetListAt(listID, 2, h1); err != nil || ok {
+		t.Fatalf("expected element to be missing at old heads: ok=%v err=%v", ok, err)
+	}
+
+	// Seed a concurrent overwrite of the first element directly in the op set.
+	_ = doc.ops.SetListRaw(listID, 0, opsetString("x"), model.OpID{Counter: 1, Actor: 9}, 9, 1, nil)
+	all := doc.GetAllList(listID, 0)
+	if len(all) != 2 {
+		t.Fatalf("expected list conflict of 2 values, got %#v", all)
+	}
+	old, err := doc.GetAllListAt(listID, 0, h1)
+	if err != nil || len(old) != 1 || old[0].Scalar.String != "a" {
+		t.Fatalf("unexpected historical conflict set: %#v err=%v", old, err)
+	}
+}
+
+func TestRangeOverFuncIterators(t *testing.T) {
+	doc := NewDocument()
+	tx, _ := doc.Begin()
+	root := model.RootObjID()
+	_ = tx.Put(root, "b", model.IntValue(2))
+	_ = tx.Put(root, "a", model.IntValue(1))
+	listID, _ := tx.PutObject(root, "list", ObjList)
+	_ = tx.Insert(listID, 0, model.StringValue("x"))
+	_ = tx.Insert(listID, 1, model.StringValue("y"))
+	textID, _ := tx.PutObject(root, "text", ObjText)
+	_ = tx.SpliceText(textID, 0, 0, "h😀")
+	_, _ = tx.Commit()
+	tx2, _ := doc.Begin()
+	_ = tx2.Put(root, "c", model.IntValue(3))
+	_, _ = tx2.Commit()
+
+	var keys []string
+	for k := range doc.MapEntries(root) {
+		keys = append(keys, k)
+		if k == "b" {
+			break
+		}
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("unexpected map iteration: %#v", keys)
+	}
+
+	var items []string
+	for i, v := range doc.ListItems(listID) {
+		if len(items) != i {
+			t.Fatalf("unexpected list index %d", i)
+		}
+		items = append(items, v.Scalar.String)
+	}
+	if len(items) != 2 || items[0] != "x" || items[1] != "y" {
+		t.Fatalf("unexpected list iteration: %#v", items)
+	}
+
+	var runes []rune
+	for i, r := range doc.TextRunes(textID) {
+		if len(runes) != i {
+			t.Fatalf("unexpected rune index %d", i)
+		}
+		runes = append(runes, r)
+	}
+	if string(runes) != "h😀" {
+		t.Fatalf("unexpected text iteration: %q", string(runes))
+	}
+
+	var seqs []uint64
+	for h, c := range doc.Changes() {
+		if h != c.Hash {
+			t.Fatalf("hash key mismatch: %s vs %s", h, c.Hash)
+		}
+		seqs = append(seqs, c.Seq)
+	}
+	if len(seqs) != 2 || seqs[0] != 1 || seqs[1] != 2 {
+		t.Fatalf("unexpected change iteration: %#v", seqs)
+	}
+
+	// Inserts before the element just yielded are not revisited, and the
+	// indexes account for them.
+	items = items[:0]
+	var indexes []int
+	for i, v := range doc.ListItems(listID) {
+		items = append(items, v.Scalar.String)
+		indexes = append(indexes, i)
+		tx, _ := doc.Begin()
+		_ = tx.Insert(listID, 0, model.StringValue("z"))
+		_, _ = tx.Commit()
+	}
+	if len(items) != 2 || doc.Length(listID) != 4 || indexes[0] != 0 || indexes[1] != 2 {
+		t.Fatalf("unexpected iteration with inserts before it: %#v %v", items, indexes)
+	}
+
+	// Elements appended while iterating are reached.
+	items = items[:0]
+	for _, v := range doc.ListItems(listID) {
+		items = append(items, v.Scalar.String)
+		if v.Scalar.String == "y" {
+			tx, _ := doc.Begin()
+			_ = tx.Insert(listID, doc.Length(listID), model.StringValue("end"))
+			_, _ = tx.Commit()
+		}
+	}
+	if len(items) != 5 || items[4] != "end" {
+		t.Fatalf("unexpected iteration with an append: %#v", items)
+	}
+}
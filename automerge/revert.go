@@ -0,0 +1,269 @@
+package automerge
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cjanietz/automerge-native-go/internal/opset"
+)
+
+// ErrRevertNoOps is returned by RevertTo when the content differs from the
+// target but no operations could be derived to restore it.
+var ErrRevertNoOps = errors.New("revert found differences but produced no operations")
+
+// RevertTo commits one change that sets the content of the document back to
+// what it was at heads. It diffs the current state against heads and applies
+// the patches as new operations, so history is kept and peers merging the
+// change converge on the restored content. Empty heads revert to the empty
+// document. It returns a nil change when the content already matches.
+//
+// Objects that only exist at heads are recreated as copies, and list values
+// that were overwritten come back as new elements in the same place.
+func (d *Document) RevertTo(heads []ChangeHash) (*Change, error) {
+	patches, err := d.DiffObjWithOptions(RootObjID(), d.Heads(), heads, true, DefaultDiffOptions())
+	if err != nil {
+		return nil, err
+	}
+	clk, err := d.clockFromHeads(heads)
+	if err != nil {
+		return nil, err
+	}
+	target := d.ops.ViewAt(clk)
+	if sameContent(d.ops.CurrentView(), target, RootObjID(), RootObjID()) {
+		return nil, nil
+	}
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := applyRevertPatches(tx, target, patches); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if len(tx.ops) == 0 {
+		_ = tx.Rollback()
+		return nil, ErrRevertNoOps
+	}
+	return tx.Commit()
+}
+
+func (a *AutoCommit) RevertTo(heads []ChangeHash) (*Change, error) {
+	return a.doc.RevertTo(heads)
+}
+
+// applyRevertPatches turns the patches from the current state to target into
+// mutations. Patches inside objects that had to be copied from target are
+// covered by the copy and skipped.
+func applyRevertPatches(tx *Transaction, target *opset.View, patches []Patch) error {
+	copied := map[ObjID]struct{}{}
+	restore := func(v Value, put func(ScalarValue) error, putObject func(ObjType) (ObjID, error)) error {
+		if v.Kind == ValueObject {
+			markSubtree(target, v.Object.ID, copied)
+		}
+		return restoreValue(tx, target, v, put, putObject)
+	}
+	for _, p := range patches {
+		if _, ok := copied[p.ObjID]; ok {
+			continue
+		}
+		obj := p.ObjID
+		var err error
+		switch p.Kind {
+		case PatchMapPut:
+			err = restore(*p.NewValue,
+				func(v ScalarValue) error { return tx.Put(obj, p.Key, v) },
+				func(typ ObjType) (ObjID, error) { return tx.PutObject(obj, p.Key, typ) })
+		case PatchMapDelete:
+			err = tx.DeleteMap(obj, p.Key)
+		case PatchIncrement:
+			err = tx.Increment(obj, p.Key, p.Delta)
+		case PatchTextSplice:
+			if sameTextRun(tx.view(), target, obj, p.Index, p.DeleteCount, p.InsertText) {
+				// Restored text with new identity, but the same elements.
+				continue
+			}
+			if err = tx.spliceText(obj, p.Index, p.DeleteCount, ""); err != nil {
+				break
+			}
+			err = restoreTextRun(tx, target, obj, p.Index, p.InsertText, copied)
+		case PatchListDelete:
+			for range p.Count {
+				if err = tx.DeleteList(obj, p.Index); err != nil {
+					break
+				}
+			}
+		case PatchListPut:
+			if err = tx.DeleteList(obj, p.Index); err != nil {
+				break
+			}
+			fallthrough
+		case PatchListInsert:
+			values := p.Values
+			if p.Kind == PatchListPut {
+				values = []Value{*p.NewValue}
+			}
+			for i, v := range values {
+				index := p.Index + i
+				err = restore(v,
+					func(s ScalarValue) error { return tx.Insert(obj, index, s) },
+					func(typ ObjType) (ObjID, error) { return tx.InsertObject(obj, index, typ) })
+				if err != nil {
+					break
+				}
+			}
+		case PatchMark, PatchUnmark:
+			for _, m := range p.Marks {
+				if err = tx.mark(obj, m.Start, m.End, m.Name, m.Value, ExpandAfter); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreTextRun inserts text at index, which after the splices so far lines
+// up with the same index in target. Block markers, which read as newlines,
+// come back as copies of the block maps in target.
+func restoreTextRun(tx *Transaction, target *opset.View, obj ObjID, index int, text string, copied map[ObjID]struct{}) error {
+	var run strings.Builder
+	pos := index
+	flush := func() error {
+		err := tx.spliceText(obj, pos, 0, run.String())
+		pos += utf8.RuneCountInString(run.String())
+		run.Reset()
+		return err
+	}
+	for i, r := range []rune(text) {
+		v, ok := target.GetList(obj, index+i)
+		if !ok || !isBlockMarker(v) {
+			run.WriteRune(r)
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		markSubtree(target, v.Object.ID, copied)
+		at := pos
+		if err := restoreValue(tx, target, v, nil,
+			func(typ ObjType) (ObjID, error) { return tx.InsertObject(obj, at, typ) }); err != nil {
+			return err
+		}
+		pos++
+	}
+	return flush()
+}
+
+// sameTextRun reports whether the count elements at index of obj in cur
+// already hold text as it is at the same index in target. Characters compare
+// by their string, and block markers, which read as newlines, only match
+// block markers with the same content.
+func sameTextRun(cur, target *opset.View, obj ObjID, index, count int, text string) bool {
+	runes := []rune(text)
+	if len(runes) != count {
+		return false
+	}
+	for i, r := range runes {
+		vc, ok := cur.GetList(obj, index+i)
+		if !ok {
+			return false
+		}
+		vt, ok := target.GetList(obj, index+i)
+		if !ok {
+			return false
+		}
+		if isBlockMarker(vc) || isBlockMarker(vt) {
+			if !isBlockMarker(vc) || !isBlockMarker(vt) || !sameContent(cur, target, vc.Object.ID, vt.Object.ID) {
+				return false
+			}
+			continue
+		}
+		if opset.TextOf(vc) != string(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// markSubtree adds obj and every object below it in view to seen.
+func markSubtree(view *opset.View, obj ObjID, seen map[ObjID]struct{}) {
+	if _, ok := seen[obj]; ok {
+		return
+	}
+	seen[obj] = struct{}{}
+	visit := func(v Value) {
+		if v.Kind == ValueObject {
+			markSubtree(view, v.Object.ID, seen)
+		}
+	}
+	for _, v := range view.MapEntries(obj) {
+		visit(v)
+	}
+	for _, v := range view.ListItems(obj) {
+		visit(v)
+	}
+}
+
+// sameContent reports whether object x in a and object y in b hold the same
+// content, regardless of the identity of their ops and child objects.
+func sameContent(a, b *opset.View, x, y ObjID) bool {
+	typ, ok := a.ObjectType(x)
+	if other, otherOK := b.ObjectType(y); !ok || !otherOK || typ != other {
+		return false
+	}
+	same := func(va, vb Value) bool {
+		if va.Kind != vb.Kind {
+			return false
+		}
+		if va.Kind == ValueScalar {
+			return va.Scalar.Equal(vb.Scalar)
+		}
+		return sameContent(a, b, va.Object.ID, vb.Object.ID)
+	}
+	switch typ {
+	case ObjMap:
+		keys := a.KeysMap(x)
+		if !slices.Equal(keys, b.KeysMap(y)) {
+			return false
+		}
+		for _, k := range keys {
+			va, _ := a.GetMap(x, k)
+			vb, _ := b.GetMap(y, k)
+			if !same(va, vb) {
+				return false
+			}
+		}
+	case ObjList:
+		la, lb := a.ListRange(x, 0, -1), b.ListRange(y, 0, -1)
+		return slices.EqualFunc(la, lb, same)
+	case ObjText:
+		if a.Text(x) != b.Text(y) {
+			return false
+		}
+		la, lb := a.ListRange(x, 0, -1), b.ListRange(y, 0, -1)
+		if !slices.EqualFunc(la, lb, func(va, vb Value) bool {
+			return isBlockMarker(va) == isBlockMarker(vb) && (!isBlockMarker(va) || same(va, vb))
+		}) {
+			return false
+		}
+		ma, mb := a.Marks(x), b.Marks(y)
+		n := len(a.ListElements(x))
+		checked := map[string]bool{}
+		for _, m := range slices.Concat(ma, mb) {
+			if checked[m.Name] {
+				continue
+			}
+			checked[m.Name] = true
+			ea, eb := effectiveMarks(ma, m.Name, n), effectiveMarks(mb, m.Name, n)
+			if !slices.EqualFunc(ea, eb, func(p, q *Mark) bool { return sameMarkValue(markValue(p), markValue(q)) }) {
+				return false
+			}
+		}
+	}
+	return true
+}
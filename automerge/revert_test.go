@@ -0,0 +1,114 @@
+package automerge
+
+import (
+	"testing"
+)
+
+func TestRevertToRestoresContent(t *testing.T) {
+	d := NewDocument()
+	root := RootObjID()
+	var text, list, cfg ObjID
+	commitTx(t, d, func(tx *Transaction) error {
+		_ = tx.Put(root, "title", StringValue("draft"))
+		_ = tx.Put(root, "views", CounterValue(1))
+		text, _ = tx.PutObject(root, "text", ObjText)
+		_ = tx.SpliceText(text, 0, 0, "hello world")
+		_ = tx.Mark(text, 0, 5, "bold", BoolValue(true))
+		list, _ = tx.PutObject(root, "list", ObjList)
+		_ = tx.Insert(list, 0, IntValue(1))
+		_ = tx.Insert(list, 1, IntValue(2))
+		cfg, _ = tx.PutObject(root, "cfg", ObjMap)
+		tags, _ := tx.PutObject(cfg, "tags", ObjList)
+		return tx.Insert(tags, 0, StringValue("a"))
+	})
+	h1 := d.Heads()
+	want, _ := d.ToJSON(root, h1)
+
+	commitTx(t, d, func(tx *Transaction) error {
+		_ = tx.Put(root, "title", StringValue("final"))
+		_ = tx.Put(root, "extra", BoolValue(true))
+		_ = tx.Increment(root, "views", 4)
+		_ = tx.SpliceText(text, 6, 5, "there")
+		_ = tx.Mark(text, 0, 3, "italic", BoolValue(true))
+		_ = tx.Insert(list, 0, IntValue(0))
+		_ = tx.DeleteList(list, 2)
+		return tx.DeleteMap(root, "cfg")
+	})
+	h2 := d.Heads()
+
+	change, err := d.RevertTo(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change == nil || len(change.Deps) != 1 || change.Deps[0] != h2[0] {
+		t.Fatalf("expected one forward change on top of h2, got %#v", change)
+	}
+	got, _ := d.ToJSON(root, nil)
+	if string(got) != string(want) {
+		t.Fatalf("unexpected content after revert:\n got %s\nwant %s", got, want)
+	}
+	var bold, italic bool
+	for _, m := range d.MarksAtIndex(text, 1) {
+		bold = bold || m.Name == "bold" && m.Value.Kind != ScalarNull
+		italic = italic || m.Name == "italic" && m.Value.Kind != ScalarNull
+	}
+	if !bold || italic {
+		t.Fatalf("unexpected marks after revert: %#v", d.MarksAtIndex(text, 1))
+	}
+	if v, _ := d.GetMap(root, "views"); v.Scalar.Counter != 1 {
+		t.Fatalf("unexpected counter after revert: %#v", v)
+	}
+	// History is kept.
+	if old, _ := d.TextAt(text, h2); old != "hello there" {
+		t.Fatalf("unexpected text at h2: %q", old)
+	}
+	if change, err := d.RevertTo(h1); err != nil || change != nil {
+		t.Fatalf("expected nothing left to revert, got %v %v", change, err)
+	}
+
+	// A peer that merges the revert converges on the restored content.
+	peer := NewDocument()
+	if err := peer.Merge(d); err != nil {
+		t.Fatal(err)
+	}
+	if peerJSON, _ := peer.ToJSON(root, nil); string(peerJSON) != string(want) {
+		t.Fatalf("peer diverged: %s", peerJSON)
+	}
+}
+
+func TestRevertToEmptyHeads(t *testing.T) {
+	d := NewDocument()
+	commitTx(t, d, func(tx *Transaction) error { return tx.Put(RootObjID(), "k", IntValue(1)) })
+	if _, err := d.RevertTo(nil); err != nil {
+		t.Fatal(err)
+	}
+	if d.Length(RootObjID()) != 0 || len(d.Heads()) != 1 {
+		t.Fatalf("expected an empty root and a new head, got %d keys", d.Length(RootObjID()))
+	}
+	if _, err := d.RevertTo([]ChangeHash{{1}}); err == nil {
+		t.Fatal("expected an error for unknown heads")
+	}
+}
+
+func TestRevertToKeepsWinnerOfOverlappingMarks(t *testing.T) {
+	d := NewDocument()
+	root := RootObjID()
+	var text ObjID
+	commitTx(t, d, func(tx *Transaction) error {
+		text, _ = tx.PutObject(root, "text", ObjText)
+		_ = tx.SpliceText(text, 0, 0, "0123456789")
+		_ = tx.Mark(text, 2, 4, "b", StringValue("x"))
+		return tx.Mark(text, 0, 10, "b", StringValue("y"))
+	})
+	h1 := d.Heads()
+	commitTx(t, d, func(tx *Transaction) error { return tx.DeleteMap(root, "text") })
+
+	if _, err := d.RevertTo(h1); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := d.GetMap(root, "text")
+	marks := d.MarksAtIndex(v.Object.ID, 3)
+	if d.Text(v.Object.ID) != "0123456789" || len(marks) != 1 || marks[0].Value.String != "y" {
+		t.Fatalf("unexpected marks after revert: %#v", marks)
+	}
+}
@@ -67,20 +67,27 @@ type changeDTO struct {
 }
 
 type changeOperationDTO struct {
-	Kind        uint8     `json:"kind"`
-	ObjID       objIDDTO  `json:"obj_id"`
-	ChildObjID  objIDDTO  `json:"child_obj_id"`
-	Key         string    `json:"key"`
-	Index       int       `json:"index"`
-	Start       int       `json:"start"`
-	End         int       `json:"end"`
-	MarkName    string    `json:"mark_name"`
-	Value       scalarDTO `json:"value"`
-	ObjType     uint8     `json:"obj_type"`
-	By          int64     `json:"by"`
-	DeleteCount int       `json:"delete_count"`
-	InsertText  string    `json:"insert_text"`
-	OpID        opIDDTO   `json:"op_id"`
+	Kind        uint8      `json:"kind"`
+	ObjID       objIDDTO   `json:"obj_id"`
+	ChildObjID  objIDDTO   `json:"child_obj_id"`
+	Key         string     `json:"key"`
+	Index       int        `json:"index"`
+	Start       int        `json:"start"`
+	End         int        `json:"end"`
+	MarkName    string     `json:"mark_name"`
+	Expand      uint8      `json:"expand,omitempty"`
+	Identified  bool       `json:"identified,omitempty"`
+	Elem        *opIDDTO   `json:"elem,omitempty"`
+	Deleted     []opIDDTO  `json:"deleted,omitempty"`
+	Pred        []opIDDTO  `json:"pred,omitempty"`
+	StartAnchor *anchorDTO `json:"start_anchor,omitempty"`
+	EndAnchor   *anchorDTO `json:"end_anchor,omitempty"`
+	Value       scalarDTO  `json:"value"`
+	ObjType     uint8      `json:"obj_type"`
+	By          int64      `json:"by"`
+	DeleteCount int        `json:"delete_count"`
+	InsertText  string     `json:"insert_text"`
+	OpID        opIDDTO    `json:"op_id"`
 }
 
 type objIDDTO struct {
@@ -92,6 +99,10 @@ type opIDDTO struct {
 	Counter uint64 `json:"counter"`
 	Actor   uint32 `json:"actor"`
 }
+type anchorDTO struct {
+	Elem  opIDDTO `json:"elem"`
+	After bool    `json:"after,omitempty"`
+}
 type scalarDTO struct {
 	Kind     uint8   `json:"kind"`
 	Bytes    []byte  `json:"bytes,omitempty"`
@@ -159,8 +170,8 @@ func (d *Document) SaveNoCompress() ([]byte, error) {
 	return d.SaveWithOptions(o)
 }
 
-func (d *Document) SaveAfter(heads []model.ChangeHash) ([]byte, error) {
-	baseSet := map[model.ChangeHash]struct{}{}
+func (d *Document) SaveAfter(heads []ChangeHash) ([]byte, error) {
+	baseSet := map[ChangeHash]struct{}{}
 	if len(heads) > 0 {
 		hashes, err := d.graph.GetHashesFromHeads(heads)
 		if err != nil {
@@ -284,6 +295,9 @@ func LoadWithOptions(data []byte, opts LoadOptions) (*Document, error) {
 	return d, nil
 }
 
+// LoadIncremental applies the changes saved in data and returns how many heads
+// they added. While a transaction is open the changes are held until it
+// closes, as in ApplyChanges, and the count is 0.
 func (d *Document) LoadIncremental(data []byte) (int, error) {
 	before := len(d.Heads())
 	loaded, err := LoadWithOptions(data, LoadOptions{OnPartialLoad: OnPartialIgnore, Verification: VerificationCheck, StringMigration: StringMigrationNone})
@@ -316,12 +330,28 @@ func encodeChange(c Change) changeDTO {
 			Start:       op.Start,
 			End:         op.End,
 			MarkName:    op.MarkName,
+			Expand:      uint8(op.Expand),
 			Value:       encodeScalar(op.Value),
 			ObjType:     uint8(op.ObjType),
 			By:          op.By,
 			DeleteCount: op.DeleteCount,
 			InsertText:  op.InsertText,
 			OpID:        encodeOpID(op.OpID),
+			Identified:  op.Identified,
+		}
+		if !op.Identified {
+			continue
+		}
+		switch op.Kind {
+		case OpMark:
+			ops[i].StartAnchor = encodeAnchor(op.StartAnchor)
+			ops[i].EndAnchor = encodeAnchor(op.EndAnchor)
+		case OpInsert, OpInsertObject, OpDeleteList, OpSpliceText:
+			elem := encodeOpID(op.Elem)
+			ops[i].Elem = &elem
+			ops[i].Deleted = encodeOpIDs(op.Deleted)
+		case OpPut, OpPutObject, OpDeleteMap:
+			ops[i].Pred = encodeOpIDs(op.Pred)
 		}
 	}
 	return changeDTO{Hash: c.Hash.String(), Actor: c.Actor, Seq: c.Seq, StartOp: c.StartOp, MaxOp: c.MaxOp, Deps: deps, Message: c.Message, Time: c.Time, Operations: ops}
@@ -334,7 +364,7 @@ func decodeChanges(in []changeDTO) ([]Change, error) {
 		if err != nil {
 			return nil, err
 		}
-		deps := make([]model.ChangeHash, len(c.Deps))
+		deps := make([]ChangeHash, len(c.Deps))
 		for i, d := range c.Deps {
 			deps[i], err = model.ChangeHashFromHex(d)
 			if err != nil {
@@ -352,35 +382,76 @@ func decodeChanges(in []changeDTO) ([]Change, error) {
 				Start:       op.Start,
 				End:         op.End,
 				MarkName:    op.MarkName,
+				Expand:      ExpandMark(op.Expand),
 				Value:       decodeScalar(op.Value),
 				ObjType:     ObjType(op.ObjType),
 				By:          op.By,
 				DeleteCount: op.DeleteCount,
 				InsertText:  op.InsertText,
 				OpID:        decodeOpID(op.OpID),
+				Identified:  op.Identified,
+			}
+			if op.StartAnchor != nil {
+				ops[i].StartAnchor = decodeAnchor(*op.StartAnchor)
 			}
+			if op.EndAnchor != nil {
+				ops[i].EndAnchor = decodeAnchor(*op.EndAnchor)
+			}
+			if op.Elem != nil {
+				ops[i].Elem = decodeOpID(*op.Elem)
+			}
+			ops[i].Deleted = decodeOpIDs(op.Deleted)
+			ops[i].Pred = decodeOpIDs(op.Pred)
 		}
 		out = append(out, Change{Hash: h, Actor: c.Actor, Seq: c.Seq, StartOp: c.StartOp, MaxOp: c.MaxOp, Deps: deps, Message: c.Message, Time: c.Time, Operations: ops})
 	}
 	return out, nil
 }
 
-func encodeObjID(v model.ObjID) objIDDTO {
+func encodeObjID(v ObjID) objIDDTO {
 	return objIDDTO{Root: v.Root, Counter: v.Op.Counter, Actor: v.Op.Actor}
 }
-func decodeObjID(v objIDDTO) model.ObjID {
+func decodeObjID(v objIDDTO) ObjID {
 	if v.Root {
 		return model.RootObjID()
 	}
-	return model.ObjID{Op: model.OpID{Counter: v.Counter, Actor: v.Actor}}
+	return ObjID{Op: OpID{Counter: v.Counter, Actor: v.Actor}}
+}
+func encodeOpID(v OpID) opIDDTO { return opIDDTO{Counter: v.Counter, Actor: v.Actor} }
+func decodeOpID(v opIDDTO) OpID { return OpID{Counter: v.Counter, Actor: v.Actor} }
+
+func encodeOpIDs(ids []OpID) []opIDDTO {
+	if len(ids) == 0 {
+		return nil
+	}
+	out := make([]opIDDTO, len(ids))
+	for i, id := range ids {
+		out[i] = encodeOpID(id)
+	}
+	return out
+}
+func decodeOpIDs(in []opIDDTO) []OpID {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make([]OpID, len(in))
+	for i, v := range in {
+		out[i] = decodeOpID(v)
+	}
+	return out
+}
+
+func encodeAnchor(a MarkAnchor) *anchorDTO {
+	return &anchorDTO{Elem: encodeOpID(a.Elem), After: a.After}
+}
+func decodeAnchor(a anchorDTO) MarkAnchor {
+	return MarkAnchor{Elem: decodeOpID(a.Elem), After: a.After}
 }
-func encodeOpID(v model.OpID) opIDDTO { return opIDDTO{Counter: v.Counter, Actor: v.Actor} }
-func decodeOpID(v opIDDTO) model.OpID { return model.OpID{Counter: v.Counter, Actor: v.Actor} }
-func encodeScalar(v model.ScalarValue) scalarDTO {
+func encodeScalar(v ScalarValue) scalarDTO {
 	return scalarDTO{Kind: uint8(v.Kind), Bytes: v.Bytes, String: v.String, Int: v.Int, Uint: v.Uint, F64: v.F64, Counter: v.Counter, Time: v.Time, Boolean: v.Boolean, TypeCode: v.TypeCode}
 }
-func decodeScalar(v scalarDTO) model.ScalarValue {
-	return model.ScalarValue{Kind: model.ScalarKind(v.Kind), Bytes: v.Bytes, String: v.String, Int: v.Int, Uint: v.Uint, F64: v.F64, Counter: v.Counter, Time: v.Time, Boolean: v.Boolean, TypeCode: v.TypeCode}
+func decodeScalar(v scalarDTO) ScalarValue {
+	return ScalarValue{Kind: model.ScalarKind(v.Kind), Bytes: v.Bytes, String: v.String, Int: v.Int, Uint: v.Uint, F64: v.F64, Counter: v.Counter, Time: v.Time, Boolean: v.Boolean, TypeCode: v.TypeCode}
 }
 
 func (d *Document) Validate() error                    { return d.graph.Validate() }
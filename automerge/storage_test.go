@@ -27,12 +27,12 @@ func TestSaveLoadRoundTrip(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	v1, _ := d.GetMap(model.RootObjID(), "name", nil)
-	v2, ok := loaded.GetMap(model.RootObjID(), "name", nil)
+	v1, _ := d.GetMap(model.RootObjID(), "name")
+	v2, ok := loaded.GetMap(model.RootObjID(), "name")
 	if !ok || !v1.Equal(v2) {
 		t.Fatalf("map mismatch after load: %#v %#v", v1, v2)
 	}
-	if got := loaded.Text(textID, nil); got != "hello" {
+	if got := loaded.Text(textID); got != "hello" {
 		t.Fatalf("text mismatch after load: %q", got)
 	}
 }
@@ -66,7 +66,7 @@ func TestSaveAfterAndLoadIncremental(t *testing.T) {
 	if _, err := d2.LoadIncremental(inc); err != nil {
 		t.Fatal(err)
 	}
-	v, ok := d2.GetMap(model.RootObjID(), "k", nil)
+	v, ok := d2.GetMap(model.RootObjID(), "k")
 	if !ok || v.Scalar.String != "v2" {
 		t.Fatalf("unexpected incremental value: %#v", v)
 	}
@@ -139,7 +139,7 @@ func TestSaveCacheInvalidatedOnMutation(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	v, ok := loaded.GetMap(model.RootObjID(), "k", nil)
+	v, ok := loaded.GetMap(model.RootObjID(), "k")
 	if !ok || v.Scalar.String != "v2" {
 		t.Fatalf("unexpected loaded value after cache invalidation: %#v ok=%v", v, ok)
 	}
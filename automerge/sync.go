@@ -11,6 +11,26 @@ import (
 
 var ErrSyncDecodeChanges = errors.New("sync decode changes")
 
+type (
+	// SyncState tracks one peer connection; keep one per peer and persist it
+	// with Encode/DecodeSyncState between sessions.
+	SyncState      = intsync.State
+	SyncMessage    = intsync.Message
+	SyncHave       = intsync.Have
+	SyncCapability = intsync.Capability
+)
+
+const (
+	SyncCapabilityMessageV1 = intsync.CapabilityMessageV1
+	SyncCapabilityMessageV2 = intsync.CapabilityMessageV2
+)
+
+func NewSyncState() *SyncState { return intsync.NewState() }
+
+func DecodeSyncState(in []byte) (*SyncState, error) { return intsync.DecodeState(in) }
+
+func DecodeSyncMessage(in []byte) (SyncMessage, error) { return intsync.DecodeMessage(in) }
+
 type SyncEngine struct {
 	doc *Document
 }
@@ -19,16 +39,16 @@ func (d *Document) Sync() *SyncEngine {
 	return &SyncEngine{doc: d}
 }
 
-func (s *SyncEngine) GenerateSyncMessage(state *intsync.State) (*intsync.Message, error) {
+func (s *SyncEngine) GenerateSyncMessage(state *SyncState) (*SyncMessage, error) {
 	if state == nil {
 		return nil, nil
 	}
 	if state.SentHashes == nil {
-		state.SentHashes = make(map[model.ChangeHash]struct{})
+		state.SentHashes = make(map[ChangeHash]struct{})
 	}
 
 	ourHeads := s.doc.Heads()
-	theirHeads := []model.ChangeHash{}
+	theirHeads := []ChangeHash{}
 	if state.TheirHeads != nil {
 		theirHeads = append(theirHeads, (*state.TheirHeads)...)
 	}
@@ -44,7 +64,7 @@ func (s *SyncEngine) GenerateSyncMessage(state *intsync.State) (*intsync.Message
 		SupportedCapabilities: []intsync.Capability{intsync.CapabilityMessageV1, intsync.CapabilityMessageV2},
 	}
 
-	var hashesToSend []model.ChangeHash
+	var hashesToSend []ChangeHash
 	if state.SendDoc() {
 		all, err := s.doc.graph.GetHashesFromHeads(ourHeads)
 		if err != nil {
@@ -103,7 +123,7 @@ func (s *SyncEngine) GenerateSyncMessage(state *intsync.State) (*intsync.Message
 	}
 
 	state.HaveResponded = true
-	state.LastSentHeads = append([]model.ChangeHash(nil), ourHeads...)
+	state.LastSentHeads = append([]ChangeHash(nil), ourHeads...)
 	for _, h := range hashesToSend {
 		state.SentHashes[h] = struct{}{}
 	}
@@ -111,7 +131,10 @@ func (s *SyncEngine) GenerateSyncMessage(state *intsync.State) (*intsync.Message
 	return msg, nil
 }
 
-func (s *SyncEngine) ReceiveSyncMessage(state *intsync.State, msg intsync.Message) error {
+// ReceiveSyncMessage updates state from msg and applies the changes it carries.
+// While a transaction is open the changes are held until it closes, as in
+// ApplyChanges; the peer resends what is still missing in the next round.
+func (s *SyncEngine) ReceiveSyncMessage(state *SyncState, msg SyncMessage) error {
 	if state == nil {
 		return nil
 	}
@@ -159,28 +182,28 @@ func (s *SyncEngine) ReceiveSyncMessage(state *intsync.State, msg intsync.Messag
 
 	shared := intersectHashes(s.doc.Heads(), msg.Heads)
 	state.SharedHeads = shared
-	state.SentHashes = make(map[model.ChangeHash]struct{})
+	state.SentHashes = make(map[ChangeHash]struct{})
 	return nil
 }
 
-func (s *SyncEngine) makeHave(lastSync []model.ChangeHash) intsync.Have {
+func (s *SyncEngine) makeHave(lastSync []ChangeHash) intsync.Have {
 	hashes, err := s.doc.graph.GetHashesFromHeads(lastSync)
 	if err != nil || len(lastSync) == 0 {
 		hashes, _ = s.doc.graph.GetHashesFromHeads(s.doc.Heads())
 	}
-	return intsync.Have{LastSync: append([]model.ChangeHash(nil), lastSync...), Bloom: intsync.BloomFromHashes(hashes)}
+	return intsync.Have{LastSync: append([]ChangeHash(nil), lastSync...), Bloom: intsync.BloomFromHashes(hashes)}
 }
 
-func (s *SyncEngine) getHashesToSend(have []intsync.Have, need []model.ChangeHash) []model.ChangeHash {
+func (s *SyncEngine) getHashesToSend(have []intsync.Have, need []ChangeHash) []ChangeHash {
 	all, err := s.doc.graph.GetHashesFromHeads(s.doc.Heads())
 	if err != nil {
 		return nil
 	}
-	needSet := make(map[model.ChangeHash]struct{}, len(need))
+	needSet := make(map[ChangeHash]struct{}, len(need))
 	for _, n := range need {
 		needSet[n] = struct{}{}
 	}
-	out := make([]model.ChangeHash, 0, len(all))
+	out := make([]ChangeHash, 0, len(all))
 	for _, h := range all {
 		if _, ok := s.doc.changes[h]; !ok {
 			continue
@@ -204,7 +227,7 @@ func (s *SyncEngine) getHashesToSend(have []intsync.Have, need []model.ChangeHas
 	return out
 }
 
-func (s *SyncEngine) serializeChangesByHashes(hashes []model.ChangeHash) ([]byte, error) {
+func (s *SyncEngine) serializeChangesByHashes(hashes []ChangeHash) ([]byte, error) {
 	dtos := make([]changeDTO, 0, len(hashes))
 	for _, h := range hashes {
 		c, ok := s.doc.changes[h]
@@ -216,12 +239,12 @@ func (s *SyncEngine) serializeChangesByHashes(hashes []model.ChangeHash) ([]byte
 	return json.Marshal(dtos)
 }
 
-func (d *Document) getMissingDeps(heads []model.ChangeHash) []model.ChangeHash {
-	inQueue := make(map[model.ChangeHash]struct{}, len(d.queue))
+func (d *Document) getMissingDeps(heads []ChangeHash) []ChangeHash {
+	inQueue := make(map[ChangeHash]struct{}, len(d.queue))
 	for _, c := range d.queue {
 		inQueue[c.Hash] = struct{}{}
 	}
-	missingSet := make(map[model.ChangeHash]struct{})
+	missingSet := make(map[ChangeHash]struct{})
 	for _, c := range d.queue {
 		for _, dep := range c.Deps {
 			if !d.hasChange(dep) {
@@ -234,7 +257,7 @@ func (d *Document) getMissingDeps(heads []model.ChangeHash) []model.ChangeHash {
 			missingSet[h] = struct{}{}
 		}
 	}
-	missing := make([]model.ChangeHash, 0, len(missingSet))
+	missing := make([]ChangeHash, 0, len(missingSet))
 	for h := range missingSet {
 		if _, queued := inQueue[h]; queued {
 			continue
@@ -245,12 +268,12 @@ func (d *Document) getMissingDeps(heads []model.ChangeHash) []model.ChangeHash {
 	return missing
 }
 
-func ptrHashes(h []model.ChangeHash) *[]model.ChangeHash {
-	cp := append([]model.ChangeHash(nil), h...)
+func ptrHashes(h []ChangeHash) *[]ChangeHash {
+	cp := append([]ChangeHash(nil), h...)
 	return &cp
 }
 
-func hashesEqual(a, b []model.ChangeHash) bool {
+func hashesEqual(a, b []ChangeHash) bool {
 	if len(a) != len(b) {
 		return false
 	}
@@ -262,12 +285,12 @@ func hashesEqual(a, b []model.ChangeHash) bool {
 	return true
 }
 
-func intersectHashes(a, b []model.ChangeHash) []model.ChangeHash {
-	set := make(map[model.ChangeHash]struct{}, len(a))
+func intersectHashes(a, b []ChangeHash) []ChangeHash {
+	set := make(map[ChangeHash]struct{}, len(a))
 	for _, x := range a {
 		set[x] = struct{}{}
 	}
-	out := make([]model.ChangeHash, 0)
+	out := make([]ChangeHash, 0)
 	for _, y := range b {
 		if _, ok := set[y]; ok {
 			out = append(out, y)
@@ -62,10 +62,10 @@ func TestTwoPeerSyncConverges(t *testing.T) {
 		}
 	}
 
-	if v, ok := p1.GetMap(model.RootObjID(), "b", nil); !ok || v.Scalar.String != "two" {
+	if v, ok := p1.GetMap(model.RootObjID(), "b"); !ok || v.Scalar.String != "two" {
 		t.Fatalf("p1 missing b after sync: %#v ok=%v", v, ok)
 	}
-	if v, ok := p2.GetMap(model.RootObjID(), "a", nil); !ok || v.Scalar.String != "one" {
+	if v, ok := p2.GetMap(model.RootObjID(), "a"); !ok || v.Scalar.String != "one" {
 		t.Fatalf("p2 missing a after sync: %#v ok=%v", v, ok)
 	}
 }
@@ -0,0 +1,145 @@
+package automerge
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cjanietz/automerge-native-go/internal/changegraph"
+	"github.com/cjanietz/automerge-native-go/internal/opset"
+	inttext "github.com/cjanietz/automerge-native-go/internal/text"
+)
+
+// ErrSplitGrapheme is returned in grapheme mode for a text index that falls
+// inside a user-perceived character.
+var ErrSplitGrapheme = errors.New("index splits a grapheme cluster")
+
+// TextOptions control how the text APIs count indexes.
+type TextOptions struct {
+	// Encoding counts the indexes and lengths of the text APIs that take no
+	// explicit encoding.
+	Encoding Encoding
+	// Graphemes refuses splices and marks whose boundaries fall inside a
+	// grapheme cluster, such as between a letter and its combining accent.
+	Graphemes bool
+}
+
+func DefaultTextOptions() TextOptions {
+	return TextOptions{Encoding: EncodingUTF8}
+}
+
+// SetTextOptions changes how SpliceText, Mark, Marks, MarksAtIndex and Length
+// count text indexes. It applies to transactions begun afterwards as well as
+// open ones.
+func (d *Document) SetTextOptions(opts TextOptions) {
+	d.textOpts = opts
+}
+
+func (d *Document) TextOptions() TextOptions {
+	return d.textOpts
+}
+
+func (d *Document) MarksWithEncoding(obj ObjID, enc Encoding) []Mark {
+	return d.marks(obj, enc, nil)
+}
+
+func (d *Document) MarksAtIndexWithEncoding(obj ObjID, index int, enc Encoding) []Mark {
+	return d.marksAtIndex(obj, index, enc, nil)
+}
+
+// LengthWithEncoding is like Length, but counts text objects in enc units.
+func (d *Document) LengthWithEncoding(obj ObjID, enc Encoding) int {
+	return objectLength(d.ops.ViewAt(nil), obj, enc)
+}
+
+// Span is a run of text with the same marks in effect on every character.
+type Span struct {
+	Text string
+	// Marks maps the name of each mark on the run to its value. It is nil for
+	// unmarked text.
+	Marks map[string]ScalarValue
+}
+
+// Spans splits a text object into runs for rendering. Adjacent characters with
+// the same marks share a span, and where marks of the same name overlap the
+// one with the highest OpID applies, as for MarksAtIndex. Empty heads read the
+// current state.
+func (d *Document) Spans(obj ObjID, heads []ChangeHash) ([]Span, error) {
+	var at *changegraph.Clock
+	if len(heads) > 0 {
+		clk, err := d.clockFromHeads(heads)
+		if err != nil {
+			return nil, err
+		}
+		at = clk
+	}
+	view := d.ops.ViewAt(at)
+	typ, ok := view.ObjectType(obj)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", opset.ErrUnknownObject, obj)
+	}
+	if typ != ObjText {
+		return nil, fmt.Errorf("%w: have=%s", opset.ErrWrongObjectType, typ)
+	}
+	elems := view.ListElements(obj)
+	marks := make([]map[string]ScalarValue, len(elems))
+	for _, m := range view.MarkSpans(obj) {
+		for i := m.Start; i < m.End; i++ {
+			if marks[i] == nil {
+				marks[i] = map[string]ScalarValue{}
+			}
+			marks[i][m.Name] = m.Value
+		}
+	}
+	var out []Span
+	var text strings.Builder
+	for i, e := range elems {
+		text.WriteString(elementText(e))
+		if i+1 < len(elems) && maps.EqualFunc(marks[i], marks[i+1], ScalarValue.Equal) {
+			continue
+		}
+		out = append(out, Span{Text: text.String(), Marks: marks[i]})
+		text.Reset()
+	}
+	return out, nil
+}
+
+func (d *Document) marks(obj ObjID, enc Encoding, at *changegraph.Clock) []Mark {
+	marks := d.ops.MarkSpans(obj, at)
+	if enc == EncodingUTF8 {
+		return marks
+	}
+	text := d.ops.Text(obj, at)
+	for i := range marks {
+		marks[i].Start = inttext.ConvertIndex(text, marks[i].Start, EncodingUTF8, enc)
+		marks[i].End = inttext.ConvertIndex(text, marks[i].End, EncodingUTF8, enc)
+	}
+	return marks
+}
+
+func (d *Document) marksAtIndex(obj ObjID, index int, enc Encoding, at *changegraph.Clock) []Mark {
+	if enc == EncodingUTF8 {
+		return d.ops.MarksAtIndex(obj, index, at)
+	}
+	text := d.ops.Text(obj, at)
+	return d.ops.MarksAtIndex(obj, inttext.ConvertIndex(text, index, enc, EncodingUTF8), at)
+}
+
+// textRuneIndex converts index, counted in enc units of text, to a rune index.
+// It fails for indexes out of range or inside a code point, and in grapheme
+// mode for indexes inside a grapheme cluster.
+func textRuneIndex(text string, index int, enc Encoding, graphemes bool) (int, error) {
+	if n := inttext.Length(text, enc); index < 0 || index > n {
+		return 0, fmt.Errorf("%w: index=%d length=%d", opset.ErrInvalidIndex, index, n)
+	}
+	r := inttext.ConvertIndex(text, index, enc, EncodingUTF8)
+	if inttext.ConvertIndex(text, r, EncodingUTF8, enc) != index {
+		return 0, fmt.Errorf("%w: index=%d splits a code point", opset.ErrInvalidIndex, index)
+	}
+	if graphemes && r < utf8.RuneCountInString(text) && inttext.ClampToGraphemeStart(text, r) != r {
+		return 0, fmt.Errorf("%w: index=%d", ErrSplitGrapheme, index)
+	}
+	return r, nil
+}
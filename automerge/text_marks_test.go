@@ -2,9 +2,12 @@ package automerge
 
 import (
 	"errors"
+	"maps"
+	"slices"
 	"testing"
 
 	"github.com/cjanietz/automerge-native-go/internal/model"
+	"github.com/cjanietz/automerge-native-go/internal/opset"
 	inttext "github.com/cjanietz/automerge-native-go/internal/text"
 )
 
@@ -31,17 +34,17 @@ func TestTransactionMarkBeginEndAndRetrieval(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	marks := doc.Marks(textID, nil)
+	marks := doc.Marks(textID)
 	if len(marks) != 1 {
 		t.Fatalf("expected 1 mark, got %d", len(marks))
 	}
 	if marks[0].Start != 1 || marks[0].End != 4 || marks[0].Name != "bold" {
 		t.Fatalf("unexpected mark span: %#v", marks[0])
 	}
-	if at := doc.MarksAtIndex(textID, 2, nil); len(at) != 1 || at[0].Name != "bold" {
+	if at := doc.MarksAtIndex(textID, 2); len(at) != 1 || at[0].Name != "bold" {
 		t.Fatalf("unexpected active marks at index: %#v", at)
 	}
-	if at := doc.MarksAtIndex(textID, 0, nil); len(at) != 0 {
+	if at := doc.MarksAtIndex(textID, 0); len(at) != 0 {
 		t.Fatalf("expected no active marks at index 0, got %#v", at)
 	}
 }
@@ -109,3 +112,295 @@ func TestTextCursorEncodingRoundTrip(t *testing.T) {
 		t.Fatalf("expected rune index 2 (after emoji), got %d", runeIdx)
 	}
 }
+
+func TestTextAPIsUseDocumentEncoding(t *testing.T) {
+	doc := NewDocument()
+	doc.SetTextOptions(TextOptions{Encoding: EncodingUTF16})
+	var textID ObjID
+	commitTx(t, doc, func(tx *Transaction) error {
+		textID, _ = tx.PutObject(model.RootObjID(), "text", ObjText)
+		if err := tx.SpliceText(textID, 0, 0, "a😀b"); err != nil {
+			return err
+		}
+		// "b" starts at UTF-16 offset 3, after the surrogate pair.
+		if err := tx.SpliceText(textID, 3, 1, "c"); err != nil {
+			return err
+		}
+		if err := tx.SpliceText(textID, 2, 0, "x"); !errors.Is(err, opset.ErrInvalidIndex) {
+			t.Fatalf("expected a split surrogate pair to be refused, got %v", err)
+		}
+		return tx.Mark(textID, 1, 3, "bold", model.BoolValue(true))
+	})
+	if got := doc.Text(textID); got != "a😀c" {
+		t.Fatalf("unexpected text: %q", got)
+	}
+	if n := doc.Length(textID); n != 4 {
+		t.Fatalf("expected UTF-16 length 4, got %d", n)
+	}
+	if n := doc.LengthWithEncoding(textID, EncodingUTF8); n != 3 {
+		t.Fatalf("expected rune length 3, got %d", n)
+	}
+	if marks := doc.Marks(textID); len(marks) != 1 || marks[0].Start != 1 || marks[0].End != 3 {
+		t.Fatalf("unexpected UTF-16 marks: %#v", marks)
+	}
+	if marks := doc.MarksWithEncoding(textID, EncodingUTF8); len(marks) != 1 || marks[0].Start != 1 || marks[0].End != 2 {
+		t.Fatalf("unexpected rune marks: %#v", marks)
+	}
+	if at := doc.MarksAtIndex(textID, 3); len(at) != 0 {
+		t.Fatalf("expected no marks at the c, got %#v", at)
+	}
+	if at := doc.MarksAtIndexWithEncoding(textID, 1, EncodingUTF8); len(at) != 1 {
+		t.Fatalf("expected bold at the emoji, got %#v", at)
+	}
+}
+
+func TestTextGraphemeModeRefusesSplitCharacters(t *testing.T) {
+	doc := NewDocument()
+	doc.SetTextOptions(TextOptions{Encoding: EncodingUTF8, Graphemes: true})
+	var textID ObjID
+	commitTx(t, doc, func(tx *Transaction) error {
+		textID, _ = tx.PutObject(model.RootObjID(), "text", ObjText)
+		return tx.SpliceText(textID, 0, 0, "cafe\u0301!")
+	})
+	tx, err := doc.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.SpliceText(textID, 4, 0, "x"); !errors.Is(err, ErrSplitGrapheme) {
+		t.Fatalf("expected an insert before the accent to be refused, got %v", err)
+	}
+	if err := tx.SpliceText(textID, 2, 2, ""); !errors.Is(err, ErrSplitGrapheme) {
+		t.Fatalf("expected a delete ending inside the é to be refused, got %v", err)
+	}
+	if err := tx.Mark(textID, 0, 4, "bold", model.BoolValue(true)); !errors.Is(err, ErrSplitGrapheme) {
+		t.Fatalf("expected a mark ending inside the é to be refused, got %v", err)
+	}
+	if err := tx.SpliceText(textID, 3, 2, "e"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.SpliceText(textID, 5, 0, "?"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.Text(textID); got != "cafe!?" {
+		t.Fatalf("unexpected text: %q", got)
+	}
+}
+
+func TestMarkExpandPolicySurvivesMergeAndLoad(t *testing.T) {
+	doc := NewDocument()
+	var textID ObjID
+	commitTx(t, doc, func(tx *Transaction) error {
+		textID, _ = tx.PutObject(model.RootObjID(), "text", ObjText)
+		if err := tx.SpliceText(textID, 0, 0, "one two"); err != nil {
+			return err
+		}
+		if err := tx.Mark(textID, 0, 3, "bold", model.BoolValue(true)); err != nil {
+			return err
+		}
+		return tx.MarkWithExpand(textID, 4, 7, "link", model.StringValue("x"), ExpandNone)
+	})
+	data, err := doc.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := NewDocument()
+	if err := peer.Merge(doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []*Document{loaded, peer} {
+		// Typing at the end of each mark extends bold but not the link.
+		commitTx(t, d, func(tx *Transaction) error {
+			if err := tx.SpliceText(textID, 7, 0, "!"); err != nil {
+				return err
+			}
+			return tx.SpliceText(textID, 3, 0, "s")
+		})
+		marks := d.Marks(textID)
+		if len(marks) != 2 {
+			t.Fatalf("expected 2 marks, got %#v", marks)
+		}
+		if m := marks[0]; m.Name != "bold" || m.Start != 0 || m.End != 4 || m.Expand != ExpandAfter {
+			t.Fatalf("unexpected bold mark: %#v", m)
+		}
+		if m := marks[1]; m.Name != "link" || m.Start != 5 || m.End != 8 || m.Expand != ExpandNone {
+			t.Fatalf("unexpected link mark: %#v", m)
+		}
+	}
+}
+
+func TestConcurrentMarkKeepsItsCharactersAcrossMergeAndLoad(t *testing.T) {
+	a := NewDocument()
+	var textID ObjID
+	commitTx(t, a, func(tx *Transaction) error {
+		textID, _ = tx.PutObject(model.RootObjID(), "text", ObjText)
+		return tx.SpliceText(textID, 0, 0, "abcdef")
+	})
+	b, err := a.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetActor(2)
+	commitTx(t, a, func(tx *Transaction) error { return tx.SpliceText(textID, 0, 0, "XY") })
+	commitTx(t, b, func(tx *Transaction) error {
+		return tx.Mark(textID, 2, 4, "bold", model.BoolValue(true))
+	})
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Merge(a); err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []*Document{a, b, loaded} {
+		// The mark stays on "cd" wherever A's insert landed.
+		marks := d.Marks(textID)
+		if d.Text(textID) != "XYabcdef" || len(marks) != 1 || marks[0].Start != 4 || marks[0].End != 6 {
+			t.Fatalf("unexpected marks on %q: %#v", d.Text(textID), marks)
+		}
+	}
+}
+
+func TestMarkSurvivesConcurrentDeleteOfItsEdge(t *testing.T) {
+	a := NewDocument()
+	var textID ObjID
+	commitTx(t, a, func(tx *Transaction) error {
+		textID, _ = tx.PutObject(model.RootObjID(), "text", ObjText)
+		return tx.SpliceText(textID, 0, 0, "abcdef")
+	})
+	b, err := a.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetActor(2)
+	commitTx(t, a, func(tx *Transaction) error { return tx.SpliceText(textID, 2, 1, "") })
+	commitTx(t, b, func(tx *Transaction) error {
+		return tx.Mark(textID, 2, 4, "bold", model.BoolValue(true))
+	})
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Merge(a); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []*Document{a, b} {
+		// Deleting "c" leaves the mark on "d" alone.
+		spans, err := d.Spans(textID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(spans) != 3 || spans[1].Text != "d" || !spans[1].Marks["bold"].Equal(model.BoolValue(true)) {
+			t.Fatalf("unexpected spans: %#v", spans)
+		}
+	}
+}
+
+func TestUnmarkSplitsMarkSpans(t *testing.T) {
+	a := NewAutoCommit()
+	textID, _, err := a.PutObject(model.RootObjID(), "text", ObjText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.SpliceText(textID, 0, 0, "hello world"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Mark(textID, 0, 11, "bold", model.BoolValue(true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Mark(textID, 0, 5, "italic", model.BoolValue(true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Unmark(textID, 3, 8, "bold"); err != nil {
+		t.Fatal(err)
+	}
+	doc := a.Document()
+	type span struct {
+		name       string
+		start, end int
+	}
+	var got []span
+	for _, m := range doc.Marks(textID) {
+		got = append(got, span{m.Name, m.Start, m.End})
+	}
+	want := []span{{"bold", 0, 3}, {"italic", 0, 5}, {"bold", 8, 11}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("unexpected spans: got %v want %v", got, want)
+	}
+	if at := doc.MarksAtIndex(textID, 4); len(at) != 1 || at[0].Name != "italic" {
+		t.Fatalf("expected only italic at 4, got %#v", at)
+	}
+	// A later mark wins over the unmark again.
+	if _, err := a.Mark(textID, 4, 6, "bold", model.BoolValue(true)); err != nil {
+		t.Fatal(err)
+	}
+	if at := doc.MarksAtIndex(textID, 5); len(at) != 1 || at[0].Name != "bold" {
+		t.Fatalf("expected bold back at 5, got %#v", at)
+	}
+}
+
+func TestSpansMergeRunsAndResolveConflicts(t *testing.T) {
+	doc := NewDocument()
+	var textID ObjID
+	commitTx(t, doc, func(tx *Transaction) error {
+		textID, _ = tx.PutObject(model.RootObjID(), "text", ObjText)
+		if err := tx.SpliceText(textID, 0, 0, "plain bold both"); err != nil {
+			return err
+		}
+		if err := tx.Mark(textID, 6, 15, "bold", model.BoolValue(true)); err != nil {
+			return err
+		}
+		// Two marks with the same value meet without splitting the run.
+		if err := tx.Mark(textID, 11, 15, "color", model.StringValue("red")); err != nil {
+			return err
+		}
+		return tx.Mark(textID, 13, 15, "color", model.StringValue("red"))
+	})
+	h1 := doc.Heads()
+	commitTx(t, doc, func(tx *Transaction) error {
+		return tx.Mark(textID, 13, 15, "color", model.StringValue("blue"))
+	})
+
+	spans, err := doc.Spans(textID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bold := model.BoolValue(true)
+	want := []Span{
+		{Text: "plain "},
+		{Text: "bold ", Marks: map[string]ScalarValue{"bold": bold}},
+		{Text: "bo", Marks: map[string]ScalarValue{"bold": bold, "color": model.StringValue("red")}},
+		{Text: "th", Marks: map[string]ScalarValue{"bold": bold, "color": model.StringValue("blue")}},
+	}
+	sameSpans := func(got, want []Span) bool {
+		return slices.EqualFunc(got, want, func(a, b Span) bool {
+			return a.Text == b.Text && maps.EqualFunc(a.Marks, b.Marks, ScalarValue.Equal)
+		})
+	}
+	if !sameSpans(spans, want) {
+		t.Fatalf("unexpected spans: %#v", spans)
+	}
+	old, err := doc.Spans(textID, h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = append(want[:2], Span{Text: "both", Marks: map[string]ScalarValue{"bold": bold, "color": model.StringValue("red")}})
+	if !sameSpans(old, want) {
+		t.Fatalf("unexpected spans at h1: %#v", old)
+	}
+	if _, err := doc.Spans(model.RootObjID(), nil); !errors.Is(err, opset.ErrWrongObjectType) {
+		t.Fatalf("expected a wrong type error for the root, got %v", err)
+	}
+}
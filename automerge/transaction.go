@@ -6,8 +6,8 @@ import (
 	"unicode/utf8"
 
 	"github.com/cjanietz/automerge-native-go/internal/changegraph"
-	"github.com/cjanietz/automerge-native-go/internal/model"
 	"github.com/cjanietz/automerge-native-go/internal/opset"
+	inttext "github.com/cjanietz/automerge-native-go/internal/text"
 )
 
 var (
@@ -20,18 +20,21 @@ var (
 type CommitOptions struct {
 	Message *string
 	Time    *int64
+	// PatchLog, if active, receives the patches of each operation as it is
+	// applied.
+	PatchLog *PatchLog
 }
 
 type txCheckpoint struct {
 	actor   uint32
 	seq     uint64
 	startOp uint64
-	deps    []model.ChangeHash
+	deps    []ChangeHash
 }
 
 type txMutation interface {
-	toChangeOp(opid model.OpID) ChangeOperation
-	apply(ops *opset.OpSet, opid model.OpID, actor uint32, seq uint64) error
+	toChangeOp(opid OpID) ChangeOperation
+	apply(ops *opset.OpSet, opid OpID, actor uint32, seq uint64) error
 	opCount() uint64
 }
 
@@ -40,15 +43,27 @@ type Transaction struct {
 
 	closed bool
 	cp     txCheckpoint
-	ops    []txMutation
+	// isolated is the clock of the heads passed to BeginAt, or nil.
+	isolated *changegraph.Clock
+	// start is the op log position before the transaction's first mutation.
+	start  opset.Checkpoint
+	ops    []txStep
+	nextOp uint64
 	openMk []openMark
 }
 
+// txStep is an applied mutation: its change operation and the op log
+// position right after its records.
+type txStep struct {
+	op  ChangeOperation
+	end opset.Checkpoint
+}
+
 type openMark struct {
-	obj   model.ObjID
+	obj   ObjID
 	start int
 	name  string
-	value model.ScalarValue
+	value ScalarValue
 }
 
 func newTransaction(doc *Document) *Transaction {
@@ -57,7 +72,8 @@ func newTransaction(doc *Document) *Transaction {
 	startOp := doc.graph.MaxOp() + 1
 	deps := doc.dependenciesForActorSeq(actor, seq)
 	return &Transaction{
-		doc: doc,
+		doc:   doc,
+		start: doc.ops.Checkpoint(),
 		cp: txCheckpoint{
 			actor:   actor,
 			seq:     seq,
@@ -74,104 +90,216 @@ func (tx *Transaction) ensureOpen() error {
 	return nil
 }
 
-func (tx *Transaction) Put(obj model.ObjID, key string, value model.ScalarValue) error {
+func (tx *Transaction) Put(obj ObjID, key string, value ScalarValue) error {
 	if err := tx.ensureOpen(); err != nil {
 		return err
 	}
-	tx.ops = append(tx.ops, putMutation{obj: obj, key: key, value: value})
-	return nil
+	return tx.applyMutation(putMutation{obj: obj, key: key, value: value})
 }
 
-func (tx *Transaction) PutObject(obj model.ObjID, key string, typ ObjType) (model.ObjID, error) {
+func (tx *Transaction) PutObject(obj ObjID, key string, typ ObjType) (ObjID, error) {
 	if err := tx.ensureOpen(); err != nil {
-		return model.ObjID{}, err
+		return ObjID{}, err
+	}
+	objID := ObjID{Op: tx.nextOpIDForNextMutation()}
+	if err := tx.applyMutation(putObjectMutation{obj: obj, key: key, typ: typ, child: objID}); err != nil {
+		return ObjID{}, err
 	}
-	objID := model.ObjID{Op: tx.nextOpIDForNextMutation()}
-	tx.ops = append(tx.ops, putObjectMutation{obj: obj, key: key, typ: typ, child: objID})
 	return objID, nil
 }
 
-func (tx *Transaction) Insert(obj model.ObjID, index int, value model.ScalarValue) error {
+func (tx *Transaction) Insert(obj ObjID, index int, value ScalarValue) error {
 	if err := tx.ensureOpen(); err != nil {
 		return err
 	}
-	tx.ops = append(tx.ops, insertMutation{obj: obj, index: index, value: value})
-	return nil
+	return tx.applyMutation(insertMutation{obj: obj, index: index, value: value})
 }
 
-func (tx *Transaction) InsertObject(obj model.ObjID, index int, typ ObjType) (model.ObjID, error) {
+func (tx *Transaction) InsertObject(obj ObjID, index int, typ ObjType) (ObjID, error) {
 	if err := tx.ensureOpen(); err != nil {
-		return model.ObjID{}, err
+		return ObjID{}, err
+	}
+	objID := ObjID{Op: tx.nextOpIDForNextMutation()}
+	if err := tx.applyMutation(insertObjectMutation{obj: obj, index: index, typ: typ, child: objID}); err != nil {
+		return ObjID{}, err
 	}
-	objID := model.ObjID{Op: tx.nextOpIDForNextMutation()}
-	tx.ops = append(tx.ops, insertObjectMutation{obj: obj, index: index, typ: typ, child: objID})
 	return objID, nil
 }
 
-func (tx *Transaction) DeleteMap(obj model.ObjID, key string) error {
+func (tx *Transaction) DeleteMap(obj ObjID, key string) error {
 	if err := tx.ensureOpen(); err != nil {
 		return err
 	}
-	tx.ops = append(tx.ops, deleteMapMutation{obj: obj, key: key})
-	return nil
+	return tx.applyMutation(deleteMapMutation{obj: obj, key: key})
 }
 
-func (tx *Transaction) DeleteList(obj model.ObjID, index int) error {
+func (tx *Transaction) DeleteList(obj ObjID, index int) error {
 	if err := tx.ensureOpen(); err != nil {
 		return err
 	}
-	tx.ops = append(tx.ops, deleteListMutation{obj: obj, index: index})
-	return nil
+	return tx.applyMutation(deleteListMutation{obj: obj, index: index})
 }
 
-func (tx *Transaction) Increment(obj model.ObjID, key string, by int64) error {
+func (tx *Transaction) Increment(obj ObjID, key string, by int64) error {
 	if err := tx.ensureOpen(); err != nil {
 		return err
 	}
-	tx.ops = append(tx.ops, incrementMutation{obj: obj, key: key, by: by})
-	return nil
+	return tx.applyMutation(incrementMutation{obj: obj, key: key, by: by})
+}
+
+// SpliceText deletes deleteCount characters at index and inserts insert there,
+// counting in the document's text encoding.
+func (tx *Transaction) SpliceText(obj ObjID, index int, deleteCount int, insert string) error {
+	return tx.SpliceTextWithEncoding(obj, index, deleteCount, insert, tx.doc.textOpts.Encoding)
+}
+
+// SpliceTextWithEncoding is like SpliceText, but counts index and deleteCount
+// in enc units.
+func (tx *Transaction) SpliceTextWithEncoding(obj ObjID, index int, deleteCount int, insert string, enc Encoding) error {
+	if err := tx.ensureOpen(); err != nil {
+		return err
+	}
+	if deleteCount == 0 && insert == "" {
+		return nil
+	}
+	start, end, err := tx.textRange(obj, index, index+deleteCount, enc)
+	if err != nil {
+		return err
+	}
+	return tx.spliceText(obj, start, end-start, insert)
 }
 
-func (tx *Transaction) SpliceText(obj model.ObjID, index int, deleteCount int, insert string) error {
+// spliceText splices at rune indexes, regardless of the text options.
+func (tx *Transaction) spliceText(obj ObjID, index int, deleteCount int, insert string) error {
 	if err := tx.ensureOpen(); err != nil {
 		return err
 	}
 	if deleteCount == 0 && insert == "" {
 		return nil
 	}
-	tx.ops = append(tx.ops, spliceTextMutation{obj: obj, index: index, deleteCount: deleteCount, insert: insert})
+	return tx.applyMutation(spliceTextMutation{obj: obj, index: index, deleteCount: deleteCount, insert: insert})
+}
+
+// textRange converts the range start..end, counted in enc units, to rune
+// indexes of the text object obj. Rune indexes without grapheme mode are
+// returned as they are and left to the opset to check.
+func (tx *Transaction) textRange(obj ObjID, start int, end int, enc Encoding) (int, int, error) {
+	if enc == EncodingUTF8 && !tx.doc.textOpts.Graphemes {
+		return start, end, nil
+	}
+	text := tx.Text(obj)
+	s, err := textRuneIndex(text, start, enc, tx.doc.textOpts.Graphemes)
+	if err != nil {
+		return 0, 0, err
+	}
+	e, err := textRuneIndex(text, end, enc, tx.doc.textOpts.Graphemes)
+	if err != nil {
+		return 0, 0, err
+	}
+	return s, e, nil
+}
+
+// UpdateText replaces the content of the text object obj with newText using
+// the fewest splices, found by a Myers diff over grapheme clusters. Text
+// outside the changed regions keeps its identity, so concurrent edits there
+// survive the merge.
+func (tx *Transaction) UpdateText(obj ObjID, newText string) error {
+	if err := tx.ensureOpen(); err != nil {
+		return err
+	}
+	typ, ok := tx.view().ObjectType(obj)
+	if !ok {
+		return fmt.Errorf("%w: %s", opset.ErrUnknownObject, obj)
+	}
+	if typ != ObjText {
+		return fmt.Errorf("%w: have=%s", opset.ErrWrongObjectType, typ)
+	}
+	start, steps, nextOp := tx.doc.ops.Checkpoint(), len(tx.ops), tx.nextOp
+	for _, sp := range inttext.DiffSplices(tx.Text(obj), newText) {
+		if err := tx.spliceText(obj, sp.Index, sp.Delete, sp.Insert); err != nil {
+			tx.doc.ops.Revert(start)
+			tx.ops, tx.nextOp = tx.ops[:steps], nextOp
+			return err
+		}
+	}
 	return nil
 }
 
-func (tx *Transaction) Mark(obj model.ObjID, start int, end int, name string, value model.ScalarValue) error {
+// Mark sets the mark name to value over start..end, counting in the
+// document's text encoding. The mark stays on those characters as the text
+// changes around them, and expands to text typed right after it.
+func (tx *Transaction) Mark(obj ObjID, start int, end int, name string, value ScalarValue) error {
+	return tx.markRange(obj, start, end, name, value, tx.doc.textOpts.Encoding, ExpandAfter)
+}
+
+// MarkWithEncoding is like Mark, but counts start and end in enc units.
+func (tx *Transaction) MarkWithEncoding(obj ObjID, start int, end int, name string, value ScalarValue, enc Encoding) error {
+	return tx.markRange(obj, start, end, name, value, enc, ExpandAfter)
+}
+
+// MarkWithExpand is like Mark, but expand decides whether text inserted at
+// either edge of the mark joins it.
+func (tx *Transaction) MarkWithExpand(obj ObjID, start int, end int, name string, value ScalarValue, expand ExpandMark) error {
+	return tx.markRange(obj, start, end, name, value, tx.doc.textOpts.Encoding, expand)
+}
+
+// Unmark removes the mark name from start..end by marking the range with null,
+// which overrides the marks made before it.
+func (tx *Transaction) Unmark(obj ObjID, start int, end int, name string) error {
+	return tx.markRange(obj, start, end, name, Null(), tx.doc.textOpts.Encoding, ExpandAfter)
+}
+
+func (tx *Transaction) markRange(obj ObjID, start int, end int, name string, value ScalarValue, enc Encoding, expand ExpandMark) error {
 	if err := tx.ensureOpen(); err != nil {
 		return err
 	}
 	if start < 0 || end < start {
 		return ErrInvalidMarkRange
 	}
-	tx.ops = append(tx.ops, markMutation{obj: obj, start: start, end: end, name: name, value: value})
-	return nil
+	start, end, err := tx.textRange(obj, start, end, enc)
+	if err != nil {
+		return err
+	}
+	return tx.mark(obj, start, end, name, value, expand)
 }
 
-func (tx *Transaction) MarkBegin(obj model.ObjID, index int, name string, value model.ScalarValue) error {
+// mark marks rune indexes, regardless of the text options.
+func (tx *Transaction) mark(obj ObjID, start int, end int, name string, value ScalarValue, expand ExpandMark) error {
+	if err := tx.ensureOpen(); err != nil {
+		return err
+	}
+	if start < 0 || end < start {
+		return ErrInvalidMarkRange
+	}
+	return tx.applyMutation(markMutation{obj: obj, start: start, end: end, name: name, value: value, expand: expand})
+}
+
+func (tx *Transaction) MarkBegin(obj ObjID, index int, name string, value ScalarValue) error {
 	if err := tx.ensureOpen(); err != nil {
 		return err
 	}
 	if index < 0 {
 		return ErrInvalidMarkRange
 	}
+	index, _, err := tx.textRange(obj, index, index, tx.doc.textOpts.Encoding)
+	if err != nil {
+		return err
+	}
 	tx.openMk = append(tx.openMk, openMark{obj: obj, start: index, name: name, value: value})
 	return nil
 }
 
-func (tx *Transaction) MarkEnd(obj model.ObjID, index int, name string) error {
+func (tx *Transaction) MarkEnd(obj ObjID, index int, name string) error {
 	if err := tx.ensureOpen(); err != nil {
 		return err
 	}
 	if index < 0 {
 		return ErrInvalidMarkRange
 	}
+	index, _, err := tx.textRange(obj, index, index, tx.doc.textOpts.Encoding)
+	if err != nil {
+		return err
+	}
 	for i := len(tx.openMk) - 1; i >= 0; i-- {
 		m := tx.openMk[i]
 		if m.obj != obj || m.name != name {
@@ -180,8 +308,10 @@ func (tx *Transaction) MarkEnd(obj model.ObjID, index int, name string) error {
 		if index < m.start {
 			return ErrInvalidMarkRange
 		}
+		if err := tx.applyMutation(markMutation{obj: obj, start: m.start, end: index, name: name, value: m.value}); err != nil {
+			return err
+		}
 		tx.openMk = append(tx.openMk[:i], tx.openMk[i+1:]...)
-		tx.ops = append(tx.ops, markMutation{obj: obj, start: m.start, end: index, name: name, value: m.value})
 		return nil
 	}
 	return ErrMarkNotOpen
@@ -191,6 +321,12 @@ func (tx *Transaction) Commit() (*Change, error) {
 	return tx.CommitWith(CommitOptions{})
 }
 
+// CommitWith records the transaction's mutations as one change. If the change
+// cannot be recorded, the mutations are reverted and the transaction is
+// closed, so the document never holds ops without a change. Changes received
+// while the transaction was open are applied afterwards; the error only
+// reports on the commit itself, and Document.FlushHeld reports failures
+// applying them.
 func (tx *Transaction) CommitWith(opts CommitOptions) (*Change, error) {
 	if err := tx.ensureOpen(); err != nil {
 		return nil, err
@@ -201,26 +337,29 @@ func (tx *Transaction) CommitWith(opts CommitOptions) (*Change, error) {
 	if len(tx.ops) == 0 {
 		tx.closed = true
 		tx.doc.transactionClosed(tx)
+		tx.doc.applyHeld()
 		return nil, nil
 	}
 
+	beforeHeads := tx.doc.Heads()
+	log, logStart := tx.doc.observeLog(opts.PatchLog)
 	changeOps := make([]ChangeOperation, 0, len(tx.ops))
-	offset := uint64(0)
-	for _, m := range tx.ops {
-		opid := model.OpID{Counter: tx.cp.startOp + offset, Actor: tx.cp.actor}
-		seq := opid.Counter
-		if err := m.apply(tx.doc.ops, opid, tx.cp.actor, seq); err != nil {
-			return nil, err
-		}
-		changeOps = append(changeOps, m.toChangeOp(opid))
-		offset += m.opCount()
+	for _, step := range tx.ops {
+		changeOps = append(changeOps, step.op)
 	}
+	offset := tx.nextOp
 	if offset == 0 {
 		tx.closed = true
 		tx.doc.transactionClosed(tx)
+		tx.doc.applyHeld()
 		return nil, nil
 	}
 
+	patches, err := tx.pendingPatches(log)
+	if err != nil {
+		return nil, tx.abort(err)
+	}
+
 	maxOp := tx.cp.startOp + offset - 1
 	hash := computeChangeHash(tx.cp.actor, tx.cp.seq, tx.cp.startOp, maxOp, tx.cp.deps, opts, changeOps)
 
@@ -231,7 +370,10 @@ func (tx *Transaction) CommitWith(opts CommitOptions) (*Change, error) {
 		Seq:   tx.cp.seq,
 		MaxOp: maxOp,
 	}); err != nil {
-		return nil, fmt.Errorf("add change to graph: %w", err)
+		return nil, tx.abort(fmt.Errorf("add change to graph: %w", err))
+	}
+	for _, p := range patches {
+		log.Add(p)
 	}
 
 	change := &Change{
@@ -240,69 +382,221 @@ func (tx *Transaction) CommitWith(opts CommitOptions) (*Change, error) {
 		Seq:        tx.cp.seq,
 		StartOp:    tx.cp.startOp,
 		MaxOp:      maxOp,
-		Deps:       append([]model.ChangeHash(nil), tx.cp.deps...),
+		Deps:       append([]ChangeHash(nil), tx.cp.deps...),
 		Message:    opts.Message,
 		Time:       opts.Time,
 		Operations: changeOps,
 	}
 	dcop := *change
-	dcop.Deps = append([]model.ChangeHash(nil), change.Deps...)
+	dcop.Deps = append([]ChangeHash(nil), change.Deps...)
 	dcop.Operations = append([]ChangeOperation(nil), change.Operations...)
 	tx.doc.changes[change.Hash] = dcop
 	tx.doc.clearLegacyRaw()
 	tx.doc.last = change
+	log.setHeads(tx.doc.Heads())
 
 	tx.closed = true
 	tx.doc.transactionClosed(tx)
+	tx.doc.notify(EventLocalChange, []ChangeHash{change.Hash}, beforeHeads, log.since(logStart))
+	tx.doc.applyHeld()
 	return change, nil
 }
 
+// abort reverts and closes a transaction whose commit failed, so a commit
+// either records every mutation or leaves the document untouched.
+func (tx *Transaction) abort(err error) error {
+	_ = tx.Rollback()
+	return err
+}
+
+// Rollback discards the transaction and reverts the document to the state
+// before its first mutation. Like CommitWith, it then applies the changes
+// received meanwhile and leaves their failures to Document.FlushHeld.
 func (tx *Transaction) Rollback() error {
 	if err := tx.ensureOpen(); err != nil {
 		return err
 	}
+	tx.doc.ops.Revert(tx.start)
 	tx.ops = nil
 	tx.openMk = nil
 	tx.closed = true
 	tx.doc.transactionClosed(tx)
+	tx.doc.applyHeld()
 	return nil
 }
 
-func (tx *Transaction) nextOpIDForNextMutation() model.OpID {
-	return model.OpID{Counter: tx.cp.startOp + tx.totalMutationOpCount(), Actor: tx.cp.actor}
+// Get reads prop of obj as the transaction sees it, including its pending
+// mutations.
+func (tx *Transaction) Get(obj ObjID, prop Prop) (Value, bool) {
+	if prop.Seq {
+		return tx.view().GetList(obj, prop.Index)
+	}
+	return tx.view().GetMap(obj, prop.Key)
+}
+
+func (tx *Transaction) Text(obj ObjID) string {
+	return tx.view().Text(obj)
+}
+
+func (tx *Transaction) Length(obj ObjID) int {
+	return objectLength(tx.view(), obj, tx.doc.textOpts.Encoding)
+}
+
+func (tx *Transaction) Keys(obj ObjID) []string {
+	return tx.view().KeysMap(obj)
+}
+
+// view is the state mutations are resolved against: the current document, or
+// for an isolated transaction the document at its heads plus its own ops.
+func (tx *Transaction) view() *opset.View {
+	if tx.isolated != nil {
+		return tx.doc.ops.ViewAtWithOwn(tx.isolated, tx.cp.actor, tx.cp.startOp)
+	}
+	return tx.doc.ops.CurrentView()
 }
 
-func (tx *Transaction) totalMutationOpCount() uint64 {
-	var total uint64
-	for _, m := range tx.ops {
-		total += m.opCount()
+// applyMutation applies m to the document right away, so reads see it before
+// the transaction commits. A mutation that fails is reverted and leaves the
+// transaction as it was.
+func (tx *Transaction) applyMutation(m txMutation) error {
+	m, err := identifyMutation(m, tx.view())
+	if err != nil {
+		return err
+	}
+	opid := tx.nextOpIDForNextMutation()
+	before := tx.doc.ops.Checkpoint()
+	if err := m.apply(tx.doc.ops, opid, tx.cp.actor, opid.Counter); err != nil {
+		tx.doc.ops.Revert(before)
+		return err
 	}
-	return total
+	tx.ops = append(tx.ops, txStep{op: m.toChangeOp(opid), end: tx.doc.ops.Checkpoint()})
+	tx.nextOp += m.opCount()
+	return nil
+}
+
+// pendingPatches replays the transaction's records and returns the patches of
+// each mutation in order. It returns nil unless log is active.
+func (tx *Transaction) pendingPatches(log *PatchLog) ([]Patch, error) {
+	if !log.IsActive() {
+		return nil, nil
+	}
+	buf := ActivePatchLog()
+	replay := tx.doc.ops.ReplaySince(tx.start)
+	for _, step := range tx.ops {
+		before := replay.SnapshotObject(step.op.ObjID)
+		replay.AdvanceTo(step.end)
+		if err := logObjectPatches(buf, tx.doc.ops, before, replay.View(), step.op.ObjID, tx.doc.patchOptions(log)); err != nil {
+			return nil, err
+		}
+	}
+	return buf.patches, nil
+}
+
+func (tx *Transaction) nextOpIDForNextMutation() OpID {
+	return OpID{Counter: tx.cp.startOp + tx.nextOp, Actor: tx.cp.actor}
+}
+
+// identifyMutation names the elements and versions m acts on as they are in
+// view, the state the transaction sees, and records them in m. Applied by
+// identity, m then acts on the same ones in the document, and on every peer
+// that applies the change, whatever has happened to them since.
+func identifyMutation(m txMutation, view *opset.View) (txMutation, error) {
+	switch m := m.(type) {
+	case putMutation:
+		m.pred = versionIDs(view, m.obj, m.key)
+		return m, nil
+	case putObjectMutation:
+		m.pred = versionIDs(view, m.obj, m.key)
+		return m, nil
+	case deleteMapMutation:
+		m.pred = versionIDs(view, m.obj, m.key)
+		return m, nil
+	case insertMutation:
+		if err := checkListRange(view, m.obj, m.index, m.index); err != nil {
+			return nil, err
+		}
+		m.after = view.InsertRef(m.obj, m.index, 0)
+		return m, nil
+	case insertObjectMutation:
+		if err := checkListRange(view, m.obj, m.index, m.index); err != nil {
+			return nil, err
+		}
+		m.after = view.InsertRef(m.obj, m.index, 0)
+		return m, nil
+	case deleteListMutation:
+		if err := checkListRange(view, m.obj, m.index, m.index+1); err != nil {
+			return nil, err
+		}
+		if elems := view.ListElemIDs(m.obj, m.index, m.index+1); len(elems) == 1 {
+			m.elem = elems[0]
+		}
+		return m, nil
+	case spliceTextMutation:
+		if err := checkListRange(view, m.obj, m.index, m.index+m.deleteCount); err != nil {
+			return nil, err
+		}
+		m.deleted = view.ListElemIDs(m.obj, m.index, m.index+m.deleteCount)
+		m.after = view.InsertRef(m.obj, m.index, m.deleteCount)
+		return m, nil
+	case markMutation:
+		if err := checkListRange(view, m.obj, m.start, m.end); err != nil {
+			return nil, err
+		}
+		m.startAnchor, m.endAnchor = view.MarkAnchors(m.obj, m.start, m.end, m.expand)
+		return m, nil
+	default:
+		return m, nil
+	}
+}
+
+func versionIDs(view *opset.View, obj ObjID, key string) []OpID {
+	versions := view.GetAllMapVersions(obj, key)
+	ids := make([]OpID, 0, len(versions))
+	for _, v := range versions {
+		ids = append(ids, v.OpID)
+	}
+	return ids
+}
+
+// checkListRange fails unless start..end lies within the sequence obj in
+// view. The opset accepts such indexes from remote ops, which may count
+// elements deleted concurrently, so local mutations check them here. Objects
+// that are missing or not sequences are left to the opset to report.
+func checkListRange(view *opset.View, obj ObjID, start, end int) error {
+	if typ, ok := view.ObjectType(obj); !ok || typ == ObjMap {
+		return nil
+	}
+	if n := view.ListLength(obj); start < 0 || end < start || end > n {
+		return fmt.Errorf("%w: %d..%d length=%d", opset.ErrInvalidIndex, start, end, n)
+	}
+	return nil
 }
 
 type putMutation struct {
-	obj   model.ObjID
+	obj   ObjID
 	key   string
-	value model.ScalarValue
+	value ScalarValue
+	pred  []OpID
 }
 
-func (m putMutation) toChangeOp(opid model.OpID) ChangeOperation {
-	return ChangeOperation{Kind: OpPut, ObjID: m.obj, Key: m.key, Value: m.value, OpID: opid}
+func (m putMutation) toChangeOp(opid OpID) ChangeOperation {
+	return ChangeOperation{Kind: OpPut, ObjID: m.obj, Key: m.key, Value: m.value, OpID: opid, Identified: true, Pred: m.pred}
 }
 
-func (m putMutation) apply(ops *opset.OpSet, opid model.OpID, actor uint32, seq uint64) error {
-	return ops.PutMap(m.obj, m.key, opset.NewScalarValue(m.value), opid, actor, seq)
+func (m putMutation) apply(ops *opset.OpSet, opid OpID, actor uint32, seq uint64) error {
+	return ops.PutMapRaw(m.obj, m.key, opset.NewScalarValue(m.value), opid, actor, seq, m.pred)
 }
 func (m putMutation) opCount() uint64 { return 1 }
 
 type putObjectMutation struct {
-	obj   model.ObjID
+	obj   ObjID
 	key   string
 	typ   ObjType
-	child model.ObjID
+	child ObjID
+	pred  []OpID
 }
 
-func (m putObjectMutation) toChangeOp(opid model.OpID) ChangeOperation {
+func (m putObjectMutation) toChangeOp(opid OpID) ChangeOperation {
 	return ChangeOperation{
 		Kind:       OpPutObject,
 		ObjID:      m.obj,
@@ -310,38 +604,43 @@ func (m putObjectMutation) toChangeOp(opid model.OpID) ChangeOperation {
 		Key:        m.key,
 		ObjType:    m.typ,
 		OpID:       opid,
+		Identified: true,
+		Pred:       m.pred,
 	}
 }
 
-func (m putObjectMutation) apply(ops *opset.OpSet, opid model.OpID, actor uint32, seq uint64) error {
+func (m putObjectMutation) apply(ops *opset.OpSet, opid OpID, actor uint32, seq uint64) error {
 	ops.CreateObject(m.child, opset.ObjType(m.typ))
-	return ops.PutMap(m.obj, m.key, opset.NewObjectValue(m.child, opset.ObjType(m.typ)), opid, actor, seq)
+	return ops.PutMapRaw(m.obj, m.key, opset.NewObjectValue(m.child, opset.ObjType(m.typ)), opid, actor, seq, m.pred)
 }
 func (m putObjectMutation) opCount() uint64 { return 1 }
 
 type insertMutation struct {
-	obj   model.ObjID
+	obj   ObjID
 	index int
-	value model.ScalarValue
+	value ScalarValue
+	after OpID
 }
 
-func (m insertMutation) toChangeOp(opid model.OpID) ChangeOperation {
-	return ChangeOperation{Kind: OpInsert, ObjID: m.obj, Index: m.index, Value: m.value, OpID: opid}
+func (m insertMutation) toChangeOp(opid OpID) ChangeOperation {
+	return ChangeOperation{Kind: OpInsert, ObjID: m.obj, Index: m.index, Value: m.value, OpID: opid, Identified: true, Elem: m.after}
 }
 
-func (m insertMutation) apply(ops *opset.OpSet, opid model.OpID, actor uint32, seq uint64) error {
-	return ops.InsertList(m.obj, m.index, opset.NewScalarValue(m.value), opid, actor, seq)
+func (m insertMutation) apply(ops *opset.OpSet, opid OpID, actor uint32, seq uint64) error {
+	_, err := ops.InsertListAfter(m.obj, m.after, opset.NewScalarValue(m.value), opid, actor, seq)
+	return err
 }
 func (m insertMutation) opCount() uint64 { return 1 }
 
 type insertObjectMutation struct {
-	obj   model.ObjID
+	obj   ObjID
 	index int
 	typ   ObjType
-	child model.ObjID
+	child ObjID
+	after OpID
 }
 
-func (m insertObjectMutation) toChangeOp(opid model.OpID) ChangeOperation {
+func (m insertObjectMutation) toChangeOp(opid OpID) ChangeOperation {
 	return ChangeOperation{
 		Kind:       OpInsertObject,
 		ObjID:      m.obj,
@@ -349,66 +648,74 @@ func (m insertObjectMutation) toChangeOp(opid model.OpID) ChangeOperation {
 		Index:      m.index,
 		ObjType:    m.typ,
 		OpID:       opid,
+		Identified: true,
+		Elem:       m.after,
 	}
 }
 
-func (m insertObjectMutation) apply(ops *opset.OpSet, opid model.OpID, actor uint32, seq uint64) error {
+func (m insertObjectMutation) apply(ops *opset.OpSet, opid OpID, actor uint32, seq uint64) error {
 	ops.CreateObject(m.child, opset.ObjType(m.typ))
-	return ops.InsertList(m.obj, m.index, opset.NewObjectValue(m.child, opset.ObjType(m.typ)), opid, actor, seq)
+	_, err := ops.InsertListAfter(m.obj, m.after, opset.NewObjectValue(m.child, opset.ObjType(m.typ)), opid, actor, seq)
+	return err
 }
 func (m insertObjectMutation) opCount() uint64 { return 1 }
 
 type deleteMapMutation struct {
-	obj model.ObjID
-	key string
+	obj  ObjID
+	key  string
+	pred []OpID
 }
 
-func (m deleteMapMutation) toChangeOp(opid model.OpID) ChangeOperation {
-	return ChangeOperation{Kind: OpDeleteMap, ObjID: m.obj, Key: m.key, OpID: opid}
+func (m deleteMapMutation) toChangeOp(opid OpID) ChangeOperation {
+	return ChangeOperation{Kind: OpDeleteMap, ObjID: m.obj, Key: m.key, OpID: opid, Identified: true, Pred: m.pred}
 }
 
-func (m deleteMapMutation) apply(ops *opset.OpSet, opid model.OpID, actor uint32, seq uint64) error {
-	return ops.DeleteMap(m.obj, m.key, opid, actor, seq)
+func (m deleteMapMutation) apply(ops *opset.OpSet, opid OpID, actor uint32, seq uint64) error {
+	return ops.DeleteMapRaw(m.obj, m.key, opid, actor, seq, m.pred)
 }
 func (m deleteMapMutation) opCount() uint64 { return 1 }
 
 type deleteListMutation struct {
-	obj   model.ObjID
+	obj   ObjID
 	index int
+	elem  OpID
 }
 
-func (m deleteListMutation) toChangeOp(opid model.OpID) ChangeOperation {
-	return ChangeOperation{Kind: OpDeleteList, ObjID: m.obj, Index: m.index, OpID: opid}
+func (m deleteListMutation) toChangeOp(opid OpID) ChangeOperation {
+	return ChangeOperation{Kind: OpDeleteList, ObjID: m.obj, Index: m.index, OpID: opid, Identified: true, Elem: m.elem}
 }
 
-func (m deleteListMutation) apply(ops *opset.OpSet, opid model.OpID, actor uint32, seq uint64) error {
-	return ops.DeleteList(m.obj, m.index, opid, actor, seq)
+func (m deleteListMutation) apply(ops *opset.OpSet, opid OpID, actor uint32, seq uint64) error {
+	_, err := ops.DeleteListElem(m.obj, m.elem, opid, actor, seq)
+	return err
 }
 func (m deleteListMutation) opCount() uint64 { return 1 }
 
 type incrementMutation struct {
-	obj model.ObjID
+	obj ObjID
 	key string
 	by  int64
 }
 
-func (m incrementMutation) toChangeOp(opid model.OpID) ChangeOperation {
+func (m incrementMutation) toChangeOp(opid OpID) ChangeOperation {
 	return ChangeOperation{Kind: OpIncrement, ObjID: m.obj, Key: m.key, By: m.by, OpID: opid}
 }
 
-func (m incrementMutation) apply(ops *opset.OpSet, opid model.OpID, actor uint32, seq uint64) error {
+func (m incrementMutation) apply(ops *opset.OpSet, opid OpID, actor uint32, seq uint64) error {
 	return ops.IncrementMapCounter(m.obj, m.key, m.by, opid, actor, seq)
 }
 func (m incrementMutation) opCount() uint64 { return 1 }
 
 type spliceTextMutation struct {
-	obj         model.ObjID
+	obj         ObjID
 	index       int
 	deleteCount int
 	insert      string
+	deleted     []OpID
+	after       OpID
 }
 
-func (m spliceTextMutation) toChangeOp(opid model.OpID) ChangeOperation {
+func (m spliceTextMutation) toChangeOp(opid OpID) ChangeOperation {
 	return ChangeOperation{
 		Kind:        OpSpliceText,
 		ObjID:       m.obj,
@@ -416,15 +723,14 @@ func (m spliceTextMutation) toChangeOp(opid model.OpID) ChangeOperation {
 		DeleteCount: m.deleteCount,
 		InsertText:  m.insert,
 		OpID:        opid,
+		Identified:  true,
+		Elem:        m.after,
+		Deleted:     m.deleted,
 	}
 }
 
-func (m spliceTextMutation) apply(ops *opset.OpSet, opid model.OpID, actor uint32, seq uint64) error {
-	start := seq
-	if start > 0 {
-		start--
-	}
-	_, err := ops.SpliceText(m.obj, m.index, m.deleteCount, m.insert, actor, start)
+func (m spliceTextMutation) apply(ops *opset.OpSet, opid OpID, actor uint32, seq uint64) error {
+	_, err := ops.SpliceTextAfter(m.obj, m.deleted, m.after, m.insert, actor, seq-1)
 	return err
 }
 func (m spliceTextMutation) opCount() uint64 {
@@ -432,27 +738,36 @@ func (m spliceTextMutation) opCount() uint64 {
 }
 
 type markMutation struct {
-	obj   model.ObjID
-	start int
-	end   int
-	name  string
-	value model.ScalarValue
-}
-
-func (m markMutation) toChangeOp(opid model.OpID) ChangeOperation {
+	obj    ObjID
+	start  int
+	end    int
+	name   string
+	value  ScalarValue
+	expand ExpandMark
+	// startAnchor and endAnchor place the mark; identifyMutation fills them in.
+	startAnchor MarkAnchor
+	endAnchor   MarkAnchor
+}
+
+func (m markMutation) toChangeOp(opid OpID) ChangeOperation {
 	return ChangeOperation{
 		Kind:     OpMark,
 		ObjID:    m.obj,
 		Start:    m.start,
 		End:      m.end,
 		MarkName: m.name,
+		Expand:   m.expand,
 		Value:    m.value,
 		OpID:     opid,
+
+		Identified:  true,
+		StartAnchor: m.startAnchor,
+		EndAnchor:   m.endAnchor,
 	}
 }
 
-func (m markMutation) apply(ops *opset.OpSet, opid model.OpID, actor uint32, seq uint64) error {
-	return ops.AddMark(m.obj, m.start, m.end, m.name, m.value, opid, actor, seq)
+func (m markMutation) apply(ops *opset.OpSet, opid OpID, actor uint32, seq uint64) error {
+	return ops.AddMarkAnchored(m.obj, m.startAnchor, m.endAnchor, m.name, m.value, m.expand, opid, actor, seq)
 }
 
 func (m markMutation) opCount() uint64 { return 1 }
@@ -1,9 +1,12 @@
 package automerge
 
 import (
+	"errors"
 	"testing"
 
+	"github.com/cjanietz/automerge-native-go/internal/changegraph"
 	"github.com/cjanietz/automerge-native-go/internal/model"
+	"github.com/cjanietz/automerge-native-go/internal/opset"
 )
 
 func TestTransactionCommitAndMetadata(t *testing.T) {
@@ -39,7 +42,7 @@ func TestTransactionCommitAndMetadata(t *testing.T) {
 	if len(change.Operations) != 1 || change.Operations[0].Kind != OpPut {
 		t.Fatalf("unexpected operations: %#v", change.Operations)
 	}
-	v, ok := doc.GetMap(model.RootObjID(), "name", nil)
+	v, ok := doc.GetMap(model.RootObjID(), "name")
 	if !ok || v.Scalar.String != "alice" {
 		t.Fatalf("unexpected document value after commit: %#v ok=%v", v, ok)
 	}
@@ -57,7 +60,7 @@ func TestTransactionRollback(t *testing.T) {
 	if err := tx.Rollback(); err != nil {
 		t.Fatal(err)
 	}
-	if _, ok := doc.GetMap(model.RootObjID(), "name", nil); ok {
+	if _, ok := doc.GetMap(model.RootObjID(), "name"); ok {
 		t.Fatal("value should not exist after rollback")
 	}
 	if len(doc.Heads()) != 0 {
@@ -65,6 +68,84 @@ func TestTransactionRollback(t *testing.T) {
 	}
 }
 
+func TestTransactionReadsPendingMutations(t *testing.T) {
+	doc := NewDocument()
+	tx, _ := doc.Begin()
+	_ = tx.Put(model.RootObjID(), "name", model.StringValue("alice"))
+	text, _ := tx.PutObject(model.RootObjID(), "text", ObjText)
+	_ = tx.SpliceText(text, 0, 0, "hello")
+	list, _ := tx.PutObject(model.RootObjID(), "list", ObjList)
+	_ = tx.Insert(list, 0, model.IntValue(7))
+
+	if v, ok := tx.Get(model.RootObjID(), MapProp("name")); !ok || v.Scalar.String != "alice" {
+		t.Fatalf("unexpected pending value: %#v ok=%v", v, ok)
+	}
+	if v, ok := tx.Get(list, SeqProp(0)); !ok || v.Scalar.Int != 7 {
+		t.Fatalf("unexpected pending list value: %#v ok=%v", v, ok)
+	}
+	// Later mutations resolve indexes against the pending text.
+	_ = tx.SpliceText(text, tx.Length(text), 0, "!")
+	if got := tx.Text(text); got != "hello!" {
+		t.Fatalf("unexpected pending text: %q", got)
+	}
+	if keys := tx.Keys(model.RootObjID()); len(keys) != 3 {
+		t.Fatalf("unexpected pending keys: %v", keys)
+	}
+	if err := doc.ApplyChanges(nil); err != nil {
+		t.Fatalf("expected changes to be held while open, got %v", err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.Text(text); got != "hello!" {
+		t.Fatalf("unexpected committed text: %q", got)
+	}
+}
+
+func TestTransactionRollbackRevertsAppliedMutations(t *testing.T) {
+	doc := NewDocument()
+	tx, _ := doc.Begin()
+	text, _ := tx.PutObject(model.RootObjID(), "text", ObjText)
+	_ = tx.SpliceText(text, 0, 0, "abc")
+	_, _ = tx.Commit()
+	heads := doc.Heads()
+
+	tx, _ = doc.Begin()
+	_ = tx.SpliceText(text, 1, 1, "XY")
+	_ = tx.Mark(text, 0, 2, "bold", model.BoolValue(true))
+	child, _ := tx.PutObject(model.RootObjID(), "child", ObjMap)
+	_ = tx.Put(child, "k", model.IntValue(1))
+	if got := doc.Text(text); got != "aXYc" {
+		t.Fatalf("expected the pending splice to be visible, got %q", got)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.Text(text); got != "abc" {
+		t.Fatalf("unexpected text after rollback: %q", got)
+	}
+	if len(doc.Marks(text)) != 0 {
+		t.Fatalf("unexpected marks after rollback: %#v", doc.Marks(text))
+	}
+	if _, ok := doc.GetMap(model.RootObjID(), "child"); ok {
+		t.Fatal("child object should not exist after rollback")
+	}
+	if !hashesEqual(doc.Heads(), heads) {
+		t.Fatal("rollback should not change heads")
+	}
+
+	// The next transaction reuses the rolled back op counters.
+	tx, _ = doc.Begin()
+	_ = tx.SpliceText(text, 3, 0, "d")
+	change, err := tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.StartOp != 5 || doc.Text(text) != "abcd" {
+		t.Fatalf("unexpected change after rollback: start=%d text=%q", change.StartOp, doc.Text(text))
+	}
+}
+
 func TestTransactionObjectAndListMutations(t *testing.T) {
 	doc := NewDocument()
 	tx, _ := doc.Begin()
@@ -85,7 +166,7 @@ func TestTransactionObjectAndListMutations(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	vals := doc.ListRange(listID, 0, -1, nil)
+	vals := doc.ListRange(listID, 0, -1)
 	if len(vals) != 1 || vals[0].Scalar.String != "b" {
 		t.Fatalf("unexpected list state: %#v", vals)
 	}
@@ -121,11 +202,11 @@ func TestTransactionIncrementAndSplice(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	count, ok := doc.GetMap(model.RootObjID(), "count", nil)
+	count, ok := doc.GetMap(model.RootObjID(), "count")
 	if !ok || count.Scalar.Counter != 15 {
 		t.Fatalf("unexpected count: %#v", count)
 	}
-	if got := doc.Text(textID, nil); got != "halo" {
+	if got := doc.Text(textID); got != "halo" {
 		t.Fatalf("unexpected text after splice: %q", got)
 	}
 }
@@ -151,3 +232,222 @@ func TestTransactionDepsAndSeqAdvance(t *testing.T) {
 		t.Fatal("expected deps on second change")
 	}
 }
+
+var errInjected = errors.New("injected failure")
+
+// failingMutation applies inner and then fails, leaving inner's records in
+// the op set for the transaction to undo.
+type failingMutation struct {
+	inner txMutation
+}
+
+func (m failingMutation) toChangeOp(opid OpID) ChangeOperation { return m.inner.toChangeOp(opid) }
+func (m failingMutation) opCount() uint64                      { return m.inner.opCount() }
+
+func (m failingMutation) apply(ops *opset.OpSet, opid OpID, actor uint32, seq uint64) error {
+	if err := m.inner.apply(ops, opid, actor, seq); err != nil {
+		return err
+	}
+	return errInjected
+}
+
+func TestTransactionMutationFailureIsNotApplied(t *testing.T) {
+	doc := NewDocument()
+	tx, _ := doc.Begin()
+	_ = tx.Put(model.RootObjID(), "a", model.IntValue(1))
+	text, _ := tx.PutObject(model.RootObjID(), "text", ObjText)
+	_ = tx.SpliceText(text, 0, 0, "abc")
+
+	// Deleting past the end fails after the first deletes were recorded.
+	if err := tx.SpliceText(text, 1, 5, "x"); !errors.Is(err, opset.ErrInvalidIndex) {
+		t.Fatalf("expected ErrInvalidIndex, got %v", err)
+	}
+	if err := tx.applyMutation(failingMutation{inner: spliceTextMutation{obj: text, index: 0, deleteCount: 1, insert: "Z"}}); !errors.Is(err, errInjected) {
+		t.Fatalf("expected the injected failure, got %v", err)
+	}
+	if err := tx.SpliceText(model.RootObjID(), 0, 0, "x"); !errors.Is(err, opset.ErrWrongObjectType) {
+		t.Fatalf("expected ErrWrongObjectType, got %v", err)
+	}
+	if got := tx.Text(text); got != "abc" {
+		t.Fatalf("failed mutations left partial text: %q", got)
+	}
+	_ = tx.Put(model.RootObjID(), "b", model.IntValue(2))
+	change, err := tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(change.Operations) != 4 || change.MaxOp != change.StartOp+5 {
+		t.Fatalf("unexpected change: ops=%d start=%d max=%d", len(change.Operations), change.StartOp, change.MaxOp)
+	}
+
+	// The op set and the recorded change agree.
+	data, err := doc.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Text(text) != "abc" || loaded.Length(model.RootObjID()) != 3 {
+		t.Fatalf("reloaded document differs: text=%q keys=%d", loaded.Text(text), loaded.Length(model.RootObjID()))
+	}
+}
+
+func TestTransactionCommitFailureRevertsEverything(t *testing.T) {
+	doc := NewDocument()
+	tx, _ := doc.Begin()
+	_ = tx.Put(model.RootObjID(), "a", model.IntValue(1))
+	_, _ = tx.Commit()
+	heads := doc.Heads()
+
+	tx, _ = doc.Begin()
+	_ = tx.Put(model.RootObjID(), "a", model.IntValue(2))
+	list, _ := tx.PutObject(model.RootObjID(), "list", ObjList)
+	_ = tx.Insert(list, 0, model.IntValue(3))
+	// Make the change graph reject the change.
+	tx.cp.seq = 5
+	if _, err := tx.Commit(); !errors.Is(err, changegraph.ErrInvalidActorSeq) {
+		t.Fatalf("expected ErrInvalidActorSeq, got %v", err)
+	}
+	if err := tx.Put(model.RootObjID(), "a", model.IntValue(4)); !errors.Is(err, ErrTransactionClosed) {
+		t.Fatalf("expected the failed transaction to be closed, got %v", err)
+	}
+	if v, _ := doc.GetMap(model.RootObjID(), "a"); v.Scalar.Int != 1 {
+		t.Fatalf("unexpected value after failed commit: %#v", v)
+	}
+	if _, ok := doc.GetMap(model.RootObjID(), "list"); ok {
+		t.Fatal("list should not exist after failed commit")
+	}
+	if !hashesEqual(doc.Heads(), heads) {
+		t.Fatal("failed commit should not change heads")
+	}
+
+	tx, _ = doc.Begin()
+	_ = tx.Put(model.RootObjID(), "a", model.IntValue(5))
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := doc.GetMap(model.RootObjID(), "a"); v.Scalar.Int != 5 {
+		t.Fatalf("unexpected value after retry: %#v", v)
+	}
+}
+
+func TestTransactionUpdateTextKeepsUntouchedElements(t *testing.T) {
+	doc := NewDocument()
+	tx, _ := doc.Begin()
+	text, _ := tx.PutObject(model.RootObjID(), "text", ObjText)
+	_ = tx.SpliceText(text, 0, 0, "hello world")
+	_, _ = tx.Commit()
+	before := doc.ops.CurrentView().ListElements(text)
+
+	tx, _ = doc.Begin()
+	if err := tx.UpdateText(text, "hello brave world!"); err != nil {
+		t.Fatal(err)
+	}
+	change, err := tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(change.Operations) != 2 {
+		t.Fatalf("expected two splices, got %#v", change.Operations)
+	}
+	if got := doc.Text(text); got != "hello brave world!" {
+		t.Fatalf("unexpected text: %q", got)
+	}
+	after := doc.ops.CurrentView().ListElements(text)
+	for i, e := range before {
+		j := i
+		if i >= 6 {
+			j += len("brave ")
+		}
+		if after[j].ID != e.ID {
+			t.Fatalf("element %d lost its identity", i)
+		}
+	}
+
+	tx, _ = doc.Begin()
+	if err := tx.UpdateText(model.RootObjID(), "x"); !errors.Is(err, opset.ErrWrongObjectType) {
+		t.Fatalf("expected ErrWrongObjectType, got %v", err)
+	}
+	if err := tx.UpdateText(text, "hello brave world!"); err != nil {
+		t.Fatal(err)
+	}
+	if change, _ := tx.Commit(); change != nil {
+		t.Fatalf("expected no change for identical text, got %#v", change)
+	}
+}
+
+func TestChangesReceivedDuringTransactionApplyWhenItCloses(t *testing.T) {
+	peer := NewDocument()
+	_ = peer.SetActor(2)
+	commitTx(t, peer, func(tx *Transaction) error { return tx.Put(RootObjID(), "peer", IntValue(1)) })
+
+	d := NewDocument()
+	tx, _ := d.Begin()
+	_ = tx.Put(RootObjID(), "local", IntValue(2))
+	if err := d.Merge(peer); err != nil {
+		t.Fatalf("merge during a transaction: %v", err)
+	}
+	if _, ok := tx.Get(RootObjID(), MapProp("peer")); ok {
+		t.Fatal("the transaction should not see held changes")
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := d.KeysMapAt(RootObjID(), d.Heads()); len(keys) != 2 || len(d.Heads()) != 2 {
+		t.Fatalf("expected both changes after commit, got %v heads=%d", keys, len(d.Heads()))
+	}
+
+	// Sync messages received while a transaction is open apply on rollback.
+	commitTx(t, peer, func(tx *Transaction) error { return tx.Put(RootObjID(), "later", IntValue(3)) })
+	msg, err := peer.Sync().GenerateSyncMessage(NewSyncState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, _ = d.Begin()
+	if err := d.Sync().ReceiveSyncMessage(NewSyncState(), *msg); err != nil {
+		t.Fatalf("sync during a transaction: %v", err)
+	}
+	if _, ok := d.GetMap(RootObjID(), "later"); ok {
+		t.Fatal("held change applied before the transaction closed")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.GetMap(RootObjID(), "later"); !ok {
+		t.Fatal("held change missing after rollback")
+	}
+}
+
+func TestHeldChangeFailuresSurfaceThroughFlushHeld(t *testing.T) {
+	first, second := NewDocument(), NewDocument()
+	_ = first.SetActor(2)
+	_ = second.SetActor(2)
+	commitTx(t, first, func(tx *Transaction) error { return tx.Put(RootObjID(), "k", IntValue(1)) })
+	commitTx(t, second, func(tx *Transaction) error { return tx.Put(RootObjID(), "k", IntValue(2)) })
+
+	d := NewDocument()
+	if err := d.Merge(first); err != nil {
+		t.Fatal(err)
+	}
+	tx, _ := d.Begin()
+	_ = tx.Put(RootObjID(), "local", IntValue(3))
+	// The second document reuses actor 2's first sequence number.
+	if err := d.Merge(second); err != nil {
+		t.Fatalf("merge during a transaction: %v", err)
+	}
+	if err := d.FlushHeld(); !errors.Is(err, ErrTransactionOpen) {
+		t.Fatalf("expected ErrTransactionOpen while the change is held, got %v", err)
+	}
+	change, err := tx.Commit()
+	if err != nil || change == nil {
+		t.Fatalf("the commit itself succeeded, got %v %v", change, err)
+	}
+	if err := d.FlushHeld(); !errors.Is(err, ErrDuplicateSeqNumber) {
+		t.Fatalf("expected ErrDuplicateSeqNumber, got %v", err)
+	}
+	if err := d.FlushHeld(); err != nil {
+		t.Fatalf("expected the failure to be reported once, got %v", err)
+	}
+}
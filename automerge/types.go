@@ -1,6 +1,10 @@
 package automerge
 
-import "github.com/cjanietz/automerge-native-go/internal/opset"
+import (
+	"github.com/cjanietz/automerge-native-go/internal/model"
+	"github.com/cjanietz/automerge-native-go/internal/opset"
+	inttext "github.com/cjanietz/automerge-native-go/internal/text"
+)
 
 type ObjType = opset.ObjType
 
@@ -9,3 +13,90 @@ const (
 	ObjList = opset.ObjList
 	ObjText = opset.ObjText
 )
+
+type (
+	// ActorID is the raw identity of a change author.
+	ActorID = model.ActorID
+	// ChangeHash identifies a change; a set of them (heads) identifies a
+	// document version.
+	ChangeHash = model.ChangeHash
+	// OpID identifies one operation by counter and actor index.
+	OpID = model.OpID
+	// ObjID identifies a map, list or text object. The root map is RootObjID().
+	ObjID = model.ObjID
+)
+
+func RootObjID() ObjID { return model.RootObjID() }
+
+func ChangeHashFromHex(s string) (ChangeHash, error) { return model.ChangeHashFromHex(s) }
+
+type (
+	ScalarKind  = model.ScalarKind
+	ScalarValue = model.ScalarValue
+)
+
+const (
+	ScalarNull      = model.ScalarNull
+	ScalarBytes     = model.ScalarBytes
+	ScalarString    = model.ScalarString
+	ScalarInt       = model.ScalarInt
+	ScalarUint      = model.ScalarUint
+	ScalarF64       = model.ScalarF64
+	ScalarCounter   = model.ScalarCounter
+	ScalarTimestamp = model.ScalarTimestamp
+	ScalarBoolean   = model.ScalarBoolean
+	ScalarUnknown   = model.ScalarUnknown
+)
+
+func Null() ScalarValue                  { return model.Null() }
+func BytesValue(v []byte) ScalarValue    { return model.BytesValue(v) }
+func StringValue(v string) ScalarValue   { return model.StringValue(v) }
+func IntValue(v int64) ScalarValue       { return model.IntValue(v) }
+func UintValue(v uint64) ScalarValue     { return model.UintValue(v) }
+func F64Value(v float64) ScalarValue     { return model.F64Value(v) }
+func CounterValue(v int64) ScalarValue   { return model.CounterValue(v) }
+func TimestampValue(v int64) ScalarValue { return model.TimestampValue(v) }
+func BoolValue(v bool) ScalarValue       { return model.BoolValue(v) }
+
+type (
+	// Value is a document value: either a scalar or a reference to a child
+	// object, as selected by Kind.
+	Value       = opset.Value
+	ValueKind   = opset.ValueKind
+	ObjectValue = opset.ObjectValue
+	// Mark is a named formatting span over a text object.
+	Mark = opset.Mark
+)
+
+const (
+	ValueScalar = opset.ValueScalar
+	ValueObject = opset.ValueObject
+)
+
+// ExpandMark says whether text inserted at the edges of a mark takes the mark
+// on. The zero value, ExpandAfter, extends a mark to text typed at its end.
+type ExpandMark = opset.ExpandMark
+
+// MarkAnchor is the gap right after or right before a character, named by the
+// OpID of its insert. A zero Elem is the start of the text when After is set,
+// and the end otherwise.
+type MarkAnchor = opset.MarkAnchor
+
+const (
+	ExpandAfter  = opset.ExpandAfter
+	ExpandBefore = opset.ExpandBefore
+	ExpandBoth   = opset.ExpandBoth
+	ExpandNone   = opset.ExpandNone
+)
+
+// Encoding selects how text indexes are counted.
+type Encoding = inttext.Encoding
+
+const (
+	// EncodingUTF8 counts Unicode code points (runes).
+	EncodingUTF8 = inttext.EncodingUTF8
+	// EncodingUTF16 counts UTF-16 code units, as JavaScript strings do.
+	EncodingUTF16 = inttext.EncodingUTF16
+	// EncodingUTF8Bytes counts UTF-8 bytes, as Go string offsets do.
+	EncodingUTF8Bytes = inttext.EncodingUTF8Bytes
+)
@@ -0,0 +1,588 @@
+package automerge
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cjanietz/automerge-native-go/internal/opset"
+)
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+type UndoOptions struct {
+	// CaptureTimeout merges a change into the previous undo step when it is
+	// committed within this long of that step's last change. Zero gives every
+	// change its own step.
+	CaptureTimeout time.Duration
+}
+
+func DefaultUndoOptions() UndoOptions {
+	return UndoOptions{CaptureTimeout: 500 * time.Millisecond}
+}
+
+// UndoManager keeps undo and redo stacks of the local changes one actor makes
+// to a document. Undo commits a new change that reverts the latest step. Only
+// values still as the step left them are restored, so edits merged from other
+// actors in the meantime survive.
+type UndoManager struct {
+	doc   *Document
+	actor uint32
+	opts  UndoOptions
+	now   func() time.Time
+
+	undo []undoStep
+	redo []undoStep
+	last time.Time
+	// group counts open BeginGroup calls, and grouped reports whether the
+	// open group has started its step yet.
+	group   int
+	grouped bool
+	// split makes the next change start a new step.
+	split       bool
+	applying    bool
+	unsubscribe func()
+	// aliases maps each sequence element restored by an undo or redo to the
+	// deleted element it stands for, so older steps still recognise it.
+	aliases map[OpID]OpID
+}
+
+type undoStep struct {
+	changes []ChangeHash
+}
+
+func NewUndoManager(doc *Document, actor uint32) *UndoManager {
+	return NewUndoManagerWithOptions(doc, actor, DefaultUndoOptions())
+}
+
+// NewUndoManagerWithOptions binds a manager to doc that records the changes
+// actor commits locally from now on. Close detaches it.
+func NewUndoManagerWithOptions(doc *Document, actor uint32, opts UndoOptions) *UndoManager {
+	u := &UndoManager{doc: doc, actor: actor, opts: opts, now: time.Now, aliases: map[OpID]OpID{}}
+	u.unsubscribe = doc.subscribe(&subscription{fn: u.record, changesOnly: true})
+	return u
+}
+
+// Close stops recording changes. The stacks stay usable.
+func (u *UndoManager) Close() {
+	u.unsubscribe()
+}
+
+func (u *UndoManager) CanUndo() bool { return len(u.undo) > 0 }
+func (u *UndoManager) CanRedo() bool { return len(u.redo) > 0 }
+
+// BeginGroup collects the changes committed until the matching EndGroup into
+// one undo step. Groups nest; only the outermost one makes a step.
+func (u *UndoManager) BeginGroup() {
+	if u.group == 0 {
+		u.grouped = false
+	}
+	u.group++
+}
+
+func (u *UndoManager) EndGroup() {
+	if u.group == 0 {
+		return
+	}
+	u.group--
+	if u.group == 0 {
+		u.split = true
+	}
+}
+
+// StopCapturing makes the next change start a new undo step even within the
+// capture timeout.
+func (u *UndoManager) StopCapturing() {
+	u.split = true
+}
+
+func (u *UndoManager) record(ev Event) {
+	if ev.Kind != EventLocalChange || u.applying {
+		return
+	}
+	for _, h := range ev.Changes {
+		c, ok := u.doc.changes[h]
+		if !ok || c.Actor != u.actor {
+			continue
+		}
+		u.redo = nil
+		now := u.now()
+		switch {
+		case len(u.undo) > 0 && u.group > 0 && u.grouped,
+			len(u.undo) > 0 && u.group == 0 && !u.split && u.opts.CaptureTimeout > 0 && now.Sub(u.last) < u.opts.CaptureTimeout:
+			top := &u.undo[len(u.undo)-1]
+			top.changes = append(top.changes, h)
+		default:
+			u.undo = append(u.undo, undoStep{changes: []ChangeHash{h}})
+			u.grouped = u.group > 0
+		}
+		u.split = false
+		u.last = now
+	}
+}
+
+// Undo reverts the latest undo step with a new change and makes it available
+// to Redo. It returns a nil change when nothing in the step was left to revert.
+func (u *UndoManager) Undo() (*Change, error) {
+	if len(u.undo) == 0 {
+		return nil, ErrNothingToUndo
+	}
+	change, err := u.revert(u.undo[len(u.undo)-1])
+	if err != nil {
+		return nil, err
+	}
+	u.undo = u.undo[:len(u.undo)-1]
+	if change != nil {
+		u.redo = append(u.redo, undoStep{changes: []ChangeHash{change.Hash}})
+	}
+	u.split = true
+	return change, nil
+}
+
+// Redo reverts the latest Undo with a new change.
+func (u *UndoManager) Redo() (*Change, error) {
+	if len(u.redo) == 0 {
+		return nil, ErrNothingToRedo
+	}
+	change, err := u.revert(u.redo[len(u.redo)-1])
+	if err != nil {
+		return nil, err
+	}
+	u.redo = u.redo[:len(u.redo)-1]
+	if change != nil {
+		u.undo = append(u.undo, undoStep{changes: []ChangeHash{change.Hash}})
+	}
+	u.split = true
+	return change, nil
+}
+
+// revert commits the inverse of the changes of step, latest first.
+func (u *UndoManager) revert(step undoStep) (*Change, error) {
+	tx, err := u.doc.Begin()
+	if err != nil {
+		return nil, err
+	}
+	aliases := maps.Clone(u.aliases)
+	for i := len(step.changes) - 1; i >= 0; i-- {
+		if err := invertChange(tx, u.doc.changes[step.changes[i]], aliases); err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+	u.applying = true
+	defer func() { u.applying = false }()
+	change, err := tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	u.aliases = aliases
+	return change, nil
+}
+
+type mapSlot struct {
+	obj ObjID
+	key string
+}
+
+// seqEdits are the elements a change inserted into and deleted from one
+// sequence. deleted holds the ids of the delete ops.
+type seqEdits struct {
+	inserted []OpID
+	deleted  []OpID
+}
+
+// invertChange adds to tx the mutations that take every value c wrote, and
+// that nobody has overwritten since, back to its value at c's deps. Elements
+// it restores are added to aliases.
+func invertChange(tx *Transaction, c Change, aliases map[OpID]OpID) error {
+	d := tx.doc
+	beforeClk, err := d.clockFromHeads(c.Deps)
+	if err != nil {
+		return err
+	}
+	afterClk, err := d.clockFromHeads([]ChangeHash{c.Hash})
+	if err != nil {
+		return err
+	}
+	before, after := d.ops.ViewAt(beforeClk), d.ops.ViewAt(afterClk)
+	created := func(obj ObjID) bool {
+		return obj.Op.Actor == c.Actor && obj.Op.Counter >= c.StartOp && obj.Op.Counter <= c.MaxOp
+	}
+
+	var slots []mapSlot
+	var incs []ChangeOperation
+	seqs := map[ObjID]*seqEdits{}
+	var seqOrder []ObjID
+	marked := map[ObjID][]string{}
+	seq := func(obj ObjID) *seqEdits {
+		if seqs[obj] == nil {
+			seqs[obj] = &seqEdits{}
+			seqOrder = append(seqOrder, obj)
+		}
+		return seqs[obj]
+	}
+	for _, op := range c.Operations {
+		// Edits inside objects the change created go away with the object.
+		if created(op.ObjID) {
+			continue
+		}
+		switch op.Kind {
+		case OpPut, OpPutObject, OpDeleteMap:
+			slot := mapSlot{obj: op.ObjID, key: op.Key}
+			if !slices.Contains(slots, slot) {
+				slots = append(slots, slot)
+			}
+		case OpIncrement:
+			incs = append(incs, op)
+		case OpInsert, OpInsertObject:
+			s := seq(op.ObjID)
+			s.inserted = append(s.inserted, op.OpID)
+		case OpDeleteList:
+			s := seq(op.ObjID)
+			s.deleted = append(s.deleted, op.OpID)
+		case OpSpliceText:
+			s := seq(op.ObjID)
+			for i := 0; i < op.DeleteCount; i++ {
+				s.deleted = append(s.deleted, OpID{Counter: op.OpID.Counter + uint64(i), Actor: op.OpID.Actor})
+			}
+			for j := range uint64(utf8.RuneCountInString(op.InsertText)) {
+				s.inserted = append(s.inserted, OpID{Counter: op.OpID.Counter + uint64(op.DeleteCount) + j, Actor: op.OpID.Actor})
+			}
+		case OpMark:
+			if !slices.Contains(marked[op.ObjID], op.MarkName) {
+				marked[op.ObjID] = append(marked[op.ObjID], op.MarkName)
+			}
+		}
+	}
+
+	for _, slot := range slots {
+		if err := invertMapSlot(tx, before, after, slot); err != nil {
+			return err
+		}
+	}
+	for i := len(incs) - 1; i >= 0; i-- {
+		op := incs[i]
+		cur, ok := tx.view().GetMapVersion(op.ObjID, op.Key)
+		aft, aftOK := after.GetMapVersion(op.ObjID, op.Key)
+		if ok && aftOK && cur.Value.Scalar.Kind == ScalarCounter && cur.Counter == aft.Counter {
+			if err := tx.Increment(op.ObjID, op.Key, -op.By); err != nil {
+				return err
+			}
+		}
+	}
+	for _, obj := range seqOrder {
+		restored, err := invertSeq(tx, before, obj, seqs[obj], aliases)
+		if err != nil {
+			return err
+		}
+		if typ, _ := tx.view().ObjectType(obj); typ == ObjText {
+			if err := invertMarks(tx, before, after, obj, marked[obj], aliases, restored); err != nil {
+				return err
+			}
+		}
+		delete(marked, obj)
+	}
+	for obj, names := range marked {
+		if err := invertMarks(tx, before, after, obj, names, aliases, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func invertMapSlot(tx *Transaction, before, after *opset.View, slot mapSlot) error {
+	cur, curOK := tx.view().GetMap(slot.obj, slot.key)
+	aft, aftOK := after.GetMap(slot.obj, slot.key)
+	if curOK != aftOK || (curOK && !cur.Equal(aft)) {
+		// Overwritten since, by someone else.
+		return nil
+	}
+	bef, befOK := before.GetMap(slot.obj, slot.key)
+	switch {
+	case befOK == aftOK && (!befOK || bef.Equal(aft)):
+		return nil
+	case !befOK:
+		return tx.DeleteMap(slot.obj, slot.key)
+	}
+	return restoreValue(tx, before, bef,
+		func(v ScalarValue) error { return tx.Put(slot.obj, slot.key, v) },
+		func(typ ObjType) (ObjID, error) { return tx.PutObject(slot.obj, slot.key, typ) })
+}
+
+// origin follows aliases from a restored element back to the element it
+// stands for.
+func origin(aliases map[OpID]OpID, id OpID) OpID {
+	for {
+		o, ok := aliases[id]
+		if !ok {
+			return id
+		}
+		id = o
+	}
+}
+
+// invertSeq deletes the elements edits inserted and puts the ones it deleted
+// back after their nearest surviving predecessor. It returns the elements it
+// restored.
+func invertSeq(tx *Transaction, before *opset.View, obj ObjID, edits *seqEdits, aliases map[OpID]OpID) (map[OpID]bool, error) {
+	typ, ok := tx.view().ObjectType(obj)
+	if !ok {
+		return nil, nil
+	}
+	current := tx.view().ListElements(obj)
+	var drop []int
+	for i, e := range current {
+		if slices.Contains(edits.inserted, origin(aliases, e.ID)) {
+			drop = append(drop, i)
+		}
+	}
+	for i := len(drop) - 1; i >= 0; i-- {
+		// Remove runs of adjacent elements in one go, from the end.
+		end := i
+		for i > 0 && drop[i-1] == drop[i]-1 {
+			i--
+		}
+		if err := deleteRange(tx, obj, typ, drop[i], drop[end]-drop[i]+1); err != nil {
+			return nil, err
+		}
+	}
+
+	targets := tx.doc.ops.ListTargets(edits.deleted)
+	elems := before.ListElements(obj)
+	var restore []int
+	for i, e := range elems {
+		for _, t := range targets {
+			if t == e.ID {
+				restore = append(restore, i)
+				break
+			}
+		}
+	}
+	restored := make(map[OpID]bool, len(restore))
+	find := func(current []opset.ListElement, id OpID) int {
+		id = origin(aliases, id)
+		return slices.IndexFunc(current, func(e opset.ListElement) bool { return origin(aliases, e.ID) == id })
+	}
+	for k := 0; k < len(restore); {
+		pos := restore[k]
+		current := tx.view().ListElements(obj)
+		if find(current, elems[pos].ID) >= 0 {
+			k++
+			continue
+		}
+		index := 0
+		for j := pos - 1; j >= 0; j-- {
+			if at := find(current, elems[j].ID); at >= 0 {
+				index = at + 1
+				break
+			}
+		}
+		if typ == ObjText && !isBlockMarker(elems[pos].Value) {
+			// Restore a run of adjacent deleted characters as one splice.
+			n := 1
+			for k+n < len(restore) && restore[k+n] == pos+n && !isBlockMarker(elems[pos+n].Value) {
+				n++
+			}
+			var text []byte
+			for _, e := range elems[pos : pos+n] {
+				text = append(text, e.Value.Scalar.String...)
+			}
+			first := tx.nextOpIDForNextMutation()
+			if err := tx.spliceText(obj, index, 0, string(text)); err != nil {
+				return nil, err
+			}
+			for i, e := range elems[pos : pos+n] {
+				id := OpID{Counter: first.Counter + uint64(i), Actor: first.Actor}
+				aliases[id] = e.ID
+				restored[id] = true
+			}
+			k += n
+			continue
+		}
+		id := tx.nextOpIDForNextMutation()
+		if err := restoreValue(tx, before, elems[pos].Value,
+			func(v ScalarValue) error { return tx.Insert(obj, index, v) },
+			func(typ ObjType) (ObjID, error) { return tx.InsertObject(obj, index, typ) }); err != nil {
+			return nil, err
+		}
+		aliases[id] = elems[pos].ID
+		restored[id] = true
+		k++
+	}
+	return restored, nil
+}
+
+func deleteRange(tx *Transaction, obj ObjID, typ ObjType, index, n int) error {
+	if typ == ObjText {
+		return tx.spliceText(obj, index, n, "")
+	}
+	for range n {
+		if err := tx.DeleteList(obj, index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invertMarks sets the marks called names back to their value before the
+// change wherever the change altered them and nobody has since, and gives
+// restored characters the marks they had before.
+func invertMarks(tx *Transaction, before, after *opset.View, obj ObjID, names []string, aliases map[OpID]OpID, restored map[OpID]bool) error {
+	if len(restored) > 0 {
+		for _, m := range before.Marks(obj) {
+			if !slices.Contains(names, m.Name) {
+				names = append(names, m.Name)
+			}
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		beforeMarks := markValuesByElem(before, obj, name, aliases)
+		afterMarks := markValuesByElem(after, obj, name, aliases)
+		current := tx.view().ListElements(obj)
+		currentMarks := effectiveMarks(tx.view().Marks(obj), name, len(current))
+
+		// want[i] is the value the i-th current character should get, if any.
+		want := make([]*ScalarValue, len(current))
+		for i, e := range current {
+			cur := markValue(currentMarks[i])
+			id := origin(aliases, e.ID)
+			bef := beforeMarks[id]
+			if restored[e.ID] {
+				if !sameMarkValue(bef, cur) {
+					want[i] = orNull(bef)
+				}
+				continue
+			}
+			aft, ok := afterMarks[id]
+			if ok && !sameMarkValue(bef, aft) && sameMarkValue(cur, aft) {
+				want[i] = orNull(bef)
+			}
+		}
+		for i := 0; i < len(want); {
+			if want[i] == nil {
+				i++
+				continue
+			}
+			j := i + 1
+			for j < len(want) && want[j] != nil && want[j].Equal(*want[i]) {
+				j++
+			}
+			if err := tx.mark(obj, i, j, name, *want[i], ExpandAfter); err != nil {
+				return err
+			}
+			i = j
+		}
+	}
+	return nil
+}
+
+// markValuesByElem maps the origin of each element of obj in view to the
+// value of its effective mark called name, or nil.
+func markValuesByElem(view *opset.View, obj ObjID, name string, aliases map[OpID]OpID) map[OpID]*ScalarValue {
+	elems := view.ListElements(obj)
+	eff := effectiveMarks(view.Marks(obj), name, len(elems))
+	out := make(map[OpID]*ScalarValue, len(elems))
+	for i, e := range elems {
+		out[origin(aliases, e.ID)] = markValue(eff[i])
+	}
+	return out
+}
+
+func markValue(m *Mark) *ScalarValue {
+	if m == nil {
+		return nil
+	}
+	return &m.Value
+}
+
+func sameMarkValue(a, b *ScalarValue) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func orNull(v *ScalarValue) *ScalarValue {
+	if v == nil {
+		null := Null()
+		return &null
+	}
+	return v
+}
+
+// restoreValue writes v as read from view through put, or for an object
+// through putObject followed by a deep copy of its content.
+func restoreValue(tx *Transaction, view *opset.View, v Value, put func(ScalarValue) error, putObject func(ObjType) (ObjID, error)) error {
+	if v.Kind == ValueScalar {
+		return put(v.Scalar)
+	}
+	child, err := putObject(v.Object.Type)
+	if err != nil {
+		return err
+	}
+	src := v.Object.ID
+	switch v.Object.Type {
+	case ObjMap:
+		for _, key := range view.KeysMap(src) {
+			val, _ := view.GetMap(src, key)
+			if err := restoreValue(tx, view, val,
+				func(s ScalarValue) error { return tx.Put(child, key, s) },
+				func(typ ObjType) (ObjID, error) { return tx.PutObject(child, key, typ) }); err != nil {
+				return err
+			}
+		}
+	case ObjList:
+		for i, val := range view.ListRange(src, 0, -1) {
+			if err := restoreValue(tx, view, val,
+				func(s ScalarValue) error { return tx.Insert(child, i, s) },
+				func(typ ObjType) (ObjID, error) { return tx.InsertObject(child, i, typ) }); err != nil {
+				return err
+			}
+		}
+	case ObjText:
+		// Characters are copied in runs, and block markers one by one.
+		var run strings.Builder
+		pos := 0
+		flush := func() error {
+			err := tx.spliceText(child, pos, 0, run.String())
+			pos += utf8.RuneCountInString(run.String())
+			run.Reset()
+			return err
+		}
+		for _, val := range view.ListRange(src, 0, -1) {
+			if !isBlockMarker(val) {
+				run.WriteString(opset.TextOf(val))
+				continue
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+			at := pos
+			if err := restoreValue(tx, view, val, nil,
+				func(typ ObjType) (ObjID, error) { return tx.InsertObject(child, at, typ) }); err != nil {
+				return err
+			}
+			pos++
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		// Marks are re-made in their original order, so that where marks of
+		// the same name overlap the same one still wins.
+		marks := view.Marks(src)
+		slices.SortFunc(marks, func(a, b Mark) int { return a.OpID.Compare(b.OpID) })
+		for _, m := range marks {
+			if err := tx.mark(child, m.Start, m.End, m.Name, m.Value, m.Expand); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
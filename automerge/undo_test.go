@@ -0,0 +1,245 @@
+package automerge
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func commitTx(t *testing.T, d *Document, edit func(tx *Transaction) error) {
+	t.Helper()
+	tx, err := d.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := edit(tx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUndoRedoMapValues(t *testing.T) {
+	d := NewDocument()
+	um := NewUndoManagerWithOptions(d, d.Actor(), UndoOptions{})
+	defer um.Close()
+	root := RootObjID()
+	commitTx(t, d, func(tx *Transaction) error { return tx.Put(root, "title", StringValue("v1")) })
+	commitTx(t, d, func(tx *Transaction) error { return tx.Put(root, "title", StringValue("v2")) })
+
+	title := func() string {
+		v, _ := d.GetMap(root, "title")
+		return v.Scalar.String
+	}
+	if _, err := um.Undo(); err != nil || title() != "v1" {
+		t.Fatalf("unexpected first undo: %q %v", title(), err)
+	}
+	if _, err := um.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.GetMap(root, "title"); ok {
+		t.Fatal("expected the second undo to delete the key")
+	}
+	if _, err := um.Undo(); !errors.Is(err, ErrNothingToUndo) {
+		t.Fatalf("expected ErrNothingToUndo, got %v", err)
+	}
+	if _, err := um.Redo(); err != nil || title() != "v1" {
+		t.Fatalf("unexpected first redo: %q %v", title(), err)
+	}
+	if _, err := um.Redo(); err != nil || title() != "v2" {
+		t.Fatalf("unexpected second redo: %q %v", title(), err)
+	}
+	if um.CanRedo() || !um.CanUndo() {
+		t.Fatal("unexpected stack state after redo")
+	}
+
+	// A new change clears the redo stack.
+	_, _ = um.Undo()
+	commitTx(t, d, func(tx *Transaction) error { return tx.Put(root, "other", IntValue(1)) })
+	if um.CanRedo() {
+		t.Fatal("expected a new change to clear redo")
+	}
+}
+
+func TestUndoKeepsRemoteEdits(t *testing.T) {
+	a := NewDocument()
+	root := RootObjID()
+	var text, list ObjID
+	commitTx(t, a, func(tx *Transaction) error {
+		text, _ = tx.PutObject(root, "text", ObjText)
+		list, _ = tx.PutObject(root, "list", ObjList)
+		_ = tx.Insert(list, 0, StringValue("x"))
+		return tx.SpliceText(text, 0, 0, "hello world")
+	})
+	b, err := a.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := NewUndoManagerWithOptions(a, a.Actor(), UndoOptions{})
+	defer um.Close()
+
+	commitTx(t, a, func(tx *Transaction) error {
+		_ = tx.Put(root, "k", IntValue(1))
+		_ = tx.Put(root, "mine", IntValue(1))
+		_ = tx.DeleteList(list, 0)
+		return tx.SpliceText(text, 6, 5, "there")
+	})
+	commitTx(t, b, func(tx *Transaction) error {
+		_ = tx.Put(root, "b", IntValue(2))
+		return tx.SpliceText(text, 11, 0, " !")
+	})
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	commitTx(t, b, func(tx *Transaction) error { return tx.Put(root, "k", IntValue(2)) })
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Text(text); got != "hello there !" {
+		t.Fatalf("unexpected merged text: %q", got)
+	}
+
+	if _, err := um.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Text(text); got != "hello world !" {
+		t.Fatalf("unexpected text after undo: %q", got)
+	}
+	if v, _ := a.GetMap(root, "k"); v.Scalar.Int != 2 {
+		t.Fatalf("expected the remote overwrite to survive, got %#v", v)
+	}
+	if _, ok := a.GetMap(root, "mine"); ok {
+		t.Fatal("expected the local put to be undone")
+	}
+	if v, _ := a.GetMap(root, "b"); v.Scalar.Int != 2 {
+		t.Fatalf("expected the remote put to survive, got %#v", v)
+	}
+	if vals := a.ListRange(list, 0, -1); len(vals) != 1 || vals[0].Scalar.String != "x" {
+		t.Fatalf("expected the list element to be restored, got %#v", vals)
+	}
+
+	// Peers converge on the undo.
+	if err := b.Merge(a); err != nil {
+		t.Fatal(err)
+	}
+	if b.Text(text) != a.Text(text) {
+		t.Fatalf("peers diverged: %q vs %q", b.Text(text), a.Text(text))
+	}
+}
+
+func TestUndoRestoresObjectsAndMarks(t *testing.T) {
+	d := NewDocument()
+	root := RootObjID()
+	var text, cfg ObjID
+	commitTx(t, d, func(tx *Transaction) error {
+		text, _ = tx.PutObject(root, "text", ObjText)
+		_ = tx.SpliceText(text, 0, 0, "abcdef")
+		_ = tx.Mark(text, 0, 3, "bold", BoolValue(true))
+		cfg, _ = tx.PutObject(root, "cfg", ObjMap)
+		return tx.Put(cfg, "depth", IntValue(2))
+	})
+	um := NewUndoManagerWithOptions(d, d.Actor(), UndoOptions{})
+	defer um.Close()
+
+	commitTx(t, d, func(tx *Transaction) error { return tx.Mark(text, 2, 6, "italic", BoolValue(true)) })
+	commitTx(t, d, func(tx *Transaction) error {
+		_ = tx.SpliceText(text, 1, 2, "")
+		return tx.DeleteMap(root, "cfg")
+	})
+	for range 2 {
+		if _, err := um.Undo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := d.Text(text); got != "abcdef" {
+		t.Fatalf("unexpected text after undo: %q", got)
+	}
+	for i, want := range []bool{true, true, true, false, false, false} {
+		var bold, italic bool
+		for _, m := range d.MarksAtIndex(text, i) {
+			switch {
+			case m.Name == "bold" && m.Value.Kind != ScalarNull:
+				bold = true
+			case m.Name == "italic" && m.Value.Kind != ScalarNull:
+				italic = true
+			}
+		}
+		if bold != want || italic {
+			t.Fatalf("unexpected marks at %d: %#v", i, d.MarksAtIndex(text, i))
+		}
+	}
+	v, ok := d.GetMap(root, "cfg")
+	if !ok || v.Kind != ValueObject {
+		t.Fatalf("expected the map to be restored, got %#v", v)
+	}
+	if depth, _ := d.GetMap(v.Object.ID, "depth"); depth.Scalar.Int != 2 {
+		t.Fatalf("unexpected restored map content: %#v", depth)
+	}
+}
+
+func TestUndoKeepsWinnerOfOverlappingMarks(t *testing.T) {
+	d := NewDocument()
+	root := RootObjID()
+	var text ObjID
+	commitTx(t, d, func(tx *Transaction) error {
+		text, _ = tx.PutObject(root, "text", ObjText)
+		_ = tx.SpliceText(text, 0, 0, "0123456789")
+		// The later, wider mark wins over the earlier one inside it.
+		_ = tx.Mark(text, 2, 4, "b", StringValue("x"))
+		return tx.Mark(text, 0, 10, "b", StringValue("y"))
+	})
+	um := NewUndoManager(d, d.Actor())
+	defer um.Close()
+	commitTx(t, d, func(tx *Transaction) error { return tx.DeleteMap(root, "text") })
+	if _, err := um.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := d.GetMap(root, "text")
+	marks := d.MarksAtIndex(v.Object.ID, 3)
+	if d.Text(v.Object.ID) != "0123456789" || len(marks) != 1 || marks[0].Value.String != "y" {
+		t.Fatalf("unexpected marks after undo: %#v", marks)
+	}
+}
+
+func TestUndoGroupingAndCaptureTimeout(t *testing.T) {
+	d := NewDocument()
+	um := NewUndoManagerWithOptions(d, d.Actor(), UndoOptions{CaptureTimeout: time.Second})
+	defer um.Close()
+	clock := time.Unix(0, 0)
+	um.now = func() time.Time { return clock }
+	root := RootObjID()
+	put := func(key string) {
+		commitTx(t, d, func(tx *Transaction) error { return tx.Put(root, key, IntValue(1)) })
+	}
+
+	put("a")
+	clock = clock.Add(100 * time.Millisecond)
+	put("b")
+	clock = clock.Add(2 * time.Second)
+	put("c")
+	um.StopCapturing()
+	put("d")
+	um.BeginGroup()
+	clock = clock.Add(time.Hour)
+	put("e")
+	clock = clock.Add(time.Hour)
+	put("f")
+	um.EndGroup()
+	put("g")
+
+	steps := [][]string{{"g"}, {"e", "f"}, {"d"}, {"c"}, {"a", "b"}}
+	for _, keys := range steps {
+		if _, err := um.Undo(); err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			if _, ok := d.GetMap(root, k); ok {
+				t.Fatalf("expected %q to be undone with step %v", k, keys)
+			}
+		}
+	}
+	if um.CanUndo() || d.Length(root) != 0 {
+		t.Fatalf("unexpected leftovers: %v", d.Length(root))
+	}
+}
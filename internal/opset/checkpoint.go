@@ -0,0 +1,89 @@
+package opset
+
+import "github.com/cjanietz/automerge-native-go/internal/model"
+
+// Checkpoint marks a position in the op log that Revert can return to and that
+// Replay can start from.
+type Checkpoint struct {
+	ops     int
+	objects int
+}
+
+func (o *OpSet) Checkpoint() Checkpoint {
+	return Checkpoint{ops: len(o.ops), objects: len(o.created)}
+}
+
+// Revert drops every record and object added after cp. The dropped records
+// serve as the undo journal: only the objects they touched are rebuilt, from
+// the records that remain.
+func (o *OpSet) Revert(cp Checkpoint) {
+	if cp.ops >= len(o.ops) && cp.objects >= len(o.created) {
+		return
+	}
+	touched := make(map[model.ObjID]struct{})
+	for _, op := range o.ops[cp.ops:] {
+		touched[op.obj] = struct{}{}
+	}
+	o.ops = o.ops[:cp.ops]
+	for _, id := range o.created[cp.objects:] {
+		delete(o.objects, id)
+		delete(o.current, id)
+		delete(o.parents, id)
+		delete(touched, id)
+	}
+	o.created = o.created[:cp.objects]
+	if len(touched) == 0 {
+		return
+	}
+	for id := range touched {
+		obj := o.objects[id]
+		if obj == nil {
+			continue
+		}
+		st := &objectState{typ: obj.typ}
+		if st.typ == ObjMap {
+			st.m = make(map[string]*listEntry)
+		}
+		o.current[id] = st
+	}
+	for _, op := range o.ops {
+		if _, ok := touched[op.obj]; ok {
+			applyRecord(o.current, op)
+		}
+	}
+}
+
+// Replay steps through the records added after a checkpoint, starting from
+// the state before them, so callers can observe the effect of each one.
+type Replay struct {
+	state map[model.ObjID]*objectState
+	ops   []opRecord
+	pos   int
+}
+
+func (o *OpSet) ReplaySince(cp Checkpoint) *Replay {
+	state, _ := o.materializeWhere(func(op opRecord) bool { return false })
+	r := &Replay{state: state, ops: o.ops}
+	for r.pos < cp.ops {
+		applyRecord(r.state, r.ops[r.pos])
+		r.pos++
+	}
+	return r
+}
+
+// AdvanceTo applies the records up to cp.
+func (r *Replay) AdvanceTo(cp Checkpoint) {
+	for r.pos < cp.ops && r.pos < len(r.ops) {
+		applyRecord(r.state, r.ops[r.pos])
+		r.pos++
+	}
+}
+
+// View reads the replay state. Like OpSet.CurrentView it is not a snapshot.
+func (r *Replay) View() *View {
+	return &View{state: r.state}
+}
+
+func (r *Replay) SnapshotObject(obj model.ObjID) *View {
+	return snapshotObject(r.state, obj)
+}
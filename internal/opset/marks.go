@@ -0,0 +1,238 @@
+package opset
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cjanietz/automerge-native-go/internal/model"
+)
+
+// ExpandMark says whether text inserted at the edges of a mark takes the mark
+// on, as in Peritext. The zero value expands after, like Automerge.
+type ExpandMark uint8
+
+const (
+	ExpandAfter ExpandMark = iota
+	ExpandBefore
+	ExpandBoth
+	ExpandNone
+)
+
+func (e ExpandMark) String() string {
+	switch e {
+	case ExpandAfter:
+		return "after"
+	case ExpandBefore:
+		return "before"
+	case ExpandBoth:
+		return "both"
+	case ExpandNone:
+		return "none"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(e))
+	}
+}
+
+func (e ExpandMark) before() bool { return e == ExpandBefore || e == ExpandBoth }
+func (e ExpandMark) after() bool  { return e == ExpandAfter || e == ExpandBoth }
+
+// MarkAnchor is the gap right after or right before the element Elem. With a
+// zero Elem it is the start of the text when After is set, else the end.
+// Deleted elements stay in the sequence as tombstones, so an anchor keeps its
+// place when its character is deleted, on every peer alike.
+type MarkAnchor struct {
+	Elem  model.OpID
+	After bool
+}
+
+// markState is a mark whose edges follow the characters they are anchored to.
+// The Start and End of its Mark are filled in when it is read.
+type markState struct {
+	mark       Mark
+	start, end MarkAnchor
+}
+
+// markAnchors anchors the range start..end of the visible elements of l. An
+// edge that expands sits in the gap facing outwards, so an insert there lands
+// inside the mark; one that does not sits against the outermost marked
+// character.
+func markAnchors(l []*listEntry, start, end int, expand ExpandMark) (MarkAnchor, MarkAnchor) {
+	elems := make([]model.OpID, 0, len(l))
+	for _, entry := range l {
+		if entry.visible() {
+			elems = append(elems, entry.elem)
+		}
+	}
+	var s, e MarkAnchor
+	switch {
+	case expand.before() && start > 0:
+		s = MarkAnchor{Elem: elems[start-1], After: true}
+	case expand.before():
+		s = MarkAnchor{After: true}
+	case start < len(elems):
+		s = MarkAnchor{Elem: elems[start]}
+	}
+	switch {
+	case expand.after() && end < len(elems):
+		e = MarkAnchor{Elem: elems[end]}
+	case expand.after():
+		e = MarkAnchor{}
+	case end > 0:
+		e = MarkAnchor{Elem: elems[end-1], After: true}
+	default:
+		e = MarkAnchor{After: true}
+	}
+	return s, e
+}
+
+// MarkAnchors returns the anchors AddMark gives a mark over start..end of obj
+// with the policy expand. The range is cut to the visible elements.
+func (v *View) MarkAnchors(obj model.ObjID, start, end int, expand ExpandMark) (MarkAnchor, MarkAnchor) {
+	st := v.state[obj]
+	if st == nil {
+		return MarkAnchor{After: true}, MarkAnchor{After: true}
+	}
+	n := visibleLen(st.l)
+	start, end = min(max(start, 0), n), min(max(end, start, 0), n)
+	return markAnchors(st.l, start, end, expand)
+}
+
+// resolve returns the visible index of the gap a names, or -1 if its element
+// is missing. pos maps elements to their position in the sequence and
+// before[i] counts the visible elements before position i.
+func (a MarkAnchor) resolve(pos map[model.OpID]int, before []int) int {
+	if a.Elem == (model.OpID{}) {
+		if a.After {
+			return 0
+		}
+		return before[len(before)-1]
+	}
+	i, ok := pos[a.Elem]
+	if !ok {
+		return -1
+	}
+	if a.After {
+		return before[i+1]
+	}
+	return before[i]
+}
+
+// insertRef returns the element an insert at the visible index goes after,
+// once the deleted visible elements from index on are gone. Deleted elements
+// between the neighbours of the gap may hold mark edges: the insert goes after
+// the last one anchored after its element, so it lands after every edge that
+// wants text typed there to follow it, and before the edges anchored before
+// the next character.
+func insertRef(st *objectState, index, deleted int) model.OpID {
+	var ref model.OpID
+	i := 0
+	if index > 0 {
+		i = rawIndex(st.l, index-1)
+		ref = st.l[i].elem
+		i++
+	}
+	if len(st.mk) == 0 {
+		return ref
+	}
+	after := make(map[model.OpID]struct{})
+	for _, ms := range st.mk {
+		for _, a := range []MarkAnchor{ms.start, ms.end} {
+			if a.After && a.Elem != (model.OpID{}) {
+				after[a.Elem] = struct{}{}
+			}
+		}
+	}
+	for ; i < len(st.l); i++ {
+		if st.l[i].visible() {
+			if deleted == 0 {
+				break
+			}
+			deleted--
+		}
+		if _, ok := after[st.l[i].elem]; ok {
+			ref = st.l[i].elem
+		}
+	}
+	return ref
+}
+
+// resolveMarks returns the marks of st with their current extent.
+func resolveMarks(st *objectState) []Mark {
+	if len(st.mk) == 0 {
+		return nil
+	}
+	pos := make(map[model.OpID]int, len(st.l))
+	before := make([]int, len(st.l)+1)
+	for i, entry := range st.l {
+		pos[entry.elem] = i
+		before[i+1] = before[i]
+		if entry.visible() {
+			before[i+1]++
+		}
+	}
+	out := make([]Mark, 0, len(st.mk))
+	for _, ms := range st.mk {
+		m := ms.mark
+		m.Start = max(ms.start.resolve(pos, before), 0)
+		m.End = max(ms.end.resolve(pos, before), m.Start)
+		out = append(out, m)
+	}
+	return out
+}
+
+// MarkSpans returns the marks in effect on obj. On each character the mark of
+// a name with the highest OpID wins; a null winner removes the name there.
+// Each result is a run of characters with the same winner, so a mark that was
+// partly removed or overridden comes back split, without the lost range.
+func (v *View) MarkSpans(obj model.ObjID) []Mark {
+	st := v.state[obj]
+	if st == nil || len(st.mk) == 0 {
+		return nil
+	}
+	marks := resolveMarks(st)
+	names := make([]string, 0, len(marks))
+	for _, m := range marks {
+		names = append(names, m.Name)
+	}
+	sort.Strings(names)
+	var out []Mark
+	winner := make([]int, visibleLen(st.l))
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+		for j := range winner {
+			winner[j] = -1
+		}
+		for k, m := range marks {
+			if m.Name != name {
+				continue
+			}
+			for j := m.Start; j < m.End; j++ {
+				if winner[j] < 0 || marks[winner[j]].OpID.Compare(m.OpID) < 0 {
+					winner[j] = k
+				}
+			}
+		}
+		for j := 0; j < len(winner); {
+			k := winner[j]
+			end := j + 1
+			for end < len(winner) && winner[end] == k {
+				end++
+			}
+			if k >= 0 && marks[k].Value.Kind != model.ScalarNull {
+				span := marks[k]
+				span.Start, span.End = j, end
+				out = append(out, span)
+			}
+			j = end
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Start != out[j].Start {
+			return out[i].Start < out[j].Start
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"sort"
-	"strings"
 
 	"github.com/cjanietz/automerge-native-go/internal/changegraph"
 	"github.com/cjanietz/automerge-native-go/internal/model"
@@ -17,14 +16,58 @@ var (
 )
 
 type listEntry struct {
+	// elem is the OpID of the insert that created a sequence element. It stays
+	// fixed when the element is overwritten, unlike the winning version.
+	elem     model.OpID
 	versions []VersionedValue
 }
 
+func (e *listEntry) clone() *listEntry {
+	return &listEntry{elem: e.elem, versions: append([]VersionedValue(nil), e.versions...)}
+}
+
+// visible reports whether the element is in the sequence. A deleted element
+// has no versions left but keeps its place as a tombstone, so inserts after it
+// and mark edges anchored to it still find their position.
+func (e *listEntry) visible() bool { return len(e.versions) > 0 }
+
+func visibleLen(l []*listEntry) int {
+	n := 0
+	for _, entry := range l {
+		if entry.visible() {
+			n++
+		}
+	}
+	return n
+}
+
+// rawIndex returns the position in l of the visible element at index, or -1.
+func rawIndex(l []*listEntry, index int) int {
+	if index < 0 {
+		return -1
+	}
+	for i, entry := range l {
+		if !entry.visible() {
+			continue
+		}
+		if index == 0 {
+			return i
+		}
+		index--
+	}
+	return -1
+}
+
+// visibleIndex counts the visible elements before position raw of l.
+func visibleIndex(l []*listEntry, raw int) int {
+	return visibleLen(l[:raw])
+}
+
 type objectState struct {
 	typ ObjType
 	m   map[string]*listEntry
 	l   []*listEntry
-	mk  []Mark
+	mk  []markState
 }
 
 type opKind uint8
@@ -48,21 +91,45 @@ type opRecord struct {
 	index int
 	value Value
 	pred  []model.OpID
-	start int
-	end   int
 	name  string
+	// expand and the anchors place a mark by the characters it covers.
+	expand      ExpandMark
+	anchorStart MarkAnchor
+	anchorEnd   MarkAnchor
+	// counter is the put that created the counter an increment updates.
+	counter model.OpID
+	// ref names list elements by identity so that replaying a subset of the
+	// ops still finds the right position: the element an insert follows (zero
+	// for the head), or the element a set or delete targets. index is then
+	// only a hint at its position among the elements, tombstones included.
+	ref model.OpID
 }
 
 type OpSet struct {
 	objects map[model.ObjID]*objectState
 	current map[model.ObjID]*objectState
 	ops     []opRecord
+	// created lists objects in creation order, so Revert can drop the ones
+	// made after a checkpoint.
+	created []model.ObjID
+	// parents records where each object was put, so its path can be found
+	// without searching the document.
+	parents map[model.ObjID]Parent
+}
+
+// Parent is the slot an object was put into: a key of the map Obj, or the
+// element Elem of the list or text Obj.
+type Parent struct {
+	Obj  model.ObjID
+	Key  string
+	Elem model.OpID
 }
 
 func New() *OpSet {
 	o := &OpSet{
 		objects: make(map[model.ObjID]*objectState),
 		current: make(map[model.ObjID]*objectState),
+		parents: make(map[model.ObjID]Parent),
 	}
 	root := &objectState{typ: ObjMap, m: make(map[string]*listEntry)}
 	o.objects[model.RootObjID()] = &objectState{typ: ObjMap, m: make(map[string]*listEntry)}
@@ -87,6 +154,7 @@ func (o *OpSet) CreateObject(id model.ObjID, typ ObjType) {
 		st.m = make(map[string]*listEntry)
 	}
 	o.objects[id] = st
+	o.created = append(o.created, id)
 	cur := &objectState{typ: typ}
 	if typ == ObjMap {
 		cur.m = make(map[string]*listEntry)
@@ -116,6 +184,18 @@ func (o *OpSet) PutMapRaw(obj model.ObjID, key string, value Value, id model.OpI
 	return nil
 }
 
+// DeleteMapRaw deletes the versions pred of key, leaving any others visible.
+func (o *OpSet) DeleteMapRaw(obj model.ObjID, key string, id model.OpID, actor uint32, seq uint64, pred []model.OpID) error {
+	if err := o.ensureType(obj, ObjMap); err != nil {
+		return err
+	}
+	cp := append([]model.OpID(nil), pred...)
+	rec := opRecord{kind: opMapDelete, obj: obj, key: key, id: id, actor: actor, seq: seq, pred: cp}
+	o.ops = append(o.ops, rec)
+	o.applyRecordToCurrent(rec)
+	return nil
+}
+
 func (o *OpSet) DeleteMap(obj model.ObjID, key string, id model.OpID, actor uint32, seq uint64) error {
 	if err := o.ensureType(obj, ObjMap); err != nil {
 		return err
@@ -131,24 +211,48 @@ func (o *OpSet) InsertList(obj model.ObjID, index int, value Value, id model.OpI
 	if err := o.ensureType(obj, ObjList, ObjText); err != nil {
 		return err
 	}
-	if index < 0 {
-		return ErrInvalidIndex
-	}
-	rec := opRecord{kind: opListInsert, obj: obj, index: index, value: value, id: id, actor: actor, seq: seq}
+	// Remote inserts may count elements deleted concurrently; they go to the
+	// end. Local callers check the index beforehand.
+	index = min(max(index, 0), o.currentLength(obj))
+	anchor := insertRef(o.current[obj], index, 0)
+	rec := opRecord{kind: opListInsert, obj: obj, index: o.currentIndex(obj, anchor) + 1, value: value, id: id, actor: actor, seq: seq, ref: anchor}
 	o.ops = append(o.ops, rec)
 	o.applyRecordToCurrent(rec)
 	return nil
 }
 
+// InsertListAfter inserts value right after the element anchor, or at the head
+// when anchor is zero, and returns the index it landed at.
+func (o *OpSet) InsertListAfter(obj model.ObjID, anchor model.OpID, value Value, id model.OpID, actor uint32, seq uint64) (int, error) {
+	if err := o.ensureType(obj, ObjList, ObjText); err != nil {
+		return 0, err
+	}
+	pos := -1
+	if anchor != (model.OpID{}) {
+		if pos = o.currentIndex(obj, anchor); pos < 0 {
+			return 0, fmt.Errorf("%w: unknown element %s", ErrInvalidIndex, anchor)
+		}
+	}
+	rec := opRecord{kind: opListInsert, obj: obj, index: pos + 1, value: value, id: id, actor: actor, seq: seq, ref: anchor}
+	o.ops = append(o.ops, rec)
+	o.applyRecordToCurrent(rec)
+	l := o.current[obj].l
+	return visibleIndex(l, findElem(l, id, pos+1)), nil
+}
+
+// SetList overwrites the element at index. An index past the end, as a remote
+// op on elements deleted concurrently may carry, changes nothing; local
+// callers check the index beforehand.
 func (o *OpSet) SetList(obj model.ObjID, index int, value Value, id model.OpID, actor uint32, seq uint64) error {
 	if err := o.ensureType(obj, ObjList, ObjText); err != nil {
 		return err
 	}
-	if index < 0 {
-		return ErrInvalidIndex
+	if index < 0 || index >= o.currentLength(obj) {
+		return nil
 	}
 	pred := o.visibleListVersionIDs(obj, index, nil)
-	rec := opRecord{kind: opListSet, obj: obj, index: index, value: value, id: id, actor: actor, seq: seq, pred: pred}
+	raw, elem := o.currentElem(obj, index)
+	rec := opRecord{kind: opListSet, obj: obj, index: raw, value: value, id: id, actor: actor, seq: seq, pred: pred, ref: elem}
 	o.ops = append(o.ops, rec)
 	o.applyRecordToCurrent(rec)
 	return nil
@@ -162,32 +266,74 @@ func (o *OpSet) SetListRaw(obj model.ObjID, index int, value Value, id model.OpI
 		return ErrInvalidIndex
 	}
 	cp := append([]model.OpID(nil), pred...)
-	rec := opRecord{kind: opListSet, obj: obj, index: index, value: value, id: id, actor: actor, seq: seq, pred: cp}
+	raw, elem := o.currentElem(obj, index)
+	rec := opRecord{kind: opListSet, obj: obj, index: raw, value: value, id: id, actor: actor, seq: seq, pred: cp, ref: elem}
 	o.ops = append(o.ops, rec)
 	o.applyRecordToCurrent(rec)
 	return nil
 }
 
+// DeleteList deletes the element at index. Like SetList, it changes nothing
+// for an index past the end.
 func (o *OpSet) DeleteList(obj model.ObjID, index int, id model.OpID, actor uint32, seq uint64) error {
 	if err := o.ensureType(obj, ObjList, ObjText); err != nil {
 		return err
 	}
-	if index < 0 {
-		return ErrInvalidIndex
+	if index < 0 || index >= o.currentLength(obj) {
+		return nil
 	}
-	rec := opRecord{kind: opListDelete, obj: obj, index: index, id: id, actor: actor, seq: seq}
+	raw, elem := o.currentElem(obj, index)
+	rec := opRecord{kind: opListDelete, obj: obj, index: raw, id: id, actor: actor, seq: seq, ref: elem}
 	o.ops = append(o.ops, rec)
 	o.applyRecordToCurrent(rec)
 	return nil
 }
 
+// DeleteListElem deletes the element elem wherever it currently is and
+// returns its index. Deleting an element that is already deleted changes
+// nothing.
+func (o *OpSet) DeleteListElem(obj model.ObjID, elem model.OpID, id model.OpID, actor uint32, seq uint64) (int, error) {
+	if err := o.ensureType(obj, ObjList, ObjText); err != nil {
+		return 0, err
+	}
+	raw := o.currentIndex(obj, elem)
+	if raw < 0 {
+		return 0, fmt.Errorf("%w: unknown element %s", ErrInvalidIndex, elem)
+	}
+	rec := opRecord{kind: opListDelete, obj: obj, index: raw, id: id, actor: actor, seq: seq, ref: elem}
+	o.ops = append(o.ops, rec)
+	o.applyRecordToCurrent(rec)
+	return visibleIndex(o.current[obj].l, raw), nil
+}
+
+// SetListElemRaw overwrites the element elem, superseding pred, and returns its
+// current index.
+func (o *OpSet) SetListElemRaw(obj model.ObjID, elem model.OpID, value Value, id model.OpID, actor uint32, seq uint64, pred []model.OpID) (int, error) {
+	if err := o.ensureType(obj, ObjList, ObjText); err != nil {
+		return 0, err
+	}
+	raw := o.currentIndex(obj, elem)
+	if raw < 0 {
+		return 0, fmt.Errorf("%w: unknown element %s", ErrInvalidIndex, elem)
+	}
+	cp := append([]model.OpID(nil), pred...)
+	rec := opRecord{kind: opListSet, obj: obj, index: raw, value: value, id: id, actor: actor, seq: seq, pred: cp, ref: elem}
+	o.ops = append(o.ops, rec)
+	o.applyRecordToCurrent(rec)
+	return visibleIndex(o.current[obj].l, raw), nil
+}
+
 func (o *OpSet) IncrementMapCounter(obj model.ObjID, key string, by int64, id model.OpID, actor uint32, seq uint64) error {
-	current, ok := o.GetMap(obj, key, nil)
-	if !ok || current.Kind != ValueScalar || current.Scalar.Kind != model.ScalarCounter {
+	current, ok := o.CurrentView().GetMapVersion(obj, key)
+	if !ok || current.Value.Kind != ValueScalar || current.Value.Scalar.Kind != model.ScalarCounter {
 		return fmt.Errorf("counter not found at key=%s", key)
 	}
-	next := model.CounterValue(current.Scalar.Counter + by)
-	return o.PutMap(obj, key, NewScalarValue(next), id, actor, seq)
+	next := NewScalarValue(model.CounterValue(current.Value.Scalar.Counter + by))
+	pred := o.visibleMapVersionIDs(obj, key, nil)
+	rec := opRecord{kind: opMapPut, obj: obj, key: key, value: next, id: id, actor: actor, seq: seq, pred: pred, counter: current.Counter}
+	o.ops = append(o.ops, rec)
+	o.applyRecordToCurrent(rec)
+	return nil
 }
 
 func (o *OpSet) SpliceText(obj model.ObjID, index int, deleteCount int, insert string, actor uint32, startSeq uint64) (uint64, error) {
@@ -215,23 +361,63 @@ func (o *OpSet) SpliceText(obj model.ObjID, index int, deleteCount int, insert s
 	return seq, nil
 }
 
-func (o *OpSet) AddMark(obj model.ObjID, start int, end int, name string, value model.ScalarValue, id model.OpID, actor uint32, seq uint64) error {
+// SpliceTextAfter deletes the elements deleted and inserts insert right after
+// the element ref, or at the head when ref is zero. Unlike SpliceText it names
+// the characters it acts on, so it applies the same wherever concurrent edits
+// have moved them. Deleting an element that is already deleted changes
+// nothing.
+func (o *OpSet) SpliceTextAfter(obj model.ObjID, deleted []model.OpID, ref model.OpID, insert string, actor uint32, startSeq uint64) (uint64, error) {
+	if err := o.ensureType(obj, ObjText); err != nil {
+		return startSeq, err
+	}
+	seq := startSeq
+	for _, elem := range deleted {
+		seq++
+		if _, err := o.DeleteListElem(obj, elem, model.OpID{Counter: seq, Actor: actor}, actor, seq); err != nil {
+			return seq, err
+		}
+	}
+	for _, r := range insert {
+		seq++
+		id := model.OpID{Counter: seq, Actor: actor}
+		if _, err := o.InsertListAfter(obj, ref, NewScalarValue(model.StringValue(string(r))), id, actor, seq); err != nil {
+			return seq, err
+		}
+		ref = id
+	}
+	return seq, nil
+}
+
+// AddMark marks the characters start..end. The mark is anchored to them, so
+// it follows them through later edits, and expand decides whether text
+// inserted at its edges joins it. A range past the end is cut to the text.
+func (o *OpSet) AddMark(obj model.ObjID, start int, end int, name string, value model.ScalarValue, expand ExpandMark, id model.OpID, actor uint32, seq uint64) error {
 	if err := o.ensureType(obj, ObjText); err != nil {
 		return err
 	}
-	if start < 0 || end < start {
-		return ErrInvalidIndex
+	n := o.currentLength(obj)
+	start, end = min(max(start, 0), n), min(max(end, start, 0), n)
+	anchorStart, anchorEnd := markAnchors(o.current[obj].l, start, end, expand)
+	return o.AddMarkAnchored(obj, anchorStart, anchorEnd, name, value, expand, id, actor, seq)
+}
+
+// AddMarkAnchored adds a mark between the gaps start and end, such as a mark
+// made by a peer that named the characters at its edges.
+func (o *OpSet) AddMarkAnchored(obj model.ObjID, start, end MarkAnchor, name string, value model.ScalarValue, expand ExpandMark, id model.OpID, actor uint32, seq uint64) error {
+	if err := o.ensureType(obj, ObjText); err != nil {
+		return err
 	}
 	rec := opRecord{
-		kind:  opMark,
-		id:    id,
-		actor: actor,
-		seq:   seq,
-		obj:   obj,
-		start: start,
-		end:   end,
-		name:  name,
-		value: NewScalarValue(value),
+		kind:        opMark,
+		id:          id,
+		actor:       actor,
+		seq:         seq,
+		obj:         obj,
+		name:        name,
+		value:       NewScalarValue(value),
+		expand:      expand,
+		anchorStart: start,
+		anchorEnd:   end,
 	}
 	o.ops = append(o.ops, rec)
 	o.applyRecordToCurrent(rec)
@@ -239,222 +425,73 @@ func (o *OpSet) AddMark(obj model.ObjID, start int, end int, name string, value
 }
 
 func (o *OpSet) GetMap(obj model.ObjID, key string, at *changegraph.Clock) (Value, bool) {
-	state, err := o.materialize(at)
-	if err != nil {
-		return Value{}, false
-	}
-	st := state[obj]
-	if st == nil {
-		return Value{}, false
-	}
-	entry := st.m[key]
-	versions := sortedVersions(entry)
-	if len(versions) == 0 {
-		return Value{}, false
-	}
-	return versions[len(versions)-1].Value, true
+	return o.ViewAt(at).GetMap(obj, key)
 }
 
 func (o *OpSet) GetAllMap(obj model.ObjID, key string, at *changegraph.Clock) []Value {
-	state, err := o.materialize(at)
-	if err != nil {
-		return nil
-	}
-	st := state[obj]
-	if st == nil {
-		return nil
-	}
-	entry := st.m[key]
-	versions := sortedVersions(entry)
-	out := make([]Value, 0, len(versions))
-	for _, v := range versions {
-		out = append(out, v.Value)
-	}
-	return out
+	return o.ViewAt(at).GetAllMap(obj, key)
 }
 
 func (o *OpSet) KeysMap(obj model.ObjID, at *changegraph.Clock) []string {
-	state, err := o.materialize(at)
-	if err != nil {
-		return nil
-	}
-	st := state[obj]
-	if st == nil {
-		return nil
-	}
-	keys := make([]string, 0, len(st.m))
-	for k, entry := range st.m {
-		if len(entry.versions) > 0 {
-			keys = append(keys, k)
-		}
-	}
-	sort.Strings(keys)
-	return keys
+	return o.ViewAt(at).KeysMap(obj)
 }
 
 func (o *OpSet) ValuesMap(obj model.ObjID, at *changegraph.Clock) []Value {
-	keys := o.KeysMap(obj, at)
-	out := make([]Value, 0, len(keys))
-	for _, k := range keys {
-		v, ok := o.GetMap(obj, k, at)
-		if ok {
-			out = append(out, v)
-		}
-	}
-	return out
+	return o.ViewAt(at).ValuesMap(obj)
 }
 
 func (o *OpSet) IterMap(obj model.ObjID, at *changegraph.Clock) []struct {
 	Key   string
 	Value Value
 } {
-	keys := o.KeysMap(obj, at)
-	out := make([]struct {
-		Key   string
-		Value Value
-	}, 0, len(keys))
-	for _, k := range keys {
-		v, ok := o.GetMap(obj, k, at)
-		if ok {
-			out = append(out, struct {
-				Key   string
-				Value Value
-			}{Key: k, Value: v})
-		}
-	}
-	return out
+	return o.ViewAt(at).IterMap(obj)
 }
 
 func (o *OpSet) ListLength(obj model.ObjID, at *changegraph.Clock) int {
-	state, err := o.materialize(at)
-	if err != nil {
-		return 0
-	}
-	st := state[obj]
-	if st == nil {
-		return 0
-	}
-	return len(st.l)
+	return o.ViewAt(at).ListLength(obj)
+}
+
+func (o *OpSet) GetList(obj model.ObjID, index int, at *changegraph.Clock) (Value, bool) {
+	return o.ViewAt(at).GetList(obj, index)
+}
+
+func (o *OpSet) GetAllList(obj model.ObjID, index int, at *changegraph.Clock) []Value {
+	return o.ViewAt(at).GetAllList(obj, index)
 }
 
 func (o *OpSet) ListRange(obj model.ObjID, start, end int, at *changegraph.Clock) []Value {
-	state, err := o.materialize(at)
-	if err != nil {
-		return nil
-	}
-	st := state[obj]
-	if st == nil {
-		return nil
-	}
-	list := st.l
-	if start < 0 {
-		start = 0
-	}
-	if end > len(list) || end < 0 {
-		end = len(list)
-	}
-	if start > end {
-		start = end
-	}
-	out := make([]Value, 0, end-start)
-	for i := start; i < end; i++ {
-		versions := sortedVersions(list[i])
-		if len(versions) == 0 {
-			continue
-		}
-		out = append(out, versions[len(versions)-1].Value)
-	}
-	return out
+	return o.ViewAt(at).ListRange(obj, start, end)
 }
 
 func (o *OpSet) Text(obj model.ObjID, at *changegraph.Clock) string {
-	vals := o.ListRange(obj, 0, -1, at)
-	var b strings.Builder
-	for _, v := range vals {
-		if v.Kind == ValueScalar && v.Scalar.Kind == model.ScalarString {
-			b.WriteString(v.Scalar.String)
-		}
-	}
-	return b.String()
+	return o.ViewAt(at).Text(obj)
 }
 
 func (o *OpSet) SequenceElementIDs(obj model.ObjID, at *changegraph.Clock) []model.OpID {
-	state, err := o.materialize(at)
-	if err != nil {
-		return nil
-	}
-	st := state[obj]
-	if st == nil || (st.typ != ObjList && st.typ != ObjText) {
-		return nil
-	}
-	out := make([]model.OpID, 0, len(st.l))
-	for _, entry := range st.l {
-		versions := sortedVersions(entry)
-		if len(versions) == 0 {
-			continue
-		}
-		out = append(out, versions[len(versions)-1].OpID)
-	}
-	return out
+	return o.ViewAt(at).SequenceElementIDs(obj)
 }
 
 func (o *OpSet) Marks(obj model.ObjID, at *changegraph.Clock) []Mark {
-	state, err := o.materialize(at)
-	if err != nil {
-		return nil
-	}
-	st := state[obj]
-	if st == nil {
-		return nil
-	}
-	marks := append([]Mark(nil), st.mk...)
-	sort.Slice(marks, func(i, j int) bool {
-		if marks[i].Start != marks[j].Start {
-			return marks[i].Start < marks[j].Start
-		}
-		if marks[i].End != marks[j].End {
-			return marks[i].End < marks[j].End
-		}
-		return marks[i].OpID.Compare(marks[j].OpID) < 0
-	})
-	return marks
+	return o.ViewAt(at).Marks(obj)
+}
+
+func (o *OpSet) MarkSpans(obj model.ObjID, at *changegraph.Clock) []Mark {
+	return o.ViewAt(at).MarkSpans(obj)
 }
 
 func (o *OpSet) MarksAtIndex(obj model.ObjID, index int, at *changegraph.Clock) []Mark {
-	if index < 0 {
-		return nil
-	}
-	all := o.Marks(obj, at)
-	filtered := make([]Mark, 0, len(all))
-	for _, m := range all {
-		if m.Start <= index && index < m.End {
-			filtered = append(filtered, m)
-		}
-	}
-	if len(filtered) == 0 {
-		return nil
-	}
-	byName := make(map[string]Mark, len(filtered))
-	for _, m := range filtered {
-		curr, ok := byName[m.Name]
-		if !ok || curr.OpID.Compare(m.OpID) < 0 {
-			byName[m.Name] = m
-		}
-	}
-	out := make([]Mark, 0, len(byName))
-	for _, m := range byName {
-		out = append(out, m)
-	}
-	sort.Slice(out, func(i, j int) bool {
-		if out[i].Name != out[j].Name {
-			return out[i].Name < out[j].Name
-		}
-		return out[i].OpID.Compare(out[j].OpID) < 0
-	})
-	return out
+	return o.ViewAt(at).MarksAtIndex(obj, index)
 }
 
 func (o *OpSet) materialize(at *changegraph.Clock) (map[model.ObjID]*objectState, error) {
+	if at == nil {
+		return o.materializeWhere(nil)
+	}
+	return o.materializeWhere(func(op opRecord) bool { return at.Covers(op.actor, op.seq) })
+}
+
+// materializeWhere replays the ops visible reports, or all ops if it is nil.
+func (o *OpSet) materializeWhere(visible func(opRecord) bool) (map[model.ObjID]*objectState, error) {
 	state := make(map[model.ObjID]*objectState, len(o.objects))
 	for id, obj := range o.objects {
 		copyObj := &objectState{typ: obj.typ}
@@ -467,7 +504,7 @@ func (o *OpSet) materialize(at *changegraph.Clock) (map[model.ObjID]*objectState
 	}
 
 	for _, op := range o.ops {
-		if at != nil && !at.Covers(op.actor, op.seq) {
+		if visible != nil && !visible(op) {
 			continue
 		}
 		applyRecord(state, op)
@@ -476,26 +513,24 @@ func (o *OpSet) materialize(at *changegraph.Clock) (map[model.ObjID]*objectState
 }
 
 func (o *OpSet) applyRecordToCurrent(op opRecord) {
-	obj := o.current[op.obj]
-	if obj == nil || obj.m == nil {
-		return
-	}
-	switch op.kind {
-	case opMapPut:
-		entry := obj.m[op.key]
-		if entry == nil {
-			entry = &listEntry{}
-			obj.m[op.key] = entry
+	if op.value.Kind == ValueObject {
+		p := Parent{Obj: op.obj, Key: op.key}
+		switch op.kind {
+		case opListInsert:
+			p.Elem = op.id
+		case opListSet:
+			p.Elem = op.ref
 		}
-		entry.versions = removePreds(entry.versions, op.pred)
-		entry.versions = append(entry.versions, VersionedValue{OpID: op.id, Actor: op.actor, Seq: op.seq, Value: op.value})
-	case opMapDelete:
-		entry := obj.m[op.key]
-		if entry == nil {
-			return
-		}
-		entry.versions = removePreds(entry.versions, op.pred)
+		o.parents[op.value.Object.ID] = p
 	}
+	applyRecord(o.current, op)
+}
+
+// Parent returns the slot obj was put into. Whether it is still there depends
+// on the state read; the root has no parent.
+func (o *OpSet) Parent(obj model.ObjID) (Parent, bool) {
+	p, ok := o.parents[obj]
+	return p, ok
 }
 
 func applyRecord(state map[model.ObjID]*objectState, op opRecord) {
@@ -511,7 +546,7 @@ func applyRecord(state map[model.ObjID]*objectState, op opRecord) {
 			obj.m[op.key] = entry
 		}
 		entry.versions = removePreds(entry.versions, op.pred)
-		entry.versions = append(entry.versions, VersionedValue{OpID: op.id, Actor: op.actor, Seq: op.seq, Value: op.value})
+		entry.versions = append(entry.versions, op.version())
 	case opMapDelete:
 		entry := obj.m[op.key]
 		if entry == nil {
@@ -519,38 +554,124 @@ func applyRecord(state map[model.ObjID]*objectState, op opRecord) {
 		}
 		entry.versions = removePreds(entry.versions, op.pred)
 	case opListInsert:
-		if op.index < 0 || op.index > len(obj.l) {
-			return
+		index := 0
+		if op.ref != (model.OpID{}) {
+			index = min(max(op.index, 0), len(obj.l))
+			if pos := findElem(obj.l, op.ref, op.index-1); pos >= 0 {
+				index = pos + 1
+			}
+		}
+		// Concurrent inserts after the same element are ordered by OpID,
+		// newest first, whatever order they arrive in.
+		for index < len(obj.l) && obj.l[index].elem.Compare(op.id) > 0 {
+			index++
 		}
-		entry := &listEntry{versions: []VersionedValue{{OpID: op.id, Actor: op.actor, Seq: op.seq, Value: op.value}}}
+		entry := &listEntry{elem: op.id, versions: []VersionedValue{op.version()}}
 		obj.l = append(obj.l, nil)
-		copy(obj.l[op.index+1:], obj.l[op.index:])
-		obj.l[op.index] = entry
+		copy(obj.l[index+1:], obj.l[index:])
+		obj.l[index] = entry
 	case opListSet:
-		if op.index < 0 || op.index >= len(obj.l) {
+		index := findElem(obj.l, op.ref, op.index)
+		if op.ref == (model.OpID{}) || index < 0 {
 			return
 		}
-		entry := obj.l[op.index]
+		entry := obj.l[index]
 		entry.versions = removePreds(entry.versions, op.pred)
-		entry.versions = append(entry.versions, VersionedValue{OpID: op.id, Actor: op.actor, Seq: op.seq, Value: op.value})
+		entry.versions = append(entry.versions, op.version())
 	case opListDelete:
-		if op.index < 0 || op.index >= len(obj.l) {
+		index := findElem(obj.l, op.ref, op.index)
+		if op.ref == (model.OpID{}) || index < 0 {
 			return
 		}
-		obj.l = append(obj.l[:op.index], obj.l[op.index+1:]...)
+		obj.l[index].versions = nil
 	case opMark:
-		obj.mk = append(obj.mk, Mark{
-			Start: op.start,
-			End:   op.end,
-			Name:  op.name,
-			Value: op.value.Scalar,
-			OpID:  op.id,
-			Actor: op.actor,
-			Seq:   op.seq,
+		obj.mk = append(obj.mk, markState{
+			mark: Mark{
+				Name:   op.name,
+				Value:  op.value.Scalar,
+				Expand: op.expand,
+				OpID:   op.id,
+				Actor:  op.actor,
+				Seq:    op.seq,
+			},
+			start: op.anchorStart,
+			end:   op.anchorEnd,
 		})
 	}
 }
 
+func (op opRecord) version() VersionedValue {
+	v := VersionedValue{OpID: op.id, Actor: op.actor, Seq: op.seq, Value: op.value, Counter: op.counter}
+	if v.Counter == (model.OpID{}) && op.value.Kind == ValueScalar && op.value.Scalar.Kind == model.ScalarCounter {
+		v.Counter = op.id
+	}
+	return v
+}
+
+// findElem returns the index of the element id in l, or -1. hint is checked
+// first, as a full replay finds every element at its recorded index.
+func findElem(l []*listEntry, id model.OpID, hint int) int {
+	if hint >= 0 && hint < len(l) && l[hint].elem == id {
+		return hint
+	}
+	for i, entry := range l {
+		if entry.elem == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// currentElem returns the position and identity of the visible element at
+// index, or -1 and a zero OpID.
+func (o *OpSet) currentElem(obj model.ObjID, index int) (int, model.OpID) {
+	st := o.current[obj]
+	if st == nil {
+		return -1, model.OpID{}
+	}
+	raw := rawIndex(st.l, index)
+	if raw < 0 {
+		return -1, model.OpID{}
+	}
+	return raw, st.l[raw].elem
+}
+
+// ListTargets returns the element targeted by each list set or delete among
+// ids.
+func (o *OpSet) ListTargets(ids []model.OpID) map[model.OpID]model.OpID {
+	want := make(map[model.OpID]struct{}, len(ids))
+	for _, id := range ids {
+		want[id] = struct{}{}
+	}
+	out := make(map[model.OpID]model.OpID, len(ids))
+	for _, op := range o.ops {
+		if op.kind != opListSet && op.kind != opListDelete {
+			continue
+		}
+		if _, ok := want[op.id]; ok {
+			out[op.id] = op.ref
+		}
+	}
+	return out
+}
+
+func (o *OpSet) currentLength(obj model.ObjID) int {
+	if st := o.current[obj]; st != nil {
+		return visibleLen(st.l)
+	}
+	return 0
+}
+
+// currentIndex returns the position of elem among the elements of obj,
+// tombstones included, or -1.
+func (o *OpSet) currentIndex(obj model.ObjID, elem model.OpID) int {
+	st := o.current[obj]
+	if st == nil {
+		return -1
+	}
+	return findElem(st.l, elem, -1)
+}
+
 func removePreds(in []VersionedValue, pred []model.OpID) []VersionedValue {
 	if len(pred) == 0 {
 		return in
@@ -617,19 +738,22 @@ func (o *OpSet) currentMapEntry(obj model.ObjID, key string) *listEntry {
 }
 
 func (o *OpSet) visibleListVersionIDs(obj model.ObjID, index int, at *changegraph.Clock) []model.OpID {
-	state, err := o.materialize(at)
-	if err != nil {
-		return nil
+	state := o.current
+	if at != nil {
+		var err error
+		if state, err = o.materialize(at); err != nil {
+			return nil
+		}
 	}
 	st := state[obj]
 	if st == nil {
 		return nil
 	}
-	list := st.l
-	if index < 0 || index >= len(list) {
+	raw := rawIndex(st.l, index)
+	if raw < 0 {
 		return nil
 	}
-	versions := sortedVersions(list[index])
+	versions := sortedVersions(st.l[raw])
 	out := make([]model.OpID, 0, len(versions))
 	for _, v := range versions {
 		out = append(out, v.OpID)
@@ -141,7 +141,7 @@ func TestTextMarksAndHistorical(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := op.AddMark(textID, 1, 3, "bold", model.BoolValue(true), model.OpID{Counter: seq + 1, Actor: 1}, 1, seq+1); err != nil {
+	if err := op.AddMark(textID, 1, 3, "bold", model.BoolValue(true), ExpandAfter, model.OpID{Counter: seq + 1, Actor: 1}, 1, seq+1); err != nil {
 		t.Fatal(err)
 	}
 
@@ -173,10 +173,10 @@ func TestMarksAtIndexUsesLatestByName(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := op.AddMark(textID, 1, 3, "bold", model.BoolValue(true), model.OpID{Counter: seq + 1, Actor: 1}, 1, seq+1); err != nil {
+	if err := op.AddMark(textID, 1, 3, "bold", model.BoolValue(true), ExpandAfter, model.OpID{Counter: seq + 1, Actor: 1}, 1, seq+1); err != nil {
 		t.Fatal(err)
 	}
-	if err := op.AddMark(textID, 0, 4, "bold", model.BoolValue(false), model.OpID{Counter: seq + 2, Actor: 1}, 1, seq+2); err != nil {
+	if err := op.AddMark(textID, 0, 4, "bold", model.BoolValue(false), ExpandAfter, model.OpID{Counter: seq + 2, Actor: 1}, 1, seq+2); err != nil {
 		t.Fatal(err)
 	}
 
@@ -189,6 +189,65 @@ func TestMarksAtIndexUsesLatestByName(t *testing.T) {
 	}
 }
 
+func TestMarksFollowTheirCharacters(t *testing.T) {
+	op := New()
+	textID := model.ObjID{Op: model.OpID{Counter: 41, Actor: 1}}
+	op.CreateObject(textID, ObjText)
+
+	seq, err := op.SpliceText(textID, 0, 0, "abcd", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expand := range []ExpandMark{ExpandNone, ExpandBoth} {
+		seq++
+		name := expand.String()
+		if err := op.AddMark(textID, 1, 3, name, model.BoolValue(true), expand, model.OpID{Counter: seq, Actor: 1}, 1, seq); err != nil {
+			t.Fatalf("mark %d: %v", i, err)
+		}
+	}
+	span := func(name string) [2]int {
+		for _, m := range op.Marks(textID, nil) {
+			if m.Name == name {
+				return [2]int{m.Start, m.End}
+			}
+		}
+		return [2]int{-1, -1}
+	}
+	// Typing in front shifts both marks; typing at their edges only grows the
+	// expanding one.
+	if seq, err = op.SpliceText(textID, 0, 0, "x", 1, seq); err != nil {
+		t.Fatal(err)
+	}
+	if seq, err = op.SpliceText(textID, 4, 0, "y", 1, seq); err != nil {
+		t.Fatal(err)
+	}
+	if seq, err = op.SpliceText(textID, 2, 0, "z", 1, seq); err != nil {
+		t.Fatal(err)
+	}
+	if got := op.Text(textID, nil); got != "xazbcyd" {
+		t.Fatalf("unexpected text: %q", got)
+	}
+	if got := span("none"); got != [2]int{3, 5} {
+		t.Fatalf("unexpected non-expanding span: %v", got)
+	}
+	if got := span("both"); got != [2]int{2, 6} {
+		t.Fatalf("unexpected expanding span: %v", got)
+	}
+	// Deleting the marked characters leaves empty marks where they were.
+	if _, err = op.SpliceText(textID, 2, 4, "", 1, seq); err != nil {
+		t.Fatal(err)
+	}
+	if got := span("none"); got != [2]int{2, 2} {
+		t.Fatalf("unexpected span after delete: %v", got)
+	}
+	if got := span("both"); got != [2]int{2, 2} {
+		t.Fatalf("unexpected span after delete: %v", got)
+	}
+	if at := op.MarksAtIndex(textID, 2, nil); len(at) != 0 {
+		t.Fatalf("expected no marks left on d, got %#v", at)
+	}
+}
+
 func TestReadsWithUnknownObjectReturnEmptyResults(t *testing.T) {
 	op := New()
 	unknown := model.ObjID{Op: model.OpID{Counter: 999, Actor: 7}}
@@ -212,3 +271,95 @@ func TestReadsWithUnknownObjectReturnEmptyResults(t *testing.T) {
 		t.Fatalf("expected no marks for unknown object, got %#v", marks)
 	}
 }
+
+func TestSnapshotObjectIsIndependentOfLaterOps(t *testing.T) {
+	op := New()
+	textID := model.ObjID{Op: model.OpID{Counter: 1, Actor: 1}}
+	op.CreateObject(textID, ObjText)
+	seq, _ := op.SpliceText(textID, 0, 0, "abc", 1, 1)
+	_ = op.AddMark(textID, 0, 2, "bold", model.BoolValue(true), ExpandAfter, model.OpID{Counter: seq + 1, Actor: 1}, 1, seq+1)
+
+	snap := op.SnapshotObject(textID)
+	if _, err := op.SpliceText(textID, 1, 1, "xy", 1, seq+1); err != nil {
+		t.Fatal(err)
+	}
+	if got := snap.Text(textID); got != "abc" {
+		t.Fatalf("snapshot changed with later ops: %q", got)
+	}
+	if got, want := op.CurrentView().Text(textID), op.Text(textID, nil); got != want || got != "axyc" {
+		t.Fatalf("current view %q does not match materialized text %q", got, want)
+	}
+	if len(op.CurrentView().Marks(textID)) != 1 || len(snap.Marks(textID)) != 1 {
+		t.Fatal("expected marks in the current view and the snapshot")
+	}
+}
+
+func TestReplayResolvesListOpsByElement(t *testing.T) {
+	op := New()
+	listID := model.ObjID{Op: model.OpID{Counter: 1, Actor: 1}}
+	op.CreateObject(listID, ObjList)
+	_ = op.InsertList(listID, 0, NewScalarValue(model.StringValue("a")), model.OpID{Counter: 2, Actor: 1}, 1, 2)
+	_ = op.InsertList(listID, 1, NewScalarValue(model.StringValue("b")), model.OpID{Counter: 3, Actor: 1}, 1, 3)
+	// Actor 2 inserts at the head; actor 3 then edits around "a" at shifted indexes.
+	_ = op.InsertList(listID, 0, NewScalarValue(model.StringValue("x")), model.OpID{Counter: 4, Actor: 2}, 2, 4)
+	if _, err := op.InsertListAfter(listID, model.OpID{Counter: 2, Actor: 1}, NewScalarValue(model.StringValue("c")), model.OpID{Counter: 5, Actor: 3}, 3, 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := op.DeleteListElem(listID, model.OpID{Counter: 3, Actor: 1}, model.OpID{Counter: 6, Actor: 3}, 3, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	text := func(v *View) string {
+		var out string
+		for _, val := range v.ListRange(listID, 0, -1) {
+			out += val.Scalar.String
+		}
+		return out
+	}
+	if got := text(op.ViewAt(nil)); got != "xac" {
+		t.Fatalf("unexpected current list: %q", got)
+	}
+	clk := changegraph.NewClock()
+	clk.Observe(1, 3)
+	if got := text(op.ViewAtWithOwn(&clk, 3, 5)); got != "ac" {
+		t.Fatalf("unexpected isolated list: %q", got)
+	}
+}
+
+func TestRevertAndReplaySinceCheckpoint(t *testing.T) {
+	op := New()
+	textID := model.ObjID{Op: model.OpID{Counter: 1, Actor: 1}}
+	op.CreateObject(textID, ObjText)
+	seq, _ := op.SpliceText(textID, 0, 0, "abc", 1, 1)
+	cp := op.Checkpoint()
+
+	childID := model.ObjID{Op: model.OpID{Counter: seq + 1, Actor: 1}}
+	op.CreateObject(childID, ObjMap)
+	_ = op.PutMap(childID, "k", NewScalarValue(model.IntValue(1)), model.OpID{Counter: seq + 2, Actor: 1}, 1, seq+2)
+	mid := op.Checkpoint()
+	if _, err := op.SpliceText(textID, 1, 1, "xy", 1, seq+2); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := op.ReplaySince(cp)
+	if got := replay.View().Text(textID); got != "abc" {
+		t.Fatalf("unexpected replay start: %q", got)
+	}
+	before := replay.SnapshotObject(textID)
+	replay.AdvanceTo(mid)
+	if _, ok := replay.View().GetMap(childID, "k"); !ok {
+		t.Fatal("expected the replay to reach the child put")
+	}
+	replay.AdvanceTo(op.Checkpoint())
+	if got := replay.View().Text(textID); got != "axyc" || before.Text(textID) != "abc" {
+		t.Fatalf("unexpected replay end: %q", got)
+	}
+
+	op.Revert(cp)
+	if got := op.CurrentView().Text(textID); got != "abc" {
+		t.Fatalf("unexpected text after revert: %q", got)
+	}
+	if _, ok := op.CurrentView().ObjectType(childID); ok {
+		t.Fatal("expected the child object to be dropped")
+	}
+}
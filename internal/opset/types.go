@@ -93,6 +93,9 @@ type VersionedValue struct {
 	Actor uint32
 	Seq   uint64
 	Value Value
+	// Counter is the OpID of the put that created a counter value. Increments
+	// keep it, so two versions with the same Counter are the same counter.
+	Counter model.OpID
 }
 
 type Mark struct {
@@ -100,7 +103,20 @@ type Mark struct {
 	End   int
 	Name  string
 	Value model.ScalarValue
-	OpID  model.OpID
-	Actor uint32
-	Seq   uint64
+	// Expand is the policy the mark was made with.
+	Expand ExpandMark
+	OpID   model.OpID
+	Actor  uint32
+	Seq    uint64
+}
+
+// ListElement is one visible element of a list or text object.
+type ListElement struct {
+	// ID is the OpID of the insert that created the element.
+	ID model.OpID
+	// Winner is the OpID of the version that provides Value.
+	Winner model.OpID
+	Value  Value
+	// Conflict reports whether concurrent versions lost to Winner.
+	Conflict bool
 }
@@ -0,0 +1,465 @@
+package opset
+
+import (
+	"iter"
+	"sort"
+	"strings"
+
+	"github.com/cjanietz/automerge-native-go/internal/changegraph"
+	"github.com/cjanietz/automerge-native-go/internal/model"
+)
+
+// View is a read-only materialization of an OpSet at a clock. Callers that
+// need several reads against the same state should build one View instead of
+// going through the OpSet read methods, which materialize on every call.
+type View struct {
+	state map[model.ObjID]*objectState
+}
+
+func (o *OpSet) ViewAt(at *changegraph.Clock) *View {
+	state, err := o.materialize(at)
+	if err != nil {
+		return &View{}
+	}
+	return &View{state: state}
+}
+
+// ViewAtWithOwn is ViewAt plus the ops of actor numbered fromOp and above,
+// which is what a transaction isolated at the heads of at sees: the history it
+// builds on and its own pending ops, but none of the ops applied since.
+func (o *OpSet) ViewAtWithOwn(at *changegraph.Clock, actor uint32, fromOp uint64) *View {
+	state, err := o.materializeWhere(func(op opRecord) bool {
+		return at.Covers(op.actor, op.seq) || (op.actor == actor && op.seq >= fromOp)
+	})
+	if err != nil {
+		return &View{}
+	}
+	return &View{state: state}
+}
+
+// CurrentView reads the latest state without materializing it. Unlike ViewAt,
+// the result is not a snapshot: later mutations of the OpSet show through, so
+// it should only be used for reads that finish before the next mutation.
+func (o *OpSet) CurrentView() *View {
+	return &View{state: o.current}
+}
+
+// SnapshotObject copies the latest state of a single object into a View. Other
+// objects are absent from the result.
+func (o *OpSet) SnapshotObject(obj model.ObjID) *View {
+	return snapshotObject(o.current, obj)
+}
+
+func snapshotObject(state map[model.ObjID]*objectState, obj model.ObjID) *View {
+	st := state[obj]
+	if st == nil {
+		return &View{}
+	}
+	cp := &objectState{typ: st.typ, mk: append([]markState(nil), st.mk...)}
+	if st.m != nil {
+		cp.m = make(map[string]*listEntry, len(st.m))
+		for k, entry := range st.m {
+			cp.m[k] = entry.clone()
+		}
+	}
+	if st.l != nil {
+		cp.l = make([]*listEntry, len(st.l))
+		for i, entry := range st.l {
+			cp.l[i] = entry.clone()
+		}
+	}
+	return &View{state: map[model.ObjID]*objectState{obj: cp}}
+}
+
+func (v *View) ObjectType(obj model.ObjID) (ObjType, bool) {
+	st := v.state[obj]
+	if st == nil {
+		return 0, false
+	}
+	return st.typ, true
+}
+
+func (v *View) GetMap(obj model.ObjID, key string) (Value, bool) {
+	w, ok := v.GetMapVersion(obj, key)
+	return w.Value, ok
+}
+
+// GetMapVersion returns the winning version of a map key.
+func (v *View) GetMapVersion(obj model.ObjID, key string) (VersionedValue, bool) {
+	st := v.state[obj]
+	if st == nil {
+		return VersionedValue{}, false
+	}
+	versions := sortedVersions(st.m[key])
+	if len(versions) == 0 {
+		return VersionedValue{}, false
+	}
+	return versions[len(versions)-1], true
+}
+
+// GetAllMapVersions returns every visible version of a map key in OpID order.
+func (v *View) GetAllMapVersions(obj model.ObjID, key string) []VersionedValue {
+	st := v.state[obj]
+	if st == nil {
+		return nil
+	}
+	return sortedVersions(st.m[key])
+}
+
+func (v *View) GetAllMap(obj model.ObjID, key string) []Value {
+	st := v.state[obj]
+	if st == nil {
+		return nil
+	}
+	return versionValues(sortedVersions(st.m[key]))
+}
+
+func (v *View) KeysMap(obj model.ObjID) []string {
+	st := v.state[obj]
+	if st == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(st.m))
+	for k, entry := range st.m {
+		if len(entry.versions) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *View) ValuesMap(obj model.ObjID) []Value {
+	keys := v.KeysMap(obj)
+	out := make([]Value, 0, len(keys))
+	for _, k := range keys {
+		if val, ok := v.GetMap(obj, k); ok {
+			out = append(out, val)
+		}
+	}
+	return out
+}
+
+func (v *View) IterMap(obj model.ObjID) []struct {
+	Key   string
+	Value Value
+} {
+	keys := v.KeysMap(obj)
+	out := make([]struct {
+		Key   string
+		Value Value
+	}, 0, len(keys))
+	for _, k := range keys {
+		if val, ok := v.GetMap(obj, k); ok {
+			out = append(out, struct {
+				Key   string
+				Value Value
+			}{Key: k, Value: val})
+		}
+	}
+	return out
+}
+
+// MapEntries yields the winning value of each visible key in sorted key order.
+// The keys are collected when iteration starts and each value is read when it
+// is reached, so on a view that sees later mutations, such as CurrentView, a
+// key deleted meanwhile is skipped and a key added meanwhile is not yielded.
+func (v *View) MapEntries(obj model.ObjID) iter.Seq2[string, Value] {
+	return func(yield func(string, Value) bool) {
+		for _, k := range v.KeysMap(obj) {
+			val, ok := v.GetMap(obj, k)
+			if !ok {
+				continue
+			}
+			if !yield(k, val) {
+				return
+			}
+		}
+	}
+}
+
+func (v *View) ListLength(obj model.ObjID) int {
+	st := v.state[obj]
+	if st == nil {
+		return 0
+	}
+	return visibleLen(st.l)
+}
+
+func (v *View) GetList(obj model.ObjID, index int) (Value, bool) {
+	st := v.state[obj]
+	if st == nil {
+		return Value{}, false
+	}
+	raw := rawIndex(st.l, index)
+	if raw < 0 {
+		return Value{}, false
+	}
+	versions := sortedVersions(st.l[raw])
+	if len(versions) == 0 {
+		return Value{}, false
+	}
+	return versions[len(versions)-1].Value, true
+}
+
+func (v *View) GetAllList(obj model.ObjID, index int) []Value {
+	st := v.state[obj]
+	if st == nil {
+		return nil
+	}
+	raw := rawIndex(st.l, index)
+	if raw < 0 {
+		return nil
+	}
+	return versionValues(sortedVersions(st.l[raw]))
+}
+
+func (v *View) ListRange(obj model.ObjID, start, end int) []Value {
+	st := v.state[obj]
+	if st == nil {
+		return nil
+	}
+	n := visibleLen(st.l)
+	if start < 0 {
+		start = 0
+	}
+	if end > n || end < 0 {
+		end = n
+	}
+	if start > end {
+		start = end
+	}
+	out := make([]Value, 0, end-start)
+	index := 0
+	for _, entry := range st.l {
+		if index >= end {
+			break
+		}
+		versions := sortedVersions(entry)
+		if len(versions) == 0 {
+			continue
+		}
+		if index >= start {
+			out = append(out, versions[len(versions)-1].Value)
+		}
+		index++
+	}
+	return out
+}
+
+// ListItems yields the winning value of each list or text element with its
+// index. It reads one element at a time. On a view that sees later mutations,
+// such as CurrentView, it carries on after the element it yielded last, so an
+// edit made while iterating shows up only if it lands after that element.
+func (v *View) ListItems(obj model.ObjID) iter.Seq2[int, Value] {
+	return func(yield func(int, Value) bool) {
+		st := v.state[obj]
+		if st == nil {
+			return
+		}
+		index := 0
+		for i := 0; i < len(st.l); i++ {
+			entry := st.l[i]
+			versions := sortedVersions(entry)
+			if len(versions) == 0 {
+				continue
+			}
+			if !yield(index, versions[len(versions)-1].Value) {
+				return
+			}
+			index++
+			if next := v.state[obj]; next != st || i >= len(next.l) || next.l[i].elem != entry.elem {
+				// The sequence changed under the loop: find the element again.
+				if st = next; st == nil {
+					return
+				}
+				if i = findElem(st.l, entry.elem, i); i < 0 {
+					return
+				}
+				index = visibleIndex(st.l, i) + 1
+			}
+		}
+	}
+}
+
+// ListIndex returns the index and winning value of the visible element elem.
+func (v *View) ListIndex(obj model.ObjID, elem model.OpID) (int, Value, bool) {
+	st := v.state[obj]
+	if st == nil {
+		return 0, Value{}, false
+	}
+	index := 0
+	for _, entry := range st.l {
+		versions := sortedVersions(entry)
+		if len(versions) == 0 {
+			continue
+		}
+		if entry.elem == elem {
+			return index, versions[len(versions)-1].Value, true
+		}
+		index++
+	}
+	return 0, Value{}, false
+}
+
+// ListElemIDs returns the identities of the visible elements start..end,
+// clamped to the sequence.
+func (v *View) ListElemIDs(obj model.ObjID, start, end int) []model.OpID {
+	st := v.state[obj]
+	if st == nil {
+		return nil
+	}
+	var out []model.OpID
+	index := 0
+	for _, entry := range st.l {
+		if index >= end {
+			break
+		}
+		if !entry.visible() {
+			continue
+		}
+		if index >= start {
+			out = append(out, entry.elem)
+		}
+		index++
+	}
+	return out
+}
+
+// InsertRef returns the element that text inserted at index goes after, once
+// the deleteCount elements from index are deleted, or a zero OpID for the
+// head. index must be within the sequence.
+func (v *View) InsertRef(obj model.ObjID, index, deleteCount int) model.OpID {
+	st := v.state[obj]
+	if st == nil {
+		return model.OpID{}
+	}
+	return insertRef(st, index, deleteCount)
+}
+
+// ListElements returns the visible elements of a list or text object in order.
+func (v *View) ListElements(obj model.ObjID) []ListElement {
+	st := v.state[obj]
+	if st == nil {
+		return nil
+	}
+	out := make([]ListElement, 0, len(st.l))
+	for _, entry := range st.l {
+		versions := sortedVersions(entry)
+		if len(versions) == 0 {
+			continue
+		}
+		w := versions[len(versions)-1]
+		out = append(out, ListElement{ID: entry.elem, Winner: w.OpID, Value: w.Value, Conflict: len(versions) > 1})
+	}
+	return out
+}
+
+func (v *View) Text(obj model.ObjID) string {
+	vals := v.ListRange(obj, 0, -1)
+	var b strings.Builder
+	for _, val := range vals {
+		b.WriteString(TextOf(val))
+	}
+	return b.String()
+}
+
+// BlockMarker is how a block marker, a map inside a text object, reads in the
+// text.
+const BlockMarker = "\n"
+
+// TextOf returns what a text element holding v adds to the text: its string,
+// BlockMarker for a block marker, and nothing for anything else.
+func TextOf(v Value) string {
+	switch {
+	case v.Kind == ValueScalar && v.Scalar.Kind == model.ScalarString:
+		return v.Scalar.String
+	case v.Kind == ValueObject && v.Object.Type == ObjMap:
+		return BlockMarker
+	default:
+		return ""
+	}
+}
+
+func (v *View) SequenceElementIDs(obj model.ObjID) []model.OpID {
+	st := v.state[obj]
+	if st == nil || (st.typ != ObjList && st.typ != ObjText) {
+		return nil
+	}
+	out := make([]model.OpID, 0, len(st.l))
+	for _, entry := range st.l {
+		versions := sortedVersions(entry)
+		if len(versions) == 0 {
+			continue
+		}
+		out = append(out, versions[len(versions)-1].OpID)
+	}
+	return out
+}
+
+// Marks returns every mark op on obj with its current extent, including
+// overridden and null-valued ones. MarkSpans resolves them.
+func (v *View) Marks(obj model.ObjID) []Mark {
+	st := v.state[obj]
+	if st == nil {
+		return nil
+	}
+	marks := resolveMarks(st)
+	sort.Slice(marks, func(i, j int) bool {
+		if marks[i].Start != marks[j].Start {
+			return marks[i].Start < marks[j].Start
+		}
+		if marks[i].End != marks[j].End {
+			return marks[i].End < marks[j].End
+		}
+		return marks[i].OpID.Compare(marks[j].OpID) < 0
+	})
+	return marks
+}
+
+// MarksAtIndex returns the mark in effect for each name on the character at
+// index: the one with the highest OpID, unless that one removes the name.
+func (v *View) MarksAtIndex(obj model.ObjID, index int) []Mark {
+	if index < 0 {
+		return nil
+	}
+	all := v.Marks(obj)
+	filtered := make([]Mark, 0, len(all))
+	for _, m := range all {
+		if m.Start <= index && index < m.End {
+			filtered = append(filtered, m)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	byName := make(map[string]Mark, len(filtered))
+	for _, m := range filtered {
+		curr, ok := byName[m.Name]
+		if !ok || curr.OpID.Compare(m.OpID) < 0 {
+			byName[m.Name] = m
+		}
+	}
+	out := make([]Mark, 0, len(byName))
+	for _, m := range byName {
+		// A null winner is an unmark: the name is not in effect here.
+		if m.Value.Kind != model.ScalarNull {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].OpID.Compare(out[j].OpID) < 0
+	})
+	return out
+}
+
+func versionValues(versions []VersionedValue) []Value {
+	out := make([]Value, 0, len(versions))
+	for _, v := range versions {
+		out = append(out, v.Value)
+	}
+	return out
+}
@@ -0,0 +1,220 @@
+package text
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Splice deletes Delete runes at the rune index Index and inserts Insert
+// there. Each splice of a script applies to the text left by the previous one.
+type Splice struct {
+	Index  int
+	Delete int
+	Insert string
+}
+
+// DiffSplices returns a shortest splice script turning before into after. It
+// compares grapheme clusters with the Myers algorithm, so no splice starts or
+// ends inside a user-perceived character.
+func DiffSplices(before, after string) []Splice {
+	a, b := Graphemes(before), Graphemes(after)
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	pos := 0
+	for _, g := range a[:pre] {
+		pos += utf8.RuneCountInString(g)
+	}
+
+	var out []Splice
+	var pending Splice
+	var insert strings.Builder
+	flush := func() {
+		if pending.Delete == 0 && insert.Len() == 0 {
+			return
+		}
+		pending.Insert = insert.String()
+		out = append(out, pending)
+		pos += utf8.RuneCountInString(pending.Insert)
+		insert.Reset()
+	}
+	for _, e := range myers(a[pre:len(a)-suf], b[pre:len(b)-suf]) {
+		switch e.kind {
+		case editKeep:
+			flush()
+			pending = Splice{}
+			pos += utf8.RuneCountInString(e.token)
+		case editDelete:
+			if pending.Delete == 0 && insert.Len() == 0 {
+				pending.Index = pos
+			}
+			pending.Delete += utf8.RuneCountInString(e.token)
+		case editInsert:
+			if pending.Delete == 0 && insert.Len() == 0 {
+				pending.Index = pos
+			}
+			insert.WriteString(e.token)
+		}
+	}
+	flush()
+	return out
+}
+
+// Graphemes splits s at the starts reported by GraphemeStarts.
+func Graphemes(s string) []string {
+	if s == "" {
+		return nil
+	}
+	runes := []rune(s)
+	starts := GraphemeStarts(s)
+	out := make([]string, len(starts))
+	for i, st := range starts {
+		end := len(runes)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		out[i] = string(runes[st:end])
+	}
+	return out
+}
+
+type editKind uint8
+
+const (
+	editKeep editKind = iota
+	editDelete
+	editInsert
+)
+
+type edit struct {
+	kind  editKind
+	token string
+}
+
+// myers computes a shortest edit script from a to b in O((n+m)d) time and
+// O(n+m) space, splitting the problem at the middle snake of an optimal path
+// as in Myers' linear-space refinement.
+func myers(a, b []string) []edit {
+	return diffInto(nil, a, b)
+}
+
+// diffInto appends a shortest edit script from a to b to out.
+func diffInto(out []edit, a, b []string) []edit {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	for _, t := range a[:pre] {
+		out = append(out, edit{kind: editKeep, token: t})
+	}
+	a, b = a[pre:], b[pre:]
+	suf := 0
+	for suf < len(a) && suf < len(b) && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	common := a[len(a)-suf:]
+	a, b = a[:len(a)-suf], b[:len(b)-suf]
+
+	switch {
+	case len(a) == 0 || len(b) == 0 || (len(a) == 1 && len(b) == 1):
+		// Nothing is left in common: delete what remains of a, insert b.
+		for _, t := range a {
+			out = append(out, edit{kind: editDelete, token: t})
+		}
+		for _, t := range b {
+			out = append(out, edit{kind: editInsert, token: t})
+		}
+	default:
+		x, y := middleSnake(a, b)
+		out = diffInto(out, a[:x], b[:y])
+		out = diffInto(out, a[x:], b[y:])
+	}
+	for _, t := range common {
+		out = append(out, edit{kind: editKeep, token: t})
+	}
+	return out
+}
+
+// middleSnake runs the search for an optimal path from both ends of a and b
+// at once and returns a point on it where the two searches meet. a and b must
+// differ in their first and last tokens.
+func middleSnake(a, b []string) (int, int) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	off := maxD
+	// fwd[off+k] is the furthest x reached on diagonal k from the start, and
+	// rev[off+k] the furthest distance from the end on diagonal k counted
+	// backwards; -1 marks diagonals not reached yet.
+	fwd := make([]int, 2*maxD+2)
+	rev := make([]int, 2*maxD+2)
+	for i := range fwd {
+		fwd[i], rev[i] = -1, -1
+	}
+	fwd[off+1], rev[off+1] = 0, 0
+	delta := n - m
+	// With an odd delta the paths meet on a forward step, else on a reverse
+	// one.
+	odd := delta%2 != 0
+	// The k bounds skip diagonals that ran off the edit graph.
+	var fStart, fEnd, rStart, rEnd int
+	for d := 0; d < maxD; d++ {
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && fwd[off+k-1] < fwd[off+k+1]) {
+				x = fwd[off+k+1]
+			} else {
+				x = fwd[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			fwd[off+k] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case odd:
+				if rk := off + delta - k; rk >= 0 && rk < len(rev) && rev[rk] != -1 && x >= n-rev[rk] {
+					return x, y
+				}
+			}
+		}
+		for k := -d + rStart; k <= d-rEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && rev[off+k-1] < rev[off+k+1]) {
+				x = rev[off+k+1]
+			} else {
+				x = rev[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			rev[off+k] = x
+			switch {
+			case x > n:
+				rEnd += 2
+			case y > m:
+				rStart += 2
+			case !odd:
+				if fk := off + delta - k; fk >= 0 && fk < len(fwd) && fwd[fk] != -1 {
+					fx := fwd[fk]
+					if fx >= n-x {
+						return fx, off + fx - fk
+					}
+				}
+			}
+		}
+	}
+	// Unreachable for inputs that differ: the searches meet by d = maxD.
+	return n, 0
+}
@@ -0,0 +1,124 @@
+package text
+
+import (
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func applySplices(s string, splices []Splice) string {
+	runes := []rune(s)
+	for _, sp := range splices {
+		out := append([]rune(nil), runes[:sp.Index]...)
+		out = append(out, []rune(sp.Insert)...)
+		runes = append(out, runes[sp.Index+sp.Delete:]...)
+	}
+	return string(runes)
+}
+
+func TestDiffSplicesRoundTrip(t *testing.T) {
+	cases := []struct{ before, after string }{
+		{"", ""},
+		{"", "abc"},
+		{"abc", ""},
+		{"kitten", "sitting"},
+		{"hello world", "hello brave new world"},
+		{"abcabba", "cbabac"},
+		{"a😀b", "a😀😀b"},
+	}
+	for _, c := range cases {
+		got := applySplices(c.before, DiffSplices(c.before, c.after))
+		if got != c.after {
+			t.Fatalf("%q -> %q: splices produced %q", c.before, c.after, got)
+		}
+	}
+}
+
+func TestDiffSplicesIsMinimal(t *testing.T) {
+	splices := DiffSplices("hello world", "hello brave world")
+	if len(splices) != 1 || splices[0] != (Splice{Index: 6, Insert: "brave "}) {
+		t.Fatalf("unexpected splices: %#v", splices)
+	}
+	// Myers finds the 5-edit script for the classic example.
+	edits := 0
+	for _, e := range myers(Graphemes("abcabba"), Graphemes("cbabac")) {
+		if e.kind != editKeep {
+			edits++
+		}
+	}
+	if edits != 5 {
+		t.Fatalf("expected 5 edits, got %d", edits)
+	}
+}
+
+func TestDiffSplicesKeepsGraphemesWhole(t *testing.T) {
+	// Adding an accent to the e replaces the whole cluster, not just the mark.
+	splices := DiffSplices("cafe", "cafe\u0301")
+	if len(splices) != 1 || splices[0] != (Splice{Index: 3, Delete: 1, Insert: "e\u0301"}) {
+		t.Fatalf("unexpected splices: %#v", splices)
+	}
+}
+
+// lcsEdits counts the inserts and deletes of a shortest script by dynamic
+// programming, to check myers against.
+func lcsEdits(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else {
+				dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+			}
+		}
+	}
+	return len(a) + len(b) - 2*dp[0][0]
+}
+
+func TestMyersMatchesLCSOnRandomInputs(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func() string {
+		var b strings.Builder
+		for range rng.Intn(20) {
+			b.WriteByte("abc"[rng.Intn(3)])
+		}
+		return b.String()
+	}
+	for range 500 {
+		before, after := random(), random()
+		a, b := Graphemes(before), Graphemes(after)
+		edits := 0
+		for _, e := range myers(a, b) {
+			if e.kind != editKeep {
+				edits++
+			}
+		}
+		if want := lcsEdits(a, b); edits != want {
+			t.Fatalf("%q -> %q: %d edits, want %d", before, after, edits, want)
+		}
+		if got := applySplices(before, DiffSplices(before, after)); got != after {
+			t.Fatalf("%q -> %q: splices produced %q", before, after, got)
+		}
+	}
+}
+
+func TestDiffSplicesLargeReplacementUsesLinearSpace(t *testing.T) {
+	before := strings.Repeat("ab", 2000)
+	after := strings.Repeat("cd", 2000)
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	start := ms.TotalAlloc
+	splices := DiffSplices(before, after)
+	runtime.ReadMemStats(&ms)
+	if got := applySplices(before, splices); got != after {
+		t.Fatal("large replacement did not round trip")
+	}
+	// A trace of every d would take about 1GB here.
+	if alloc := ms.TotalAlloc - start; alloc > 32<<20 {
+		t.Fatalf("diffing 4k graphemes allocated %d bytes", alloc)
+	}
+}
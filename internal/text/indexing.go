@@ -9,8 +9,9 @@ import (
 type Encoding uint8
 
 const (
-	EncodingUTF8  Encoding = iota // rune index
-	EncodingUTF16                 // utf-16 code unit index
+	EncodingUTF8      Encoding = iota // rune index
+	EncodingUTF16                     // utf-16 code unit index
+	EncodingUTF8Bytes                 // utf-8 byte offset
 )
 
 func RuneCount(s string) int {
@@ -21,6 +22,48 @@ func UTF16CodeUnitCount(s string) int {
 	return len(utf16.Encode([]rune(s)))
 }
 
+// Length returns the length of s counted in enc units.
+func Length(s string, enc Encoding) int {
+	switch enc {
+	case EncodingUTF16:
+		return UTF16CodeUnitCount(s)
+	case EncodingUTF8Bytes:
+		return len(s)
+	default:
+		return RuneCount(s)
+	}
+}
+
+func RuneIndexToByte(s string, runeIndex int) int {
+	if runeIndex <= 0 {
+		return 0
+	}
+	n := 0
+	for i := range s {
+		if n == runeIndex {
+			return i
+		}
+		n++
+	}
+	return len(s)
+}
+
+// ByteIndexToRune maps a byte offset to a rune index, rounding offsets inside
+// a multi-byte sequence up to the next rune boundary.
+func ByteIndexToRune(s string, byteIndex int) int {
+	if byteIndex <= 0 {
+		return 0
+	}
+	n := 0
+	for i := range s {
+		if i >= byteIndex {
+			return n
+		}
+		n++
+	}
+	return n
+}
+
 func RuneIndexToUTF16(s string, runeIndex int) int {
 	if runeIndex <= 0 {
 		return 0
@@ -62,6 +105,11 @@ func NormalizeIndexForEncoding(s string, index int, enc Encoding) int {
 			return max
 		}
 		return index
+	case EncodingUTF8Bytes:
+		if index > len(s) {
+			return len(s)
+		}
+		return index
 	default:
 		max := RuneCount(s)
 		if index > max {
@@ -76,13 +124,21 @@ func ConvertIndex(s string, index int, from Encoding, to Encoding) int {
 	if from == to {
 		return norm
 	}
-	if from == EncodingUTF16 && to == EncodingUTF8 {
-		return UTF16IndexToRune(s, norm)
+	runeIndex := norm
+	switch from {
+	case EncodingUTF16:
+		runeIndex = UTF16IndexToRune(s, norm)
+	case EncodingUTF8Bytes:
+		runeIndex = ByteIndexToRune(s, norm)
 	}
-	if from == EncodingUTF8 && to == EncodingUTF16 {
-		return RuneIndexToUTF16(s, norm)
+	switch to {
+	case EncodingUTF16:
+		return RuneIndexToUTF16(s, runeIndex)
+	case EncodingUTF8Bytes:
+		return RuneIndexToByte(s, runeIndex)
+	default:
+		return runeIndex
 	}
-	return norm
 }
 
 func ClampToGraphemeStart(s string, runeIndex int) int {
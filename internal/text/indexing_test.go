@@ -41,3 +41,19 @@ func TestConvertIndexAndClamp(t *testing.T) {
 		t.Fatalf("expected clamp to start of combining grapheme, got %d", got)
 	}
 }
+
+func TestUTF8ByteIndexConversion(t *testing.T) {
+	s := "a😀é"
+	if got := Length(s, EncodingUTF8Bytes); got != 7 {
+		t.Fatalf("byte length mismatch: %d", got)
+	}
+	if got := ConvertIndex(s, 2, EncodingUTF8, EncodingUTF8Bytes); got != 5 {
+		t.Fatalf("rune->byte mismatch: %d", got)
+	}
+	if got := ConvertIndex(s, 3, EncodingUTF8Bytes, EncodingUTF16); got != 3 {
+		t.Fatalf("byte inside rune should round up, got %d", got)
+	}
+	if got := ConvertIndex(s, 3, EncodingUTF16, EncodingUTF8Bytes); got != 5 {
+		t.Fatalf("utf16->byte mismatch: %d", got)
+	}
+}